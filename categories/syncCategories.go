@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+type JobCreated struct {
+	JobId  string `json:"job_id"`
+	Status string `json:"status"`
+}
+
+// SyncCategories enqueues the migration of the magento categories tree, it must run before the
+// products so they are created on their categories. The progress and results are exposed by GET /jobs/{id}
+func SyncCategories(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bodyRequest := services.BodyRequest{}
+
+	err := json.Unmarshal([]byte(request.Body), &bodyRequest)
+	if err != nil {
+		fmt.Println("Error destructuring the body of the request on SyncCategories function : ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
+	}
+
+	if bodyRequest.DryRun {
+		return events.APIGatewayProxyResponse{Body: "dry_run is only supported on POST /users with a list of users", StatusCode: http.StatusBadRequest}, nil
+	}
+
+	job, err := services.CreateJob(services.CategoriesJob, bodyRequest)
+	if err != nil {
+		fmt.Println("Error returned by CreateJob function: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	marshaledResult, err := json.Marshal(JobCreated{JobId: job.Id, Status: job.Status})
+	if err != nil {
+		fmt.Println("Error on marshal job: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusAccepted}, nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(SyncCategories)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+// categoriesTree is the magento tree Root Catalog > Default Category > (Decoración, Muebles > Mesas)
+func categoriesTree() services.MagentoCategory {
+	return services.MagentoCategory{Id: 1, Name: "Root Catalog", IsActive: true, Level: 0, Children: []services.MagentoCategory{
+		{Id: 2, ParentId: 1, Name: "Default Category", IsActive: true, Level: 1, Children: []services.MagentoCategory{
+			{Id: 3, ParentId: 2, Name: "Muebles", IsActive: true, Position: 2, Level: 2, Children: []services.MagentoCategory{
+				{Id: 5, ParentId: 3, Name: "Mesas", IsActive: true, Position: 1, Level: 3},
+			}},
+			{Id: 4, ParentId: 2, Name: "Decoración", IsActive: false, Position: 1, Level: 2},
+		}},
+	}}
+}
+
+func TestSyncCategoriesCreatesTreeTopDown(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.SetCategories(categoriesTree())
+
+	job := env.RunJob(t, SyncCategories, `{}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "4", ResponseCode: 1},
+		services.BodyResult{Reference: "3", ResponseCode: 1},
+		services.BodyResult{Reference: "5", ResponseCode: 1},
+	)
+	if job.Type != services.CategoriesJob || job.Total != 3 || job.Results[0].Entity != "category" {
+		t.Errorf("job = %+v, want a categories job of 3 categories", job)
+	}
+
+	categories := env.CSCart.Categories()
+	expected := map[int]services.GamaCategory{
+		1: {Category: "Decoración", ParentId: 0, Position: 1, Status: "D"},
+		2: {Category: "Muebles", ParentId: 0, Position: 2, Status: "A"},
+		3: {Category: "Mesas", ParentId: 2, Position: 1, Status: "A"},
+	}
+	for id, category := range expected {
+		if categories[id] != category {
+			t.Errorf("CS-Cart category %d = %+v, want %+v", id, categories[id], category)
+		}
+	}
+
+	mapping, err := env.Store.GetCategory("5")
+	if err != nil || !mapping.Result || mapping.GamaId != 3 || mapping.ParentId != 3 {
+		t.Errorf("category mapping = %+v, %v", mapping, err)
+	}
+}
+
+func TestSyncCategoriesSkipsMigratedCategoriesUnlessForced(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.SetCategories(categoriesTree())
+
+	env.RunJob(t, SyncCategories, `{}`)
+	job := env.RunJob(t, SyncCategories, `{}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "4", ResponseCode: 1, Reason: "category already migrated with id 1"},
+		services.BodyResult{Reference: "3", ResponseCode: 1, Reason: "category already migrated with id 2"},
+		services.BodyResult{Reference: "5", ResponseCode: 1, Reason: "category already migrated with id 3"},
+	)
+	if len(env.CSCart.RequestsTo(http.MethodPost, "api/categories")) != 3 {
+		t.Errorf("migrated categories were created again")
+	}
+
+	job = env.RunJob(t, SyncCategories, `{"force": true}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "4", ResponseCode: 2},
+		services.BodyResult{Reference: "3", ResponseCode: 2},
+		services.BodyResult{Reference: "5", ResponseCode: 2},
+	)
+	if len(env.CSCart.RequestsTo(http.MethodPut, "api/categories/3")) != 1 || len(env.CSCart.Categories()) != 3 {
+		t.Errorf("forced categories were not updated: %+v", env.CSCart.Requests())
+	}
+}
+
+func TestSyncCategoriesFailsChildrenOfFailedParent(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.SetCategories(categoriesTree())
+	env.CSCart.Fail(http.MethodPost, "api/categories", 0, http.StatusInternalServerError)
+
+	job := env.RunJob(t, SyncCategories, `{}`)
+
+	if len(job.Results) != 3 {
+		t.Fatalf("job results = %+v, want 3", job.Results)
+	}
+	if job.Results[1].ResponseCode != 3 {
+		t.Errorf("result of the failed category = %+v", job.Results[1])
+	}
+	if job.Results[2].ResponseCode != 3 || job.Results[2].Reason != "parent category 3 was not migrated" {
+		t.Errorf("result of the child of the failed category = %+v", job.Results[2])
+	}
+
+	job = env.RunJob(t, SyncCategories, `{}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "4", ResponseCode: 1, Reason: "category already migrated with id 1"},
+		services.BodyResult{Reference: "3", ResponseCode: 1},
+		services.BodyResult{Reference: "5", ResponseCode: 1},
+	)
+}
+
+func TestSyncCategoriesResumesByCategoryId(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.SetCategories(categoriesTree())
+	env.RunJob(t, SyncCategories, `{}`)
+
+	// a job stopped after Decoración and Muebles, Sillas was added before Decoración meanwhile
+	tree := categoriesTree()
+	defaultCategory := &tree.Children[0]
+	defaultCategory.Children = append(defaultCategory.Children, services.MagentoCategory{Id: 6, ParentId: 2, Name: "Sillas", IsActive: true, Position: 0, Level: 2})
+	env.Magento.SetCategories(tree)
+	job := services.Job{
+		Id:         "resumed-categories",
+		Type:       services.CategoriesJob,
+		Status:     services.JobQueued,
+		Processed:  2,
+		Results:    []services.BodyResult{},
+		Checkpoint: &services.Checkpoint{Id: services.CategoriesJob, ProcessedIds: []int{4, 3}, Processed: 2},
+	}
+	if err := env.Store.SaveJob(job); err != nil {
+		t.Fatalf("SaveJob: %v", err)
+	}
+
+	if err := services.ProcessJob(context.Background(), job.Id); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	job, err := env.Store.GetJob(job.Id)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "6", ResponseCode: 1},
+		services.BodyResult{Reference: "5", ResponseCode: 1, Reason: "category already migrated with id 3"},
+	)
+	if job.Total != 4 || job.Processed != 4 || len(env.CSCart.Categories()) != 4 {
+		t.Errorf("job total/processed = %d/%d, categories = %+v", job.Total, job.Processed, env.CSCart.Categories())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+// DeltaRequest is the input of the scheduled event, since is optional and uses magento date format (UTC)
+type DeltaRequest struct {
+	Since string `json:"since"`
+}
+
+type DeltaResults struct {
+	Results    []services.BodyResult `json:"results"`
+	Checkpoint services.Checkpoint   `json:"checkpoint"`
+}
+
+func SyncDelta(ctx context.Context, request DeltaRequest) (DeltaResults, error) {
+	results, checkpoint, err := services.SyncUpdatedUsers(ctx, request.Since)
+	if err != nil {
+		fmt.Println("Error returned by SyncUpdatedUsers function: ", err.Error())
+		return DeltaResults{}, err
+	}
+
+	fmt.Println("Delta sync processed: " + strconv.Itoa(checkpoint.Processed) + " | high water mark: " + checkpoint.HighWaterMark)
+
+	return DeltaResults{Results: results, Checkpoint: checkpoint}, nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(SyncDelta)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+func TestSyncDeltaUpdatesOnlyMigratedUsers(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.AddCustomer(services.MagentoUser{Email: "maria.valencia@example.com", Firstname: "Maria", Lastname: "Valencia", GroupId: 1, UpdatedAt: "2021-02-01 10:00:00"})
+	if results, err := services.SyncUser(services.User{Email: "maria.valencia@example.com"}, false, false); err != nil || results[0].ResponseCode != 1 {
+		t.Fatalf("SyncUser = %+v, %v", results, err)
+	}
+	env.Magento.AddCustomer(services.MagentoUser{Email: "zahit.rios@example.com", Firstname: "Zahit", Lastname: "Rios", GroupId: 1, UpdatedAt: "2021-02-02 10:00:00"})
+
+	response, err := SyncDelta(context.Background(), DeltaRequest{Since: "2021-01-31 00:00:00"})
+	if err != nil {
+		t.Fatalf("SyncDelta: %v", err)
+	}
+
+	if len(response.Results) != 2 || response.Results[0].Email != "maria.valencia@example.com" || response.Results[0].ResponseCode == 3 {
+		t.Fatalf("results = %+v, want the migrated user updated", response.Results)
+	}
+	if response.Results[1].Email != "zahit.rios@example.com" || response.Results[1].ResponseCode != 5 || response.Results[1].Reason != "the user was never migrated, the delta only updates migrated users" {
+		t.Errorf("result of the new customer = %+v, want it skipped", response.Results[1])
+	}
+	if _, ok := env.CSCart.UserByEmail("zahit.rios@example.com"); ok {
+		t.Error("the delta created a user that was never migrated")
+	}
+	if response.Checkpoint.Skipped != 1 || response.Checkpoint.HighWaterMark != "2021-02-02 10:00:00" {
+		t.Errorf("checkpoint = %+v", response.Checkpoint)
+	}
+}
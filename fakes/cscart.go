@@ -0,0 +1,841 @@
+package fakes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	services "migration-m2-gama/services"
+)
+
+const (
+	CSCartUser     = "admin@example.com"
+	CSCartPassword = "api-key"
+)
+
+// CSCartServer serves the api/users, api/profiles, api/profile_fields, api/usergroups, api/states, api/orders, api/products, api/categories,
+// api/features and api/product_variations_groups endpoints of GAMA keeping the data in memory
+type CSCartServer struct {
+	*httptest.Server
+	failures
+
+	mu            sync.Mutex
+	users         []services.GamaUser
+	profiles      map[int]CSCartProfile
+	lastProfileId int
+	orders        map[int]services.GamaOrder
+	products      map[int]services.GamaProduct
+	images        map[int]CSCartImages
+	categories    map[int]services.GamaCategory
+	features      map[int]services.GamaFeatureResult
+	lastVariantId int
+	groups        map[int]services.GamaVariationGroup
+	usergroups    map[int]services.GamaUsergroup
+	members       map[string]map[int]string // status of the usergroups by user_id
+	states        []services.GamaState
+	profileFields []services.GamaProfileField
+	requests      []Request
+}
+
+// CSCartProfile is a profile saved on the fake with the id of its user
+type CSCartProfile struct {
+	UserId  string
+	Main    bool
+	Profile services.Profile
+}
+
+// CSCartImages are the images uploaded to a product, the additional ones in upload order
+type CSCartImages struct {
+	Main       *services.GamaImagePair
+	Additional []services.GamaImagePair
+}
+
+// NewCSCartServer starts the fake, close it when the test ends
+func NewCSCartServer() *CSCartServer {
+	s := &CSCartServer{
+		profiles:   make(map[int]CSCartProfile),
+		orders:     make(map[int]services.GamaOrder),
+		products:   make(map[int]services.GamaProduct),
+		images:     make(map[int]CSCartImages),
+		categories: make(map[int]services.GamaCategory),
+		features:   make(map[int]services.GamaFeatureResult),
+		groups:     make(map[int]services.GamaVariationGroup),
+		usergroups: make(map[int]services.GamaUsergroup),
+		members:    make(map[string]map[int]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseUrl is the value for gamaUrl, the endpoints are appended to it
+func (s *CSCartServer) BaseUrl() string {
+	return s.URL + "/"
+}
+
+// AddUser adds an existing user, the user_id is assigned when it is empty
+func (s *CSCartServer) AddUser(user services.GamaUser) services.GamaUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.Id == "" {
+		user.Id = strconv.Itoa(len(s.users) + 1)
+	}
+	s.users = append(s.users, user)
+	s.createProfile(user.Id, services.Profile{}, true)
+	return user
+}
+
+// Users returns the users saved on the fake, the password sent is on Hash
+func (s *CSCartServer) Users() []services.GamaUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]services.GamaUser(nil), s.users...)
+}
+
+// UserByEmail returns the user with the email and if it exists
+func (s *CSCartServer) UserByEmail(email string) (services.GamaUser, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return services.GamaUser{}, false
+}
+
+// Profiles returns the profiles of the user with the email by profile_id
+func (s *CSCartServer) Profiles(email string) map[int]CSCartProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profiles := make(map[int]CSCartProfile)
+	for _, user := range s.users {
+		if user.Email != email {
+			continue
+		}
+		for id, profile := range s.profiles {
+			if profile.UserId == user.Id {
+				profiles[id] = profile
+			}
+		}
+	}
+	return profiles
+}
+
+// Orders returns the orders saved on the fake by order_id
+func (s *CSCartServer) Orders() map[int]services.GamaOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make(map[int]services.GamaOrder)
+	for id, order := range s.orders {
+		orders[id] = order
+	}
+	return orders
+}
+
+// Products returns the products saved on the fake by product_id
+func (s *CSCartServer) Products() map[int]services.GamaProduct {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	products := make(map[int]services.GamaProduct)
+	for id, product := range s.products {
+		products[id] = product
+	}
+	return products
+}
+
+// Categories returns the categories saved on the fake by category_id
+func (s *CSCartServer) Categories() map[int]services.GamaCategory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	categories := make(map[int]services.GamaCategory)
+	for id, category := range s.categories {
+		categories[id] = category
+	}
+	return categories
+}
+
+// Images returns the images uploaded to the product with the id
+func (s *CSCartServer) Images(productId int) CSCartImages {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.images[productId]
+}
+
+// Features returns the features saved on the fake by feature_id
+func (s *CSCartServer) Features() map[int]services.GamaFeatureResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	features := make(map[int]services.GamaFeatureResult)
+	for id, feature := range s.features {
+		features[id] = feature
+	}
+	return features
+}
+
+// VariationGroups returns the variation groups saved on the fake by group_id
+func (s *CSCartServer) VariationGroups() map[int]services.GamaVariationGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := make(map[int]services.GamaVariationGroup)
+	for id, group := range s.groups {
+		groups[id] = group
+	}
+	return groups
+}
+
+// AddUsergroup adds a usergroup and returns its usergroup_id
+func (s *CSCartServer) AddUsergroup(usergroup services.GamaUsergroup) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := len(s.usergroups) + 1
+	s.usergroups[id] = usergroup
+	return id
+}
+
+// Usergroups returns the usergroups saved on the fake by usergroup_id
+func (s *CSCartServer) Usergroups() map[int]services.GamaUsergroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usergroups := make(map[int]services.GamaUsergroup)
+	for id, usergroup := range s.usergroups {
+		usergroups[id] = usergroup
+	}
+	return usergroups
+}
+
+// SetUserUsergroup sets the status (A active, D declined) of the user on the usergroup
+func (s *CSCartServer) SetUserUsergroup(userId string, usergroupId int, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members[userId] == nil {
+		s.members[userId] = make(map[int]string)
+	}
+	s.members[userId][usergroupId] = status
+}
+
+// UserUsergroups returns the status of the usergroups of the user by usergroup_id
+func (s *CSCartServer) UserUsergroups(userId string) map[int]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usergroups := make(map[int]string)
+	for id, status := range s.members[userId] {
+		usergroups[id] = status
+	}
+	return usergroups
+}
+
+// AddProfileField adds a profile field, served on api/profile_fields
+func (s *CSCartServer) AddProfileField(field services.GamaProfileField) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profileFields = append(s.profileFields, field)
+}
+
+// AddState adds a state, the state_id is assigned when it is 0
+func (s *CSCartServer) AddState(state services.GamaState) services.GamaState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state.StateId == 0 {
+		state.StateId = len(s.states) + 1
+	}
+	s.states = append(s.states, state)
+	return state
+}
+
+// Requests returns the requests received so far
+func (s *CSCartServer) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsTo returns the requests received for method and path
+func (s *CSCartServer) RequestsTo(method string, path string) []Request {
+	var requests []Request
+	for _, request := range s.Requests() {
+		if request.Method == method && request.Path == path {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func (s *CSCartServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// GAMA endpoints are built as api/users&gredir=gama, so the params can be on the path
+	path := strings.Trim(r.URL.Path, "/")
+	if index := strings.Index(path, "&"); index >= 0 {
+		path = path[:index]
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Query: r.URL.RawQuery, Body: string(body)})
+	s.mu.Unlock()
+
+	user, password, ok := r.BasicAuth()
+	if !ok || user != CSCartUser || password != CSCartPassword {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+		return
+	}
+	if failure := s.nextFailure(r.Method, path); failure.afterHandling {
+		defer failure.write(w)
+		w = httptest.NewRecorder()
+	} else if failure.status != 0 {
+		failure.write(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "api/users":
+		s.searchUsers(w, r)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "users":
+		s.getUser(w, parts[2])
+	case r.Method == http.MethodPost && path == "api/users":
+		s.createUser(w, body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "users":
+		s.updateUser(w, parts[2], body)
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[1] == "users" && parts[3] == "usergroups":
+		s.getUserUsergroups(w, parts[2])
+	case r.Method == http.MethodPut && len(parts) == 5 && parts[1] == "users" && parts[3] == "usergroups":
+		s.assignUsergroup(w, parts[2], parts[4], body)
+	case r.Method == http.MethodGet && path == "api/profile_fields":
+		s.mu.Lock()
+		fields := []map[string]string{}
+		for _, field := range s.profileFields {
+			fields = append(fields, map[string]string{"field_id": strconv.Itoa(field.FieldId), "field_name": field.FieldName, "section": field.Section})
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, fields)
+	case r.Method == http.MethodGet && path == "api/states":
+		s.searchStates(w, r)
+	case r.Method == http.MethodPost && path == "api/usergroups":
+		s.createUsergroup(w, body)
+	case r.Method == http.MethodGet && path == "api/profiles":
+		s.searchProfiles(w, r)
+	case r.Method == http.MethodPost && path == "api/profiles":
+		s.createProfiles(w, body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "profiles":
+		s.updateProfiles(w, body)
+	case r.Method == http.MethodDelete && len(parts) == 3 && parts[1] == "profiles":
+		s.deleteProfiles(w, body)
+	case r.Method == http.MethodPost && path == "api/orders":
+		s.createOrder(w, body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "orders":
+		s.updateOrder(w, parts[2], body)
+	case r.Method == http.MethodPost && path == "api/products":
+		s.createProduct(w, body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "products":
+		s.updateProduct(w, parts[2], body)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "features":
+		s.getFeature(w, parts[2])
+	case r.Method == http.MethodPost && path == "api/features":
+		s.saveFeature(w, "", body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "features":
+		s.saveFeature(w, parts[2], body)
+	case r.Method == http.MethodPost && path == "api/product_variations_groups":
+		s.saveVariationGroup(w, "", body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "product_variations_groups":
+		s.saveVariationGroup(w, parts[2], body)
+	case r.Method == http.MethodPost && path == "api/categories":
+		s.createCategory(w, body)
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[1] == "categories":
+		s.updateCategory(w, parts[2], body)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	}
+}
+
+func (s *CSCartServer) createOrder(w http.ResponseWriter, body []byte) {
+	order := services.GamaOrder{}
+	if err := json.Unmarshal(body, &order); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	orderId := len(s.orders) + 1
+	s.orders[orderId] = order
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, services.GamaOrderResponse{OrderId: orderId})
+}
+
+func (s *CSCartServer) updateOrder(w http.ResponseWriter, id string, body []byte) {
+	order := services.GamaOrder{}
+	if err := json.Unmarshal(body, &order); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	orderId, _ := strconv.Atoi(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[orderId]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Order not found"})
+		return
+	}
+	s.orders[orderId] = order
+	writeJSON(w, http.StatusOK, services.GamaOrderResponse{OrderId: orderId})
+}
+
+func (s *CSCartServer) createProduct(w http.ResponseWriter, body []byte) {
+	product := services.GamaProduct{}
+	if err := json.Unmarshal(body, &product); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	productId := len(s.products) + 1
+	s.products[productId] = product
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, services.GamaProductResponse{ProductId: productId})
+}
+
+// updateProduct replaces the product, or adds the images when the body has main_pair or image_pairs
+func (s *CSCartServer) updateProduct(w http.ResponseWriter, id string, body []byte) {
+	product := services.GamaProduct{}
+	images := services.GamaProductImages{}
+	if err := json.Unmarshal(body, &product); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	if err := json.Unmarshal(body, &images); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	productId, _ := strconv.Atoi(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.products[productId]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Product not found"})
+		return
+	}
+	if images.MainPair != nil || len(images.ImagePairs) > 0 {
+		saved := s.images[productId]
+		if images.MainPair != nil {
+			saved.Main = images.MainPair
+		}
+		for index := 0; index < len(images.ImagePairs); index++ {
+			saved.Additional = append(saved.Additional, images.ImagePairs[strconv.Itoa(index)])
+		}
+		s.images[productId] = saved
+		writeJSON(w, http.StatusOK, services.GamaProductResponse{ProductId: productId})
+		return
+	}
+	s.products[productId] = product
+	writeJSON(w, http.StatusOK, services.GamaProductResponse{ProductId: productId})
+}
+
+func (s *CSCartServer) createCategory(w http.ResponseWriter, body []byte) {
+	category := services.GamaCategory{}
+	if err := json.Unmarshal(body, &category); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.categories[category.ParentId]; category.ParentId != 0 && !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Parent category not found"})
+		return
+	}
+	categoryId := len(s.categories) + 1
+	s.categories[categoryId] = category
+	writeJSON(w, http.StatusCreated, services.GamaCategoryResponse{CategoryId: categoryId})
+}
+
+func (s *CSCartServer) updateCategory(w http.ResponseWriter, id string, body []byte) {
+	category := services.GamaCategory{}
+	if err := json.Unmarshal(body, &category); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	categoryId, _ := strconv.Atoi(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.categories[categoryId]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Category not found"})
+		return
+	}
+	s.categories[categoryId] = category
+	writeJSON(w, http.StatusOK, services.GamaCategoryResponse{CategoryId: categoryId})
+}
+
+func (s *CSCartServer) getFeature(w http.ResponseWriter, id string) {
+	featureId, _ := strconv.Atoi(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feature, ok := s.features[featureId]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Feature not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, feature)
+}
+
+// saveFeature creates the feature when id is empty, the variants without variant_id are created and
+// the ones missing on the request are kept
+func (s *CSCartServer) saveFeature(w http.ResponseWriter, id string, body []byte) {
+	request := services.GamaFeature{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	featureId, _ := strconv.Atoi(id)
+	feature, ok := s.features[featureId]
+	if id == "" {
+		featureId = len(s.features) + 1
+		feature = services.GamaFeatureResult{FeatureId: featureId, Variants: make(map[string]services.GamaFeatureVariant)}
+	} else if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Feature not found"})
+		return
+	}
+	feature.Description = request.Description
+	feature.FeatureType = request.FeatureType
+	if request.Purpose != "" {
+		feature.Purpose = request.Purpose
+	}
+	for _, variant := range request.Variants {
+		if variant.VariantId == 0 {
+			s.lastVariantId++
+			variant.VariantId = s.lastVariantId
+		}
+		feature.Variants[strconv.Itoa(variant.VariantId)] = variant
+	}
+	s.features[featureId] = feature
+
+	status := http.StatusOK
+	if id == "" {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, services.GamaFeatureResponse{FeatureId: featureId})
+}
+
+func (s *CSCartServer) saveVariationGroup(w http.ResponseWriter, id string, body []byte) {
+	group := services.GamaVariationGroup{}
+	if err := json.Unmarshal(body, &group); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, productId := range group.ProductIds {
+		if _, ok := s.products[productId]; !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Product " + strconv.Itoa(productId) + " not found"})
+			return
+		}
+	}
+	groupId, _ := strconv.Atoi(id)
+	status := http.StatusOK
+	if id == "" {
+		groupId = len(s.groups) + 1
+		status = http.StatusCreated
+	} else if _, ok := s.groups[groupId]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Group not found"})
+		return
+	}
+	s.groups[groupId] = group
+	writeJSON(w, status, services.GamaVariationGroupResponse{GroupId: groupId})
+}
+
+func (s *CSCartServer) searchStates(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := []map[string]string{}
+	for _, state := range s.states {
+		if state.CountryCode == r.URL.Query().Get("country_code") {
+			states = append(states, map[string]string{
+				"state_id":     strconv.Itoa(state.StateId),
+				"country_code": state.CountryCode,
+				"code":         state.Code,
+				"state":        state.State,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"states": states})
+}
+
+func (s *CSCartServer) createUsergroup(w http.ResponseWriter, body []byte) {
+	usergroup := services.GamaUsergroup{}
+	if err := json.Unmarshal(body, &usergroup); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	id := len(s.usergroups) + 1
+	s.usergroups[id] = usergroup
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, services.GamaUsergroupResponse{UsergroupId: id})
+}
+
+func (s *CSCartServer) getUserUsergroups(w http.ResponseWriter, userId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usergroups := []map[string]string{}
+	for id, status := range s.members[userId] {
+		usergroups = append(usergroups, map[string]string{"usergroup_id": strconv.Itoa(id), "status": status})
+	}
+	writeJSON(w, http.StatusOK, usergroups)
+}
+
+func (s *CSCartServer) assignUsergroup(w http.ResponseWriter, userId string, id string, body []byte) {
+	request := struct {
+		Status string `json:"status"`
+	}{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usergroupId, _ := strconv.Atoi(id)
+	if _, ok := s.usergroups[usergroupId]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Usergroup not found"})
+		return
+	}
+	found := false
+	for _, user := range s.users {
+		found = found || user.Id == userId
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "User not found"})
+		return
+	}
+	if s.members[userId] == nil {
+		s.members[userId] = make(map[int]string)
+	}
+	s.members[userId][usergroupId] = request.Status
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (s *CSCartServer) searchUsers(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+
+	s.mu.Lock()
+	users := []services.GamaUser{}
+	for _, user := range s.users {
+		if email == "" || user.Email == email {
+			users = append(users, user)
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, services.GamaResult{
+		Users:  users,
+		Params: services.GamaResultParams{TotalItems: strconv.Itoa(len(users))},
+	})
+}
+
+func (s *CSCartServer) getUser(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Id == id {
+			user.Hash = "" // GAMA never returns the password
+			writeJSON(w, http.StatusOK, user)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "User not found"})
+}
+
+func (s *CSCartServer) searchProfiles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	userId := s.userIdByEmail(r.URL.Query().Get("email"))
+	profiles := []services.Profile{}
+	for _, profile := range s.profiles {
+		if userId != "" && profile.UserId == userId {
+			// GAMA returns the type of every profile, P for the main one
+			saved := profile.Profile
+			saved.ProfileType = "S"
+			if profile.Main {
+				saved.ProfileType = "P"
+			}
+			profiles = append(profiles, saved)
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string][]services.Profile{"profiles": profiles})
+}
+
+func (s *CSCartServer) createUser(w http.ResponseWriter, body []byte) {
+	user := services.GamaUser{}
+	if err := json.Unmarshal(body, &user); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	user.Id = strconv.Itoa(len(s.users) + 1)
+	s.users = append(s.users, user)
+	profileId := s.createProfile(user.Id, services.Profile{}, true)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"user_id": user.Id, "profile_id": strconv.Itoa(profileId)})
+}
+
+func (s *CSCartServer) updateUser(w http.ResponseWriter, id string, body []byte) {
+	update := services.GamaUser{}
+	if err := json.Unmarshal(body, &update); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for index, user := range s.users {
+		if user.Id == id {
+			update.Id = id
+			if update.Hash == "" {
+				update.Hash = user.Hash
+			}
+			if update.UserType == "" {
+				update.UserType = user.UserType
+			}
+			if update.CompanyId == "" {
+				update.CompanyId = user.CompanyId
+			}
+			s.users[index] = update
+			writeJSON(w, http.StatusOK, map[string]string{"user_id": id})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "User not found"})
+}
+
+func (s *CSCartServer) createProfiles(w http.ResponseWriter, body []byte) {
+	request := services.GamaProfileRequest{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userId := s.userIdByEmail(request.Email)
+	if userId == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "User not found"})
+		return
+	}
+
+	response := services.GamaProfileResponse{Profiles: make(map[int]int)}
+	for _, profile := range request.Profiles {
+		response.Profiles[profile.ProfileName] = s.createProfile(userId, profile, false)
+		if profile.ProfileType == "P" {
+			s.setMainProfile(userId, response.Profiles[profile.ProfileName])
+		}
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *CSCartServer) updateProfiles(w http.ResponseWriter, body []byte) {
+	request := services.GamaProfileRequest{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userId := s.userIdByEmail(request.Email)
+
+	response := services.GamaUpdateProfileResponse{Profiles: make(map[int]bool)}
+	for _, profile := range request.Profiles {
+		saved, ok := s.profiles[profile.ProfileId]
+		if !ok || saved.UserId != userId {
+			response.Profiles[profile.ProfileId] = false
+			continue
+		}
+		saved.Profile = profile
+		s.profiles[profile.ProfileId] = saved
+		if profile.ProfileType == "P" {
+			s.setMainProfile(userId, profile.ProfileId)
+		}
+		response.Profiles[profile.ProfileId] = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// deleteProfiles deletes the profiles of the user of the request, the main profile can't be deleted
+func (s *CSCartServer) deleteProfiles(w http.ResponseWriter, body []byte) {
+	request := services.GamaProfileRequest{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userId := s.userIdByEmail(request.Email)
+
+	response := services.GamaUpdateProfileResponse{Profiles: make(map[int]bool)}
+	for _, profile := range request.Profiles {
+		saved, ok := s.profiles[profile.ProfileId]
+		if !ok || saved.UserId != userId || saved.Main {
+			response.Profiles[profile.ProfileId] = false
+			continue
+		}
+		delete(s.profiles, profile.ProfileId)
+		response.Profiles[profile.ProfileId] = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// createProfile must be called with the lock held
+func (s *CSCartServer) createProfile(userId string, profile services.Profile, main bool) int {
+	s.lastProfileId++
+	profile.ProfileId = s.lastProfileId
+	s.profiles[profile.ProfileId] = CSCartProfile{UserId: userId, Main: main, Profile: profile}
+	return profile.ProfileId
+}
+
+// setMainProfile makes the profile the only main profile of the user, it must be called with the lock held
+func (s *CSCartServer) setMainProfile(userId string, profileId int) {
+	for id, profile := range s.profiles {
+		if profile.UserId == userId {
+			profile.Main = id == profileId
+			s.profiles[id] = profile
+		}
+	}
+}
+
+// userIdByEmail must be called with the lock held
+func (s *CSCartServer) userIdByEmail(email string) string {
+	for _, user := range s.users {
+		if user.Email == email {
+			return user.Id
+		}
+	}
+	return ""
+}
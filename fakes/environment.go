@@ -0,0 +1,103 @@
+package fakes
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	services "migration-m2-gama/services"
+)
+
+// Environment points the services to fake servers, a memory store and an in process job queue
+type Environment struct {
+	Magento *MagentoServer
+	CSCart  *CSCartServer
+	Queue   *services.LocalJobQueue
+	Store   services.MigrationStore
+}
+
+// Handler is the signature of the lambdas that enqueue jobs
+type Handler func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// NewEnvironment starts the fake servers, they are closed when the test ends
+func NewEnvironment(t testing.TB) *Environment {
+	env := &Environment{
+		Magento: NewMagentoServer(),
+		CSCart:  NewCSCartServer(),
+		Queue:   services.NewLocalJobQueue(1),
+		Store:   services.NewMemoryStore(),
+	}
+	t.Cleanup(env.Magento.Close)
+	t.Cleanup(env.CSCart.Close)
+
+	// the clients read their rate limits when they are created, the tests run without them
+	t.Setenv("magentoRateLimit", "0")
+	t.Setenv("gamaRateLimit", "0")
+	services.SetMagentoClient(services.NewMagentoClient(env.Magento.BaseUrl(), "bearer", nil))
+	services.SetCSCartClient(services.NewCSCartClient(env.CSCart.BaseUrl(), CSCartUser, CSCartPassword, nil))
+	services.SetStore(env.Store)
+	services.SetJobQueue(env.Queue)
+
+	// the regions file of the repository, like the one packaged with the functions
+	_, file, _, _ := runtime.Caller(0)
+	t.Setenv("regionsFile", filepath.Join(filepath.Dir(file), "..", "regions.json"))
+	// the failed requests are retried without making the tests slow
+	t.Setenv("httpRetries", "2")
+	t.Setenv("httpRetryDelay", "1ms")
+
+	return env
+}
+
+// RunJob sends the body to the handler, checks it enqueued a job and returns the job once the worker processed it
+func (env *Environment) RunJob(t testing.TB, handler Handler, body string) services.Job {
+	t.Helper()
+
+	response, err := handler(events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: body})
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("handler status = %d, body = %s", response.StatusCode, response.Body)
+	}
+
+	created := struct {
+		JobId  string `json:"job_id"`
+		Status string `json:"status"`
+	}{}
+	if err := json.Unmarshal([]byte(response.Body), &created); err != nil {
+		t.Fatalf("unmarshal handler body: %v", err)
+	}
+	if created.JobId == "" || created.Status != services.JobQueued {
+		t.Fatalf("handler returned %+v", created)
+	}
+
+	env.Queue.Wait()
+
+	job, err := env.Store.GetJob(created.JobId)
+	if err != nil {
+		t.Fatalf("GetJob(%s): %v", created.JobId, err)
+	}
+	return job
+}
+
+// AssertResults checks the job finished with the email, response code and reason of the expected results
+func AssertResults(t testing.TB, job services.Job, expected ...services.BodyResult) {
+	t.Helper()
+
+	if job.Status != services.JobFinished {
+		t.Fatalf("job status = %s (%s), want %s", job.Status, job.Error, services.JobFinished)
+	}
+	if len(job.Results) != len(expected) {
+		t.Fatalf("job results = %+v, want %+v", job.Results, expected)
+	}
+	for index, result := range expected {
+		actual := job.Results[index]
+		if actual.Email != result.Email || actual.Reference != result.Reference || actual.ResponseCode != result.ResponseCode || actual.Reason != result.Reason {
+			t.Errorf("job result %d = %+v, want %+v", index, actual, result)
+		}
+	}
+}
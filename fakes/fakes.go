@@ -0,0 +1,91 @@
+package fakes
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Request is a request received by a fake server, the path has no base url nor query
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+}
+
+// failure is a programmed answer of a fake server, afterHandling makes the server apply the request before
+// answering it, like a gateway that timed out while the api was still working
+type failure struct {
+	status        int
+	retryAfter    string
+	afterHandling bool
+}
+
+// failures keeps the statuses programmed with Fail, they are returned before handling the request
+type failures struct {
+	mu       sync.Mutex
+	statuses map[string][]failure
+}
+
+// Fail makes the next requests to method and path answer the statuses, one status per request, 0 lets the request through
+func (f *failures) Fail(method string, path string, statuses ...int) {
+	for _, status := range statuses {
+		f.program(method, path, failure{status: status})
+	}
+}
+
+// FailAllAttempts makes the next request to method and path answer the status on the first attempt and on
+// all its retries (httpRetries, set by NewEnvironment)
+func (f *failures) FailAllAttempts(method string, path string, status int) {
+	retries, err := strconv.Atoi(os.Getenv("httpRetries"))
+	if err != nil {
+		retries = 0
+	}
+	for attempt := 0; attempt <= retries; attempt++ {
+		f.program(method, path, failure{status: status})
+	}
+}
+
+// Throttle makes the next request to method and path answer 429 with the Retry-After header
+func (f *failures) Throttle(method string, path string, retryAfter string) {
+	f.program(method, path, failure{status: http.StatusTooManyRequests, retryAfter: retryAfter})
+}
+
+// FailAfterHandling makes the next request to method and path answer the status once the server applied it
+func (f *failures) FailAfterHandling(method string, path string, status int) {
+	f.program(method, path, failure{status: status, afterHandling: true})
+}
+
+func (f *failures) program(method string, path string, programmed failure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.statuses == nil {
+		f.statuses = make(map[string][]failure)
+	}
+	key := method + " " + strings.Trim(path, "/")
+	f.statuses[key] = append(f.statuses[key], programmed)
+}
+
+func (f *failures) nextFailure(method string, path string) failure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := method + " " + path
+	if len(f.statuses[key]) == 0 {
+		return failure{}
+	}
+	next := f.statuses[key][0]
+	f.statuses[key] = f.statuses[key][1:]
+	return next
+}
+
+func (f failure) write(w http.ResponseWriter) {
+	if f.retryAfter != "" {
+		w.Header().Set("Retry-After", f.retryAfter)
+	}
+	writeJSON(w, f.status, map[string]string{"message": "programmed failure"})
+}
@@ -0,0 +1,494 @@
+// Package fakes has in memory stand-ins of the magento and CS-Cart (GAMA) apis used by the end to end tests
+package fakes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	services "migration-m2-gama/services"
+)
+
+// MagentoServer serves customers/search, orders, products and categories from the data added to it
+type MagentoServer struct {
+	*httptest.Server
+	failures
+
+	mu         sync.Mutex
+	customers  []services.MagentoUser
+	orders     []services.MagentoOrder
+	products   []services.MagentoProduct
+	categories services.MagentoCategory
+	children   map[string][]string
+	options    map[string][]services.MagentoConfigurableOption
+	attributes []services.MagentoProductAttribute
+	groups     []services.MagentoCustomerGroup
+	media      map[string][]byte
+	requests   []Request
+}
+
+type searchFilter struct {
+	field     string
+	value     string
+	condition string
+}
+
+// NewMagentoServer starts the fake, close it when the test ends
+func NewMagentoServer() *MagentoServer {
+	s := &MagentoServer{
+		children: make(map[string][]string),
+		options:  make(map[string][]services.MagentoConfigurableOption),
+		media:    make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseUrl is the value for magentoUrl, the endpoints are appended to it
+func (s *MagentoServer) BaseUrl() string {
+	return s.URL + "/rest/V1/"
+}
+
+// AddCustomer adds a customer, the entity_id is assigned when it is 0
+func (s *MagentoServer) AddCustomer(customer services.MagentoUser) services.MagentoUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if customer.Id == 0 {
+		customer.Id = len(s.customers) + 1
+	}
+	s.customers = append(s.customers, customer)
+	return customer
+}
+
+// AddOrder adds an order, the entity_id is assigned when it is 0
+func (s *MagentoServer) AddOrder(order services.MagentoOrder) services.MagentoOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if order.EntityId == 0 {
+		order.EntityId = len(s.orders) + 1
+	}
+	s.orders = append(s.orders, order)
+	return order
+}
+
+// AddProduct adds a product, the entity_id is assigned when it is 0
+func (s *MagentoServer) AddProduct(product services.MagentoProduct) services.MagentoProduct {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if product.Id == 0 {
+		product.Id = len(s.products) + 1
+	}
+	s.products = append(s.products, product)
+	return product
+}
+
+// AddConfigurable links the children skus and the options to the configurable product with the sku
+func (s *MagentoServer) AddConfigurable(sku string, options []services.MagentoConfigurableOption, children ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.options[sku] = options
+	s.children[sku] = children
+}
+
+// AddAttribute adds the definition of a product attribute, served by attribute_code and attribute_id
+func (s *MagentoServer) AddAttribute(attribute services.MagentoProductAttribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, attribute)
+}
+
+// AddCustomerGroup adds a customer group, served on customerGroups/{id}
+func (s *MagentoServer) AddCustomerGroup(group services.MagentoCustomerGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = append(s.groups, group)
+}
+
+// AddMedia adds a file to the catalog/product media folder, file is the path of the gallery entries
+func (s *MagentoServer) AddMedia(file string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.media[strings.TrimPrefix(file, "/")] = content
+}
+
+// UpdateProduct replaces the product with the same entity_id
+func (s *MagentoServer) UpdateProduct(product services.MagentoProduct) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for index := range s.products {
+		if s.products[index].Id == product.Id {
+			s.products[index] = product
+		}
+	}
+}
+
+// SetCategories replaces the categories tree
+func (s *MagentoServer) SetCategories(root services.MagentoCategory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.categories = root
+}
+
+// UpdateCustomer replaces the customer with the same entity_id
+func (s *MagentoServer) UpdateCustomer(customer services.MagentoUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for index := range s.customers {
+		if s.customers[index].Id == customer.Id {
+			s.customers[index] = customer
+		}
+	}
+}
+
+// Requests returns the requests received so far
+func (s *MagentoServer) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *MagentoServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/rest/V1/")
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Query: r.URL.RawQuery})
+	s.mu.Unlock()
+
+	if r.Header.Get("Authorization") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "The consumer isn't authorized to access %resources."})
+		return
+	}
+	if failure := s.nextFailure(r.Method, path); failure.afterHandling {
+		defer failure.write(w)
+		w = httptest.NewRecorder()
+	} else if failure.status != 0 {
+		failure.write(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/media/catalog/product/"):
+		s.mu.Lock()
+		content, ok := s.media[strings.TrimPrefix(r.URL.Path, "/media/catalog/product/")]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "configurable-products" && parts[2] == "children":
+		s.getChildren(w, parts[1])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[0] == "configurable-products" && parts[2] == "options":
+		s.mu.Lock()
+		options := append([]services.MagentoConfigurableOption{}, s.options[parts[1]]...)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, options)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "products" && parts[1] == "attributes":
+		s.getAttribute(w, parts[2])
+	case r.Method == http.MethodGet && path == "products/attributes":
+		s.searchAttributes(w, r)
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "customerGroups":
+		s.getCustomerGroup(w, parts[1])
+	case r.Method == http.MethodGet && path == "customers/search":
+		s.searchCustomers(w, r)
+	case r.Method == http.MethodGet && path == "orders":
+		s.searchOrders(w, r)
+	case r.Method == http.MethodGet && path == "products":
+		s.searchProducts(w, r)
+	case r.Method == http.MethodGet && path == "categories":
+		s.mu.Lock()
+		root := s.categories
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, root)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Request does not match any route."})
+	}
+}
+
+func (s *MagentoServer) searchCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	var items []services.MagentoUser
+	for _, customer := range s.customers {
+		if matchesFilters(customer, query) {
+			items = append(items, customer)
+		}
+	}
+	s.mu.Unlock()
+
+	sortCustomers(items, query)
+
+	total := len(items)
+	start, end := pageBounds(total, query)
+	items = items[start:end]
+	if items == nil {
+		items = []services.MagentoUser{}
+	}
+
+	writeJSON(w, http.StatusOK, services.MagentoResults{Items: items, Total: total})
+}
+
+func (s *MagentoServer) searchOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	items := []services.MagentoOrder{}
+	for _, order := range s.orders {
+		matched := true
+		for _, filter := range readFilters(query, 0) {
+			if filter.field == "customer_email" && order.CustomerEmail != filter.value {
+				matched = false
+			}
+		}
+		if matched {
+			items = append(items, order)
+		}
+	}
+	s.mu.Unlock()
+
+	total := len(items)
+	start, end := pageBounds(total, query)
+	writeJSON(w, http.StatusOK, services.MagentoOrderResults{Items: items[start:end], Total: total})
+}
+
+func (s *MagentoServer) searchProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	items := []services.MagentoProduct{}
+	for _, product := range s.products {
+		matched := true
+		for _, filter := range readFilters(query, 0) {
+			if filter.field == "sku" && product.Sku != filter.value {
+				matched = false
+			}
+			if filter.field == "entity_id" && !compareInts(product.Id, filter) {
+				matched = false
+			}
+		}
+		if matched {
+			items = append(items, product)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Id < items[j].Id })
+
+	total := len(items)
+	start, end := pageBounds(total, query)
+	writeJSON(w, http.StatusOK, services.MagentoProductResults{Items: items[start:end], Total: total})
+}
+
+func (s *MagentoServer) getChildren(w http.ResponseWriter, sku string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	children := []services.MagentoProduct{}
+	for _, childSku := range s.children[sku] {
+		for _, product := range s.products {
+			if product.Sku == childSku {
+				children = append(children, product)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, children)
+}
+
+func (s *MagentoServer) getAttribute(w http.ResponseWriter, attribute string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, definition := range s.attributes {
+		if definition.AttributeCode == attribute || strconv.Itoa(definition.AttributeId) == attribute {
+			writeJSON(w, http.StatusOK, definition)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "The attribute with a \"" + attribute + "\" attributeCode doesn't exist."})
+}
+
+func (s *MagentoServer) getCustomerGroup(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, group := range s.groups {
+		if strconv.Itoa(group.Id) == id {
+			writeJSON(w, http.StatusOK, group)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such entity with id = " + id})
+}
+
+// searchAttributes serves the user defined attributes with the frontend_input of the filters
+func (s *MagentoServer) searchAttributes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	inputs := make(map[string]bool)
+	for group := 0; ; group++ {
+		filters := readFilters(query, group)
+		if len(filters) == 0 {
+			break
+		}
+		for _, filter := range filters {
+			if filter.field == "frontend_input" {
+				for _, input := range strings.Split(filter.value, ",") {
+					inputs[input] = true
+				}
+			}
+		}
+	}
+
+	var items []services.MagentoProductAttribute
+	for _, attribute := range s.attributes {
+		if attribute.IsUserDefined && (len(inputs) == 0 || inputs[attribute.FrontendInput]) {
+			items = append(items, attribute)
+		}
+	}
+	start, end := pageBounds(len(items), query)
+	writeJSON(w, http.StatusOK, services.MagentoAttributeResults{Items: items[start:end], Total: len(items)})
+}
+
+// pageBounds returns the slice of the page requested by pageSize and currentPage
+func pageBounds(total int, query map[string][]string) (int, int) {
+	pageSize, _ := strconv.Atoi(first(query["searchCriteria[pageSize]"]))
+	currentPage, _ := strconv.Atoi(first(query["searchCriteria[currentPage]"]))
+	if pageSize <= 0 {
+		return 0, total
+	}
+	if currentPage < 1 {
+		currentPage = 1
+	}
+	start := (currentPage - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// matchesFilters applies the filter groups with AND between groups and OR inside a group, like magento
+func matchesFilters(customer services.MagentoUser, query map[string][]string) bool {
+	for group := 0; ; group++ {
+		filters := readFilters(query, group)
+		if len(filters) == 0 {
+			return true
+		}
+		matched := false
+		for _, filter := range filters {
+			if matchesFilter(customer, filter) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+}
+
+func readFilters(query map[string][]string, group int) []searchFilter {
+	var filters []searchFilter
+	for index := 0; ; index++ {
+		prefix := "searchCriteria[filter_groups][" + strconv.Itoa(group) + "][filters][" + strconv.Itoa(index) + "]"
+		field := first(query[prefix+"[field]"])
+		if field == "" {
+			return filters
+		}
+		filters = append(filters, searchFilter{
+			field:     field,
+			value:     first(query[prefix+"[value]"]),
+			condition: first(query[prefix+"[condition_type]"]),
+		})
+	}
+}
+
+func matchesFilter(customer services.MagentoUser, filter searchFilter) bool {
+	var value string
+	switch filter.field {
+	case "email":
+		value = customer.Email
+	case "updated_at":
+		value = customer.UpdatedAt
+	case "entity_id":
+		return compareInts(customer.Id, filter)
+	default:
+		return false
+	}
+
+	switch filter.condition {
+	case "gteq":
+		return value >= filter.value
+	case "gt":
+		return value > filter.value
+	default:
+		return value == filter.value
+	}
+}
+
+func compareInts(value int, filter searchFilter) bool {
+	filterValue, _ := strconv.Atoi(filter.value)
+	switch filter.condition {
+	case "gteq":
+		return value >= filterValue
+	case "gt":
+		return value > filterValue
+	default:
+		return value == filterValue
+	}
+}
+
+func sortCustomers(items []services.MagentoUser, query map[string][]string) {
+	var fields []string
+	for index := 0; ; index++ {
+		field := first(query["searchCriteria[sortOrders]["+strconv.Itoa(index)+"][field]"])
+		if field == "" {
+			break
+		}
+		fields = append(fields, field)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, field := range fields {
+			switch field {
+			case "updated_at":
+				if items[i].UpdatedAt != items[j].UpdatedAt {
+					return items[i].UpdatedAt < items[j].UpdatedAt
+				}
+			case "entity_id":
+				if items[i].Id != items[j].Id {
+					return items[i].Id < items[j].Id
+				}
+			}
+		}
+		return false
+	})
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
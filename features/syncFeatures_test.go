@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+func addAttributes(env *fakes.Environment) {
+	env.Magento.AddAttribute(services.MagentoProductAttribute{AttributeId: 150, AttributeCode: "material", FrontendInput: "select", IsUserDefined: true, DefaultFrontendLabel: "Material",
+		Options: []services.MagentoAttributeOption{{Label: " ", Value: ""}, {Label: "Roble", Value: "21"}, {Label: "Pino", Value: "22"}}})
+	env.Magento.AddAttribute(services.MagentoProductAttribute{AttributeId: 151, AttributeCode: "ambientes", FrontendInput: "multiselect", IsUserDefined: true, DefaultFrontendLabel: "Ambientes",
+		Options: []services.MagentoAttributeOption{{Label: "Comedor", Value: "31"}, {Label: "Cocina", Value: "32"}}})
+	env.Magento.AddAttribute(services.MagentoProductAttribute{AttributeId: 152, AttributeCode: "garantia", FrontendInput: "text", IsUserDefined: true, DefaultFrontendLabel: "Garantía"})
+	env.Magento.AddAttribute(services.MagentoProductAttribute{AttributeId: 77, AttributeCode: "price", FrontendInput: "price"})
+}
+
+func TestSyncFeaturesCreatesFeaturesAndVariants(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	addAttributes(env)
+
+	job := env.RunJob(t, SyncFeatures, `{}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "material", ResponseCode: 1},
+		services.BodyResult{Reference: "ambientes", ResponseCode: 1},
+		services.BodyResult{Reference: "garantia", ResponseCode: 1},
+	)
+	if job.Type != services.FeaturesJob || job.Total != 3 || job.Results[0].Entity != "feature" {
+		t.Errorf("job = %+v, want a features job of 3 attributes", job)
+	}
+
+	features := env.CSCart.Features()
+	if len(features) != 3 || features[1].Description != "Material" || len(features[1].Variants) != 2 || len(features[3].Variants) != 0 {
+		t.Errorf("CS-Cart features = %+v", features)
+	}
+
+	expected := map[string]services.FeatureMapping{
+		"material":  {FeatureType: "S", Purpose: services.FilterFeaturePurpose, FeatureId: 1},
+		"ambientes": {FeatureType: "M", Purpose: services.FilterFeaturePurpose, FeatureId: 2},
+		"garantia":  {FeatureType: "T", Purpose: services.DescribeFeaturePurpose, FeatureId: 3},
+	}
+	for code, want := range expected {
+		mapping, err := env.Store.GetFeature(code)
+		if err != nil || !mapping.Result || mapping.FeatureType != want.FeatureType || mapping.Purpose != want.Purpose || mapping.FeatureId != want.FeatureId {
+			t.Errorf("feature mapping %s = %+v, %v, want %+v", code, mapping, err, want)
+		}
+	}
+	if mapping, _ := env.Store.GetFeature("material"); mapping.Variants["21"] == 0 || mapping.Variants["22"] == 0 {
+		t.Errorf("material variants = %+v, want the magento options mapped", mapping.Variants)
+	}
+}
+
+func TestSyncFeaturesSkipsMigratedFeaturesUnlessForced(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	addAttributes(env)
+	env.RunJob(t, SyncFeatures, `{}`)
+
+	job := env.RunJob(t, SyncFeatures, `{}`)
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "material", ResponseCode: 1, Reason: "feature already migrated with id 1"},
+		services.BodyResult{Reference: "ambientes", ResponseCode: 1, Reason: "feature already migrated with id 2"},
+		services.BodyResult{Reference: "garantia", ResponseCode: 1, Reason: "feature already migrated with id 3"},
+	)
+
+	job = env.RunJob(t, SyncFeatures, `{"force": true}`)
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "material", ResponseCode: 2},
+		services.BodyResult{Reference: "ambientes", ResponseCode: 2},
+		services.BodyResult{Reference: "garantia", ResponseCode: 2},
+	)
+	if features := env.CSCart.Features(); len(features) != 3 || len(features[1].Variants) != 2 {
+		t.Errorf("CS-Cart features = %+v, want the features updated", features)
+	}
+}
+
+func TestSyncFeaturesRejectsInvalidBody(t *testing.T) {
+	fakes.NewEnvironment(t)
+
+	for _, body := range []string{`{"force": `, `{"dry_run": true}`} {
+		response, err := SyncFeatures(events.APIGatewayProxyRequest{Body: body})
+		if err != nil || response.StatusCode != http.StatusBadRequest {
+			t.Errorf("SyncFeatures(%s) = %d, %v, want %d", body, response.StatusCode, err, http.StatusBadRequest)
+		}
+	}
+}
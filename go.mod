@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/alessiosavi/Requests v0.3.8 // indirect
 	github.com/aws/aws-lambda-go v1.22.0
-	github.com/aws/aws-sdk-go v1.37.1 // indirect
+	github.com/aws/aws-sdk-go v1.37.1
 	github.com/google/uuid v1.2.0 // indirect
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 )
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+type JobCreated struct {
+	JobId  string `json:"job_id"`
+	Status string `json:"status"`
+}
+
+// ImportHashes enqueues the import of the password hashes of a magento customer_entity export, run it
+// before the users so they are created with their password. The progress and the invalid records are
+// exposed by GET /jobs/{id}
+func ImportHashes(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bodyRequest := services.BodyRequest{}
+
+	err := json.Unmarshal([]byte(request.Body), &bodyRequest)
+	if err != nil {
+		fmt.Println("Error destructuring the body of the request on ImportHashes function : ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
+	}
+
+	if bodyRequest.Source == "" {
+		return events.APIGatewayProxyResponse{Body: "source is empty, send the path or s3://bucket/key of the customers export", StatusCode: http.StatusBadRequest}, nil
+	}
+
+	if bodyRequest.DryRun {
+		return events.APIGatewayProxyResponse{Body: "dry_run is only supported on POST /users with a list of users", StatusCode: http.StatusBadRequest}, nil
+	}
+
+	_, err = services.GetHashesFormat(bodyRequest.Source, bodyRequest.Format)
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
+	}
+
+	job, err := services.CreateJob(services.HashesJob, bodyRequest)
+	if err != nil {
+		fmt.Println("Error returned by CreateJob function: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	marshaledResult, err := json.Marshal(JobCreated{JobId: job.Id, Status: job.Status})
+	if err != nil {
+		fmt.Println("Error on marshal job: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusAccepted}, nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(ImportHashes)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}
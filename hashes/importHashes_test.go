@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+const customersCSV = `entity_id,email,firstname,password_hash
+1,maria.valencia@example.com,Maria,a665a45920422f9d417e4867efdc4fb8:salt:0
+2,zahit.rios@example.com,Zahit,4ea5bf148556dd9d7bb4c84d5f75278519bfe3d237a7297c5354de16e84662e1:Xy7pQ2:1
+3,broken@example.com,Broken,a665a45920422f9d417e4867efdc4fb8:salt:9
+4,,Nobody,a665a45920422f9d417e4867efdc4fb8:salt:0
+5,admin.created@example.com,Admin,
+6,short@example.com
+`
+
+func writeExport(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestImportHashesFromCSVExport(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	source := writeExport(t, "customer_entity.csv", customersCSV)
+
+	job := env.RunJob(t, ImportHashes, `{"source": "`+source+`"}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Email: "broken@example.com", Reference: "record 3", ResponseCode: 3, Reason: "invalid password hash, version 9 is not supported"},
+		services.BodyResult{Reference: "record 4", ResponseCode: 3, Reason: "the record has no valid email"},
+		services.BodyResult{Reference: "record 6", ResponseCode: 3, Reason: "the row doesn't have the email and password_hash columns"},
+	)
+	if job.Type != services.HashesJob || job.Total != 6 || job.Processed != 6 || job.Results[0].Entity != "hash" || job.Results[0].ErrorCode != "invalid_hash_format" {
+		t.Errorf("job = %+v, want a hashes job of 6 records", job)
+	}
+
+	userHash, err := env.Store.GetHash("maria.valencia@example.com")
+	want := base64.StdEncoding.EncodeToString([]byte("a665a45920422f9d417e4867efdc4fb8:salt:0"))
+	if err != nil || userHash.Hash != want || !userHash.Pending {
+		t.Errorf("imported hash = %+v, %v, want %s pending", userHash, err, want)
+	}
+	if _, err := env.Store.GetHash("zahit.rios@example.com"); err != nil {
+		t.Errorf("GetHash(zahit.rios@example.com): %v", err)
+	}
+	for _, email := range []string{"broken@example.com", "admin.created@example.com"} {
+		if _, err := env.Store.GetHash(email); err != services.ErrNotFound {
+			t.Errorf("GetHash(%s) err = %v, want ErrNotFound", email, err)
+		}
+	}
+}
+
+func TestImportedHashesAreSentWithTheUsers(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.AddCustomer(services.MagentoUser{Email: "maria.valencia@example.com", Firstname: "Maria", Lastname: "Valencia", GroupId: 1})
+	source := writeExport(t, "customer_entity.csv", customersCSV)
+	env.RunJob(t, ImportHashes, `{"source": "`+source+`"}`)
+
+	results, err := services.SyncUser(services.User{Email: "maria.valencia@example.com"}, false, false)
+	if err != nil || len(results) != 1 || results[0].ResponseCode != 1 {
+		t.Fatalf("SyncUser = %+v, %v", results, err)
+	}
+
+	user, ok := env.CSCart.UserByEmail("maria.valencia@example.com")
+	if !ok || user.Hash != "salt" {
+		t.Errorf("CS-Cart user = %+v, want the salt of the imported hash as password", user)
+	}
+	if userHash, err := env.Store.GetHash("maria.valencia@example.com"); err != nil || userHash.Pending {
+		t.Errorf("hash after the sync = %+v, %v, want it sent", userHash, err)
+	}
+}
+
+func TestImportHashesKeepsTheLastRecordOfRepeatedEmails(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	source := writeExport(t, "customer_entity.csv", customersCSV+
+		"7,maria.valencia@example.com,Maria,4ea5bf148556dd9d7bb4c84d5f75278519bfe3d237a7297c5354de16e84662e1:Xy7pQ2:1\n")
+
+	job := env.RunJob(t, ImportHashes, `{"source": "`+source+`"}`)
+
+	if job.Status != services.JobFinished || job.Processed != 7 || len(job.Results) != 3 {
+		t.Fatalf("job = %+v, want the 7 records processed", job)
+	}
+	userHash, err := env.Store.GetHash("maria.valencia@example.com")
+	want := base64.StdEncoding.EncodeToString([]byte("4ea5bf148556dd9d7bb4c84d5f75278519bfe3d237a7297c5354de16e84662e1:Xy7pQ2:1"))
+	if err != nil || userHash.Hash != want || !userHash.Pending {
+		t.Errorf("imported hash = %+v, %v, want the last record %s", userHash, err, want)
+	}
+}
+
+func TestImportHashesKeepsTheHashesSentToGama(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	sent := services.UserHash{Email: "maria.valencia@example.com", Hash: base64.StdEncoding.EncodeToString([]byte("61c5f640d0c22b1c3d0d8fcfad6ea645:Xy7pQ2"))}
+	if err := env.Store.SaveHash(sent); err != nil {
+		t.Fatalf("SaveHash: %v", err)
+	}
+	source := writeExport(t, "customer_entity.csv", customersCSV)
+
+	job := env.RunJob(t, ImportHashes, `{"source": "`+source+`"}`)
+
+	if job.Status != services.JobFinished || job.Processed != 6 {
+		t.Fatalf("job = %+v", job)
+	}
+	if userHash, err := env.Store.GetHash("maria.valencia@example.com"); err != nil || userHash != sent {
+		t.Errorf("hash of the migrated user = %+v, %v, want the one sent to GAMA %+v", userHash, err, sent)
+	}
+	if userHash, err := env.Store.GetHash("zahit.rios@example.com"); err != nil || !userHash.Pending {
+		t.Errorf("hash of the new user = %+v, %v, want it imported", userHash, err)
+	}
+}
+
+func TestImportHashesFromS3CompatibleStore(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	s3 := fakes.NewS3Server()
+	t.Cleanup(s3.Close)
+	t.Setenv("hashesS3Endpoint", s3.URL)
+	t.Setenv("hashesS3Region", "us-east-1")
+	t.Setenv("hashesS3AccessKey", "minio")
+	t.Setenv("hashesS3SecretKey", "minio123")
+
+	// more records than a batch
+	var export strings.Builder
+	for index := 1; index <= 120; index++ {
+		export.WriteString(`{"entity_id": ` + strconv.Itoa(index) + `, "email": "customer` + strconv.Itoa(index) + `@example.com", "password_hash": "a665a45920422f9d417e4867efdc4fb8:salt` + strconv.Itoa(index) + `:0"}` + "\n")
+		if index == 50 {
+			export.WriteString("\nnot json\n")
+		}
+	}
+	s3.PutObject("exports", "customers/customer_entity.ndjson", []byte(export.String()))
+
+	job := env.RunJob(t, ImportHashes, `{"source": "s3://exports/customers/customer_entity.ndjson"}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "record 51", ResponseCode: 3, Reason: "the line is not a json object: invalid character 'o' in literal null (expecting 'u')"},
+	)
+	if job.Total != 121 || job.Processed != 121 {
+		t.Errorf("job total/processed = %d/%d, want 121/121", job.Total, job.Processed)
+	}
+	for _, email := range []string{"customer1@example.com", "customer120@example.com"} {
+		if userHash, err := env.Store.GetHash(email); err != nil || !userHash.Pending {
+			t.Errorf("GetHash(%s) = %+v, %v, want a pending hash", email, userHash, err)
+		}
+	}
+
+	job = env.RunJob(t, ImportHashes, `{"source": "s3://exports/missing.csv"}`)
+	if job.Status != services.JobFailed || !strings.Contains(job.Error, "NoSuchKey") {
+		t.Errorf("job of a missing object = %s %q, want failed with NoSuchKey", job.Status, job.Error)
+	}
+}
+
+func TestImportHashesCapsTheResultsOfTheJob(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	var export strings.Builder
+	export.WriteString("email,password_hash\n")
+	for index := 1; index <= 3000; index++ {
+		export.WriteString("customer" + strconv.Itoa(index) + "@example.com,a665a45920422f9d417e4867efdc4fb8:salt:9\n")
+	}
+	source := writeExport(t, "customer_entity.csv", export.String())
+
+	job := env.RunJob(t, ImportHashes, `{"source": "`+source+`"}`)
+
+	if job.Processed != 3000 || job.OmittedResults == 0 || len(job.Results)+job.OmittedResults != 3000 {
+		t.Errorf("job processed %d, kept %d results and omitted %d, want 3000 records with omitted results", job.Processed, len(job.Results), job.OmittedResults)
+	}
+	if marshaledJob, _ := json.Marshal(job); len(marshaledJob) > 400*1024 {
+		t.Errorf("the job item has %d bytes, more than a DynamoDB item", len(marshaledJob))
+	}
+}
+
+func TestImportHashesRejectsInvalidBody(t *testing.T) {
+	fakes.NewEnvironment(t)
+
+	for _, body := range []string{`{}`, `{"source": "customers.csv", "format": "xml"}`, `{"source": "customers.csv", "dry_run": true}`, `not json`} {
+		response, err := ImportHashes(events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: body})
+		if err != nil || response.StatusCode != http.StatusBadRequest {
+			t.Errorf("ImportHashes(%s) = %d %s, %v, want %d", body, response.StatusCode, response.Body, err, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+type JobResults struct {
+	services.Job
+	ResponseCodes []services.ResponseCode `json:"response_codes"`
+}
+
+func GetJob(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	job, err := services.GetJobFromDb(request.PathParameters["id"])
+	if err == services.ErrNotFound {
+		return events.APIGatewayProxyResponse{Body: "job " + request.PathParameters["id"] + " not found", StatusCode: http.StatusNotFound}, nil
+	}
+	if err != nil {
+		fmt.Println("Error returned by GetJobFromDb function: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	marshaledResult, err := json.Marshal(JobResults{Job: job, ResponseCodes: services.GetResponseCodes()})
+	if err != nil {
+		fmt.Println("Error on marshal job: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusOK}, nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(GetJob)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}
@@ -0,0 +1,200 @@
+// Package magentohash reads and verifies the password hashes of the magento customers. Magento saves
+// them as hash:salt:version, where the version is a chain of the algorithms applied with the same salt
+// (0:2 is a md5 hash upgraded to argon2id13 without knowing the password).
+package magentohash
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	VersionMD5                = 0
+	VersionSHA256             = 1
+	VersionArgon2ID13         = 2
+	VersionArgon2ID13Agnostic = 3 // argon2id13 with the parameters on the version, like 3_32_2_67108864
+
+	// libsodium's crypto_pwhash interactive limits, the ones of magento's argon2id13 version
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	argon2OpsLimit   = 2
+	argon2MemLimit   = 67108864
+
+	// libsodium's moderate limits, higher ones are refused so a hash can't exhaust the lambda
+	argon2MaxOpsLimit = 3
+	argon2MaxMemLimit = 268435456
+)
+
+// Version is an algorithm of the chain, KeyLength, OpsLimit and MemLimit (in bytes) are the argon2 parameters
+type Version struct {
+	Id        int
+	KeyLength int
+	OpsLimit  int
+	MemLimit  int
+}
+
+// Hash is a magento password hash
+type Hash struct {
+	Hash     string
+	Salt     string
+	Versions []Version
+}
+
+// error codes of InvalidHashError
+const (
+	EncodingErrorCode = "invalid_hash_encoding"
+	FormatErrorCode   = "invalid_hash_format"
+)
+
+// InvalidHashError is returned for the hashes that are not base64 (EncodingErrorCode) or don't have the
+// magento format (FormatErrorCode)
+type InvalidHashError struct {
+	Code   string
+	Reason string
+}
+
+func (e *InvalidHashError) Error() string {
+	return "invalid password hash, " + e.Reason
+}
+
+func formatError(reason string) error {
+	return &InvalidHashError{Code: FormatErrorCode, Reason: reason}
+}
+
+// Decode reads the base64 hash:salt:version of the migration records
+func Decode(encoded string) (Hash, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return Hash{}, &InvalidHashError{Code: EncodingErrorCode, Reason: "it is not base64: " + err.Error()}
+	}
+	return Parse(string(decoded))
+}
+
+// Parse reads a hash:salt:version password hash, the hashes without version are md5 like on magento. The
+// hash must be the hex of the last algorithm of the chain.
+func Parse(passwordHash string) (Hash, error) {
+	parts := strings.SplitN(passwordHash, ":", 3)
+	if parts[0] == "" {
+		return Hash{}, formatError("it is empty")
+	}
+	hash := Hash{Hash: parts[0]}
+	if len(parts) > 1 {
+		hash.Salt = parts[1]
+	}
+	if len(parts) < 3 {
+		hash.Versions = []Version{{Id: VersionMD5}}
+	} else {
+		for _, versionText := range strings.Split(parts[2], ":") {
+			version, err := parseVersion(versionText)
+			if err != nil {
+				return Hash{}, err
+			}
+			hash.Versions = append(hash.Versions, version)
+		}
+	}
+
+	last := hash.Versions[len(hash.Versions)-1]
+	if _, err := hex.DecodeString(hash.Hash); err != nil || len(hash.Hash) != 2*last.hashLength() {
+		return Hash{}, formatError("the hash is not the hex of a " + last.name() + " hash")
+	}
+	for _, version := range hash.Versions {
+		if hash.Salt == "" && version.KeyLength != 0 {
+			return Hash{}, formatError("the " + version.name() + " hash has no salt")
+		}
+	}
+	return hash, nil
+}
+
+func parseVersion(versionText string) (Version, error) {
+	params := strings.Split(versionText, "_")
+	id, err := strconv.Atoi(params[0])
+	if err != nil {
+		return Version{}, formatError("version " + versionText + " is not supported")
+	}
+
+	switch id {
+	case VersionMD5, VersionSHA256:
+		if len(params) == 1 {
+			return Version{Id: id}, nil
+		}
+	case VersionArgon2ID13:
+		if len(params) == 1 {
+			return Version{Id: id, KeyLength: argon2KeyLength, OpsLimit: argon2OpsLimit, MemLimit: argon2MemLimit}, nil
+		}
+	case VersionArgon2ID13Agnostic:
+		if len(params) == 4 {
+			version := Version{Id: id}
+			var errs [3]error
+			version.KeyLength, errs[0] = strconv.Atoi(params[1])
+			version.OpsLimit, errs[1] = strconv.Atoi(params[2])
+			version.MemLimit, errs[2] = strconv.Atoi(params[3])
+			if errs[0] == nil && errs[1] == nil && errs[2] == nil && version.KeyLength > 0 && version.OpsLimit > 0 && version.OpsLimit <= argon2MaxOpsLimit && version.MemLimit >= 1024 && version.MemLimit <= argon2MaxMemLimit {
+				return version, nil
+			}
+		}
+	}
+
+	return Version{}, formatError("version " + versionText + " is not supported")
+}
+
+// hashLength is the bytes of the hashes of the version
+func (v Version) hashLength() int {
+	switch v.Id {
+	case VersionMD5:
+		return md5.Size
+	case VersionSHA256:
+		return sha256.Size
+	}
+	return v.KeyLength
+}
+
+func (v Version) name() string {
+	switch v.Id {
+	case VersionMD5:
+		return "md5"
+	case VersionSHA256:
+		return "sha256"
+	}
+	return "argon2id13"
+}
+
+// IsCurrent tells if the hash is a single argon2id13, the algorithm of the current magento versions. The
+// md5 and sha256 hashes and the chains upgraded without the password are not.
+func (h Hash) IsCurrent() bool {
+	return len(h.Versions) == 1 && (h.Versions[0].Id == VersionArgon2ID13 || h.Versions[0].Id == VersionArgon2ID13Agnostic)
+}
+
+// Verify tells if the password is the one of the hash, applying the algorithms of the chain in order
+func (h Hash) Verify(password string) bool {
+	recreated := password
+	for _, version := range h.Versions {
+		if version.Id == VersionArgon2ID13 || version.Id == VersionArgon2ID13Agnostic {
+			recreated = argon2Hash(recreated, h.Salt, version)
+		} else if version.Id == VersionSHA256 {
+			sum := sha256.Sum256([]byte(h.Salt + recreated))
+			recreated = hex.EncodeToString(sum[:])
+		} else {
+			sum := md5.Sum([]byte(h.Salt + recreated))
+			recreated = hex.EncodeToString(sum[:])
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(recreated), []byte(h.Hash)) == 1
+}
+
+// argon2Hash is magento's getArgonHash: the salt is repeated or cut to the 16 bytes of libsodium
+func argon2Hash(password string, salt string, version Version) string {
+	for len(salt) < argon2SaltLength {
+		salt += salt
+	}
+	salt = salt[:argon2SaltLength]
+
+	key := argon2.IDKey([]byte(password), []byte(salt), uint32(version.OpsLimit), uint32(version.MemLimit/1024), 1, uint32(version.KeyLength))
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,143 @@
+package magentohash
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const password = "Secreto123"
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// argon2Hex is libsodium's crypto_pwhash with the interactive limits, salt must have 16 bytes
+func argon2Hex(data string, salt string) string {
+	return hex.EncodeToString(argon2.IDKey([]byte(data), []byte(salt), 2, 65536, 1, 32))
+}
+
+func TestParse(t *testing.T) {
+	interactive := Version{Id: VersionArgon2ID13, KeyLength: 32, OpsLimit: 2, MemLimit: 67108864}
+	tests := []struct {
+		name     string
+		hash     string
+		versions []Version
+		errCode  string
+	}{
+		{name: "without salt", hash: md5Hex(password), versions: []Version{{Id: VersionMD5}}},
+		{name: "without version", hash: md5Hex("Xy7pQ2"+password) + ":Xy7pQ2", versions: []Version{{Id: VersionMD5}}},
+		{name: "sha256", hash: sha256Hex("Xy7pQ2"+password) + ":Xy7pQ2:1", versions: []Version{{Id: VersionSHA256}}},
+		{name: "argon2id13", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:2", versions: []Version{interactive}},
+		{name: "agnostic", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_32_3_268435456",
+			versions: []Version{{Id: VersionArgon2ID13Agnostic, KeyLength: 32, OpsLimit: 3, MemLimit: 268435456}}},
+		{name: "md5 upgraded", hash: argon2Hex("x", "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:0:2", versions: []Version{{Id: VersionMD5}, interactive}},
+		{name: "sha256 upgraded", hash: argon2Hex("x", "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:1:3_32_2_67108864",
+			versions: []Version{{Id: VersionSHA256}, {Id: VersionArgon2ID13Agnostic, KeyLength: 32, OpsLimit: 2, MemLimit: 67108864}}},
+		{name: "empty", hash: "", errCode: FormatErrorCode},
+		{name: "not hex", hash: "zz" + md5Hex(password)[2:] + ":Xy7pQ2", errCode: FormatErrorCode},
+		{name: "length of other version", hash: md5Hex("Xy7pQ2"+password) + ":Xy7pQ2:1", errCode: FormatErrorCode},
+		{name: "unknown version", hash: md5Hex("Xy7pQ2"+password) + ":Xy7pQ2:9", errCode: FormatErrorCode},
+		{name: "argon2 without salt", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + "::2", errCode: FormatErrorCode},
+		{name: "agnostic without parameters", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3", errCode: FormatErrorCode},
+		{name: "agnostic with text parameters", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_32_x_67108864", errCode: FormatErrorCode},
+		{name: "ops limit out of range", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_32_4_67108864", errCode: FormatErrorCode},
+		{name: "zero ops limit", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_32_0_67108864", errCode: FormatErrorCode},
+		{name: "mem limit out of range", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_32_2_536870912", errCode: FormatErrorCode},
+		{name: "mem limit too low", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_32_2_512", errCode: FormatErrorCode},
+		{name: "zero key length", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:3_0_2_67108864", errCode: FormatErrorCode},
+	}
+
+	for _, test := range tests {
+		hash, err := Parse(test.hash)
+		if test.errCode != "" {
+			invalid, ok := err.(*InvalidHashError)
+			if !ok || invalid.Code != test.errCode {
+				t.Errorf("%s: Parse = %+v, %v, want a %s error", test.name, hash, err, test.errCode)
+			}
+			continue
+		}
+		if err != nil || len(hash.Versions) != len(test.versions) {
+			t.Errorf("%s: Parse = %+v, %v, want the versions %+v", test.name, hash, err, test.versions)
+			continue
+		}
+		for index, version := range test.versions {
+			if hash.Versions[index] != version {
+				t.Errorf("%s: version %d = %+v, want %+v", test.name, index, hash.Versions[index], version)
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	passwordHash := md5Hex("Xy7pQ2"+password) + ":Xy7pQ2:0"
+	tests := []struct {
+		name    string
+		encoded string
+		errCode string
+	}{
+		{name: "base64", encoded: base64.StdEncoding.EncodeToString([]byte(passwordHash))},
+		{name: "with spaces", encoded: " " + base64.StdEncoding.EncodeToString([]byte(passwordHash)) + "\n"},
+		{name: "not base64", encoded: "%%%", errCode: EncodingErrorCode},
+		{name: "raw hash", encoded: passwordHash, errCode: EncodingErrorCode},
+		{name: "base64 of other data", encoded: base64.StdEncoding.EncodeToString([]byte("not a hash")), errCode: FormatErrorCode},
+	}
+
+	for _, test := range tests {
+		hash, err := Decode(test.encoded)
+		if test.errCode == "" {
+			if err != nil || hash.Salt != "Xy7pQ2" || !hash.Verify(password) {
+				t.Errorf("%s: Decode = %+v, %v", test.name, hash, err)
+			}
+			continue
+		}
+		if invalid, ok := err.(*InvalidHashError); !ok || invalid.Code != test.errCode {
+			t.Errorf("%s: Decode error = %v, want a %s error", test.name, err, test.errCode)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		current bool
+	}{
+		{name: "md5 without salt", hash: md5Hex(password)},
+		{name: "md5", hash: md5Hex("Xy7pQ2"+password) + ":Xy7pQ2:0"},
+		{name: "sha256", hash: sha256Hex("Xy7pQ2"+password) + ":Xy7pQ2:1"},
+		{name: "argon2id13", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2:2", current: true},
+		{name: "salt longer than 16 bytes", hash: argon2Hex(password, "k3Jm9QzR1vT8wLp2") + ":k3Jm9QzR1vT8wLp2Yx6Nb4Hc7Gd5Fs0A:2", current: true},
+		{name: "salt shorter than 16 bytes", hash: argon2Hex(password, "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:2", current: true},
+		{name: "salt of 1 byte", hash: argon2Hex(password, "XXXXXXXXXXXXXXXX") + ":X:3_32_2_67108864", current: true},
+		{name: "md5 upgraded to argon2id13", hash: argon2Hex(md5Hex("Xy7pQ2"+password), "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:0:2"},
+		{name: "sha256 upgraded to agnostic", hash: argon2Hex(sha256Hex("Xy7pQ2"+password), "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:1:3_32_2_67108864"},
+	}
+
+	for _, test := range tests {
+		hash, err := Parse(test.hash)
+		if err != nil {
+			t.Errorf("%s: Parse: %v", test.name, err)
+			continue
+		}
+		if !hash.Verify(password) {
+			t.Errorf("%s: Verify of the password = false", test.name)
+		}
+		if hash.Verify("secreto123") || hash.Verify("") {
+			t.Errorf("%s: Verify of a wrong password = true", test.name)
+		}
+		if hash.IsCurrent() != test.current {
+			t.Errorf("%s: IsCurrent = %v, want %v", test.name, hash.IsCurrent(), test.current)
+		}
+	}
+}
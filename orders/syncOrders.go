@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+type JobCreated struct {
+	JobId  string `json:"job_id"`
+	Status string `json:"status"`
+}
+
+// SyncOrders enqueues the migration of the orders of the users of the request, the users must be
+// already migrated. The progress and results are exposed by GET /jobs/{id}
+func SyncOrders(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	bodyRequest := services.BodyRequest{}
+
+	err := json.Unmarshal([]byte(request.Body), &bodyRequest)
+	if err != nil {
+		fmt.Println("Error destructuring the body of the request on SyncOrders function : ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
+	}
+
+	if len(bodyRequest.Users) == 0 {
+		return events.APIGatewayProxyResponse{Body: "users is empty, send the users whose orders will be migrated", StatusCode: http.StatusBadRequest}, nil
+	}
+
+	if bodyRequest.DryRun {
+		return events.APIGatewayProxyResponse{Body: "dry_run is only supported on POST /users with a list of users", StatusCode: http.StatusBadRequest}, nil
+	}
+
+	job, err := services.CreateJob(services.OrdersJob, bodyRequest)
+	if err != nil {
+		fmt.Println("Error returned by CreateJob function: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	marshaledResult, err := json.Marshal(JobCreated{JobId: job.Id, Status: job.Status})
+	if err != nil {
+		fmt.Println("Error on marshal job: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusAccepted}, nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(SyncOrders)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}
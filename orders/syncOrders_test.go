@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+func magentoOrder(incrementId string, status string) services.MagentoOrder {
+	address := services.MagentoOrderAddress{
+		Firstname: "Maria",
+		Lastname:  "Valencia",
+		Street:    []string{"Av. Álvaro Obregón 12", "Int 3"},
+		City:      "Cuauhtémoc",
+		Postcode:  "06700",
+		CountryId: "MX",
+		RegionId:  764,
+		Telephone: "5555555555",
+	}
+	order := services.MagentoOrder{
+		IncrementId:    incrementId,
+		CustomerEmail:  "maria.valencia@example.com",
+		Status:         status,
+		CreatedAt:      "2021-01-15 18:30:00",
+		GrandTotal:     1150,
+		Subtotal:       1000,
+		ShippingAmount: 150,
+		Items: []services.MagentoOrderItem{
+			{ItemId: 1, Sku: "MESA-01", Name: "Mesa", ProductType: "configurable", QtyOrdered: 2, Price: 500},
+			{ItemId: 2, ParentItemId: 1, Sku: "MESA-01", Name: "Mesa Roble", ProductType: "simple", QtyOrdered: 2},
+		},
+		BillingAddress: address,
+	}
+	order.ExtensionAttributes.ShippingAssignments = []services.MagentoShippingAssignment{
+		{Shipping: services.MagentoOrderShipping{Address: address, Method: "flatrate_flatrate"}},
+	}
+	return order
+}
+
+func TestSyncOrdersCreatesOrdersAndMappings(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	user := env.CSCart.AddUser(services.GamaUser{Email: "maria.valencia@example.com", Status: "A", UserType: "C"})
+	env.Magento.AddOrder(magentoOrder("000000011", "complete"))
+	env.Magento.AddOrder(magentoOrder("000000012", "unknown_status"))
+	env.Store.SaveProduct(services.ProductMapping{Sku: "MESA-01", MagentoId: 3, GamaId: 7, Result: true})
+
+	job := env.RunJob(t, SyncOrders, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Email: "maria.valencia@example.com", Reference: "000000011", ResponseCode: 1},
+		services.BodyResult{Email: "maria.valencia@example.com", Reference: "000000012", ResponseCode: 3, Reason: "magento order status unknown_status has no GAMA status"},
+	)
+	if job.Type != services.OrdersJob || job.Results[0].Entity != "order" {
+		t.Errorf("job = %+v, want an orders job", job)
+	}
+
+	orders := env.CSCart.Orders()
+	order, ok := orders[1]
+	if !ok || len(orders) != 1 {
+		t.Fatalf("CS-Cart orders = %+v", orders)
+	}
+	if order.UserId != user.Id || order.Status != "C" || order.Total != 1150 || order.ShippingCost != 150 || order.Timestamp != 1610735400 {
+		t.Errorf("CS-Cart order = %+v", order)
+	}
+	if len(order.Products) != 1 || order.Products["1"].ProductId != 7 || order.Products["1"].ProductCode != "MESA-01" || order.Products["1"].Amount != 2 || order.Products["1"].Price != 500 {
+		t.Errorf("CS-Cart order products = %+v, want only the configurable item", order.Products)
+	}
+	if order.UserData.Saddress != "Av. Álvaro Obregón 12" || order.UserData.Saddress2 != "Int 3" || order.UserData.Sstate != 1 || order.UserData.Bzipcode != "06700" {
+		t.Errorf("CS-Cart order user data = %+v", order.UserData)
+	}
+
+	mapping, err := env.Store.GetOrder("maria.valencia@example.com000000011")
+	if err != nil || !mapping.Result || mapping.GamaId != 1 || mapping.MagentoId != "000000011" {
+		t.Errorf("order mapping = %+v, %v", mapping, err)
+	}
+	if mapping, _ := env.Store.GetOrder("maria.valencia@example.com000000012"); mapping.Result {
+		t.Errorf("failed order mapping = %+v, want result false", mapping)
+	}
+}
+
+func TestSyncOrdersSkipsMigratedOrdersUnlessForced(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.CSCart.AddUser(services.GamaUser{Email: "maria.valencia@example.com", Status: "A", UserType: "C"})
+	env.Magento.AddOrder(magentoOrder("000000011", "processing"))
+
+	env.RunJob(t, SyncOrders, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+	job := env.RunJob(t, SyncOrders, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", Reference: "000000011", ResponseCode: 1, Reason: "order already migrated with id 1"})
+	if len(env.CSCart.RequestsTo(http.MethodPost, "api/orders")) != 1 {
+		t.Errorf("migrated order was created again")
+	}
+
+	job = env.RunJob(t, SyncOrders, `{"force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", Reference: "000000011", ResponseCode: 2})
+	if len(env.CSCart.RequestsTo(http.MethodPut, "api/orders/1")) != 1 || len(env.CSCart.Orders()) != 1 {
+		t.Errorf("forced order was not updated: %+v", env.CSCart.Requests())
+	}
+}
+
+func TestSyncOrdersRequiresMigratedUser(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.Magento.AddOrder(magentoOrder("000000011", "complete"))
+
+	job := env.RunJob(t, SyncOrders, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 3, Reason: "user not found on GAMA, migrate the user before its orders"})
+	if len(env.CSCart.Orders()) != 0 {
+		t.Errorf("orders were created for a user missing on GAMA")
+	}
+}
+
+func TestSyncOrdersFailsForUnmappedRegions(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	env.CSCart.AddUser(services.GamaUser{Email: "maria.valencia@example.com", Status: "A", UserType: "C"})
+	order := magentoOrder("000000011", "complete")
+	order.ExtensionAttributes.ShippingAssignments[0].Shipping.Address.RegionId = 9999
+	env.Magento.AddOrder(order)
+
+	job := env.RunJob(t, SyncOrders, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", Reference: "000000011", ResponseCode: 3, Reason: "shipping address: region 9999 of MX has no GAMA state"})
+	if len(env.CSCart.Orders()) != 0 {
+		t.Errorf("order was created without the state of its shipping address")
+	}
+}
+
+func TestSyncOrdersRejectsInvalidBody(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+
+	for _, body := range []string{`{"users": `, `{"users": []}`, `{"dry_run": true, "users": [{"email": "maria.valencia@example.com"}]}`} {
+		response, err := SyncOrders(events.APIGatewayProxyRequest{Body: body})
+		if err != nil || response.StatusCode != http.StatusBadRequest {
+			t.Errorf("SyncOrders(%s) = %d, %v, want %d", body, response.StatusCode, err, http.StatusBadRequest)
+		}
+	}
+	if len(env.CSCart.Requests()) != 0 {
+		t.Errorf("CS-Cart was called for an invalid body: %+v", env.CSCart.Requests())
+	}
+
+	response, _ := SyncOrders(events.APIGatewayProxyRequest{Body: `{"dry_run": true, "users": [{"email": "maria.valencia@example.com"}]}`})
+	if response.Body != "dry_run is only supported on POST /users with a list of users" {
+		t.Errorf("SyncOrders with dry_run = %q, want the message of POST /users", response.Body)
+	}
+}
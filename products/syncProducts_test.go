@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+func magentoProduct(sku string, name string) services.MagentoProduct {
+	return services.MagentoProduct{
+		Sku:    sku,
+		Name:   name,
+		Price:  4599.9,
+		Status: 1,
+		TypeId: "simple",
+		Weight: 12.5,
+		ExtensionAttributes: services.MagentoProductExtension{
+			CategoryLinks: []services.MagentoCategoryLink{{Position: 0, CategoryId: "4"}},
+			StockItem:     &services.MagentoStockItem{Qty: 8, IsInStock: true},
+		},
+		CustomAttributes: []services.ProductAttribute{
+			{Code: "description", Value: "<p>Mesa de comedor de roble</p>"},
+			{Code: "short_description", Value: "Mesa de roble"},
+			{Code: "category_ids", Value: []interface{}{"4"}},
+		},
+	}
+}
+
+func newEnvironment(t *testing.T) *fakes.Environment {
+	t.Setenv("gamaDefaultCategory", "12")
+	return fakes.NewEnvironment(t)
+}
+
+func TestSyncProductsCreatesProductsAndMappings(t *testing.T) {
+	env := newEnvironment(t)
+	env.Magento.AddProduct(magentoProduct("MESA-01", "Mesa Roble"))
+	disabled := magentoProduct("SILLA-01", "Silla Roble")
+	disabled.Status = 2
+	disabled.ExtensionAttributes.StockItem.IsInStock = false
+	env.Magento.AddProduct(disabled)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01", "SILLA-01", "BANCO-01"]}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "MESA-01", ResponseCode: 1},
+		services.BodyResult{Reference: "SILLA-01", ResponseCode: 1},
+		services.BodyResult{Reference: "BANCO-01", ResponseCode: 3, Reason: "Not product found on magento databse"},
+	)
+	if job.Type != services.ProductsJob || job.Total != 3 || job.Results[0].Entity != "product" {
+		t.Errorf("job = %+v, want a products job of 3 skus", job)
+	}
+
+	products := env.CSCart.Products()
+	table := products[1]
+	if table.ProductCode != "MESA-01" || table.Product != "Mesa Roble" || table.Price != 4599.9 || table.Status != "A" || table.Weight != 12.5 || table.Amount != 8 {
+		t.Errorf("CS-Cart product = %+v", table)
+	}
+	if table.FullDescription != "<p>Mesa de comedor de roble</p>" || table.ShortDescription != "Mesa de roble" || table.MainCategory != 12 || len(table.CategoryIds) != 1 {
+		t.Errorf("CS-Cart product = %+v", table)
+	}
+	if chair := products[2]; chair.Status != "D" || chair.Amount != 0 {
+		t.Errorf("disabled CS-Cart product = %+v, want status D without stock", chair)
+	}
+
+	mapping, err := env.Store.GetProduct("MESA-01")
+	if err != nil || !mapping.Result || mapping.GamaId != 1 || mapping.MagentoId != 1 {
+		t.Errorf("product mapping = %+v, %v", mapping, err)
+	}
+}
+
+func TestSyncProductsSkipsMigratedProductsUnlessForced(t *testing.T) {
+	env := newEnvironment(t)
+	env.Magento.AddProduct(magentoProduct("MESA-01", "Mesa Roble"))
+
+	env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 1, Reason: "product already migrated with id 1"})
+	if len(env.CSCart.RequestsTo(http.MethodPost, "api/products")) != 1 {
+		t.Errorf("migrated product was created again")
+	}
+
+	job = env.RunJob(t, SyncProducts, `{"force": true, "skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 2})
+	if len(env.CSCart.RequestsTo(http.MethodPut, "api/products/1")) != 1 || len(env.CSCart.Products()) != 1 {
+		t.Errorf("forced product was not updated: %+v", env.CSCart.Requests())
+	}
+}
+
+func TestSyncProductsAllPagesThroughMagento(t *testing.T) {
+	env := newEnvironment(t)
+	for _, sku := range []string{"MESA-01", "SILLA-01", "BANCO-01"} {
+		env.Magento.AddProduct(magentoProduct(sku, sku))
+	}
+	env.CSCart.Fail(http.MethodPost, "api/products", 0, http.StatusInternalServerError)
+
+	job := env.RunJob(t, SyncProducts, `{"all": true, "page_size": 2}`)
+
+	if job.Status != services.JobFinished || job.Checkpoint == nil || !job.Checkpoint.Finished {
+		t.Fatalf("job = %+v, want finished checkpoint", job)
+	}
+	if job.Checkpoint.Id != services.ProductsCheckpointId || job.Checkpoint.Processed != 3 || job.Checkpoint.Created != 2 || job.Checkpoint.Failed != 1 || job.Checkpoint.LastPage != 2 {
+		t.Errorf("checkpoint = %+v", job.Checkpoint)
+	}
+	if len(job.Results) != 1 || job.Results[0].Reference != "SILLA-01" {
+		t.Errorf("results = %+v, want only the failed product", job.Results)
+	}
+	if mapping, _ := env.Store.GetProduct("SILLA-01"); mapping.Result {
+		t.Errorf("failed product mapping = %+v, want result false", mapping)
+	}
+}
+
+func TestSyncProductsRequiresDefaultCategory(t *testing.T) {
+	t.Setenv("gamaDefaultCategory", "")
+	env := fakes.NewEnvironment(t)
+	env.Magento.AddProduct(magentoProduct("MESA-01", "Mesa Roble"))
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 3, Reason: "there is no GAMA category for product MESA-01, migrate its categories or define the default category of the stage"})
+	if len(env.CSCart.Products()) != 0 {
+		t.Errorf("product was created without category")
+	}
+}
+
+func TestSyncProductsRejectsInvalidBody(t *testing.T) {
+	newEnvironment(t)
+
+	for _, body := range []string{`{"skus": `, `{"skus": []}`, `{"dry_run": true, "skus": ["MESA-01"]}`} {
+		response, err := SyncProducts(events.APIGatewayProxyRequest{Body: body})
+		if err != nil || response.StatusCode != http.StatusBadRequest {
+			t.Errorf("SyncProducts(%s) = %d, %v, want %d", body, response.StatusCode, err, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSyncProductsUsesMigratedCategories(t *testing.T) {
+	env := newEnvironment(t)
+	env.Store.SaveCategory(services.CategoryMapping{Id: "4", GamaId: 21, Result: true})
+	env.Store.SaveCategory(services.CategoryMapping{Id: "5", GamaId: 22, Result: true})
+	env.Store.SaveCategory(services.CategoryMapping{Id: "6", Result: false})
+	product := magentoProduct("MESA-01", "Mesa Roble")
+	product.ExtensionAttributes.CategoryLinks = []services.MagentoCategoryLink{
+		{Position: 3, CategoryId: "4"},
+		{Position: 1, CategoryId: "5"},
+		{Position: 0, CategoryId: "6"},
+	}
+	env.Magento.AddProduct(product)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 1})
+	table := env.CSCart.Products()[1]
+	if table.MainCategory != 22 || len(table.CategoryIds) != 2 || table.CategoryIds[0] != 22 || table.CategoryIds[1] != 21 {
+		t.Errorf("CS-Cart product categories = %d %v, want main 22 and [22 21]", table.MainCategory, table.CategoryIds)
+	}
+}
+
+// addConfigurable adds the configurable SILLA with a red small and a blue big chair
+func addConfigurable(env *fakes.Environment, childrenFirst bool) {
+	env.Magento.AddAttribute(services.MagentoProductAttribute{
+		AttributeId:          93,
+		AttributeCode:        "color",
+		FrontendInput:        "select",
+		IsUserDefined:        true,
+		DefaultFrontendLabel: "Color",
+		Options:              []services.MagentoAttributeOption{{Label: " ", Value: ""}, {Label: "Rojo", Value: "49"}, {Label: "Azul", Value: "50"}},
+	})
+	env.Magento.AddAttribute(services.MagentoProductAttribute{
+		AttributeId:          144,
+		AttributeCode:        "size",
+		FrontendInput:        "select",
+		IsUserDefined:        true,
+		DefaultFrontendLabel: "Tamaño",
+		Options:              []services.MagentoAttributeOption{{Label: "Chica", Value: "60"}, {Label: "Grande", Value: "61"}},
+	})
+
+	red := magentoProduct("SILLA-R-CH", "Silla Eames Rojo Chica")
+	red.Price, red.Visibility = 1200, 1
+	red.ExtensionAttributes.CategoryLinks = nil
+	red.CustomAttributes = []services.ProductAttribute{{Code: "color", Value: "49"}, {Code: "size", Value: "60"}}
+	blue := magentoProduct("SILLA-A-GR", "Silla Eames Azul Grande")
+	blue.Price, blue.Visibility = 1500, 1
+	blue.ExtensionAttributes.CategoryLinks = nil
+	blue.ExtensionAttributes.StockItem.Qty = 3
+	blue.CustomAttributes = []services.ProductAttribute{{Code: "color", Value: "50"}, {Code: "size", Value: "61"}}
+	configurable := magentoProduct("SILLA", "Silla Eames")
+	configurable.TypeId = "configurable"
+	configurable.Price = 0
+
+	if childrenFirst {
+		env.Magento.AddProduct(red)
+		env.Magento.AddProduct(blue)
+		env.Magento.AddProduct(configurable)
+	} else {
+		env.Magento.AddProduct(configurable)
+		env.Magento.AddProduct(red)
+		env.Magento.AddProduct(blue)
+	}
+	env.Magento.AddConfigurable("SILLA", []services.MagentoConfigurableOption{
+		{Id: 1, AttributeId: "93", Label: "Color", Position: 0},
+		{Id: 2, AttributeId: "144", Label: "Tamaño", Position: 1},
+	}, "SILLA-R-CH", "SILLA-A-GR")
+}
+
+func TestSyncProductsCreatesConfigurableAsVariations(t *testing.T) {
+	env := newEnvironment(t)
+	addConfigurable(env, false)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["SILLA", "SILLA-A-GR"]}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Reference: "SILLA", ResponseCode: 1},
+		services.BodyResult{Reference: "SILLA-A-GR", ResponseCode: 1, Reason: "variation of SILLA, it is migrated with its configurable product"},
+	)
+
+	features := env.CSCart.Features()
+	if len(features) != 2 || features[1].Description != "Color" || len(features[1].Variants) != 2 || features[2].Description != "Tamaño" {
+		t.Fatalf("CS-Cart features = %+v", features)
+	}
+	color, err := env.Store.GetFeature("color")
+	if err != nil || !color.Result || color.FeatureId != 1 || len(color.Variants) != 2 || features[1].Variants[strconv.Itoa(color.Variants["50"])].Variant != "Azul" {
+		t.Errorf("color feature mapping = %+v, %v", color, err)
+	}
+
+	products := env.CSCart.Products()
+	if len(products) != 2 {
+		t.Fatalf("CS-Cart products = %+v, want the 2 variations", products)
+	}
+	red, blue := products[1], products[2]
+	if red.ProductCode != "SILLA-R-CH" || red.Product != "Silla Eames" || red.Price != 1200 || red.MainCategory != 12 || red.FullDescription != "<p>Mesa de comedor de roble</p>" {
+		t.Errorf("parent variation = %+v", red)
+	}
+	if blue.ProductCode != "SILLA-A-GR" || blue.Product != "Silla Eames" || blue.Price != 1500 || blue.Amount != 3 {
+		t.Errorf("child variation = %+v", blue)
+	}
+	size, _ := env.Store.GetFeature("size")
+	if blue.ProductFeatures["1"].VariantId != color.Variants["50"] || blue.ProductFeatures["2"].VariantId != size.Variants["61"] {
+		t.Errorf("child variation features = %+v", blue.ProductFeatures)
+	}
+
+	group := env.CSCart.VariationGroups()[1]
+	if len(group.ProductIds) != 2 || group.ProductIds[0] != 1 || group.ProductIds[1] != 2 || len(group.FeatureIds) != 2 {
+		t.Errorf("variation group = %+v", group)
+	}
+	mapping, _ := env.Store.GetProduct("SILLA")
+	child, _ := env.Store.GetProduct("SILLA-A-GR")
+	if !mapping.Result || mapping.GamaId != 1 || mapping.GroupId != 1 || !child.Result || child.GamaId != 2 || child.ParentSku != "SILLA" {
+		t.Errorf("mappings = %+v %+v", mapping, child)
+	}
+}
+
+func TestSyncProductsReusesChildrenMigratedBeforeConfigurable(t *testing.T) {
+	env := newEnvironment(t)
+	addConfigurable(env, true)
+
+	job := env.RunJob(t, SyncProducts, `{"all": true}`)
+
+	if job.Status != services.JobFinished || job.Checkpoint.Created != 3 || job.Checkpoint.Failed != 0 {
+		t.Fatalf("job = %+v, checkpoint = %+v", job, job.Checkpoint)
+	}
+	if products := env.CSCart.Products(); len(products) != 2 || products[1].Product != "Silla Eames" {
+		t.Errorf("CS-Cart products = %+v, want the children updated as variations", products)
+	}
+	if len(env.CSCart.RequestsTo(http.MethodPut, "api/products/1")) != 1 || len(env.CSCart.RequestsTo(http.MethodPut, "api/products/2")) != 1 {
+		t.Errorf("children were not updated: %+v", env.CSCart.Requests())
+	}
+
+	job = env.RunJob(t, SyncProducts, `{"force": true, "skus": ["SILLA"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "SILLA", ResponseCode: 2})
+	if len(env.CSCart.RequestsTo(http.MethodPut, "api/product_variations_groups/1")) != 1 || len(env.CSCart.VariationGroups()) != 1 || len(env.CSCart.Features()) != 2 {
+		t.Errorf("forced configurable was not updated: %+v", env.CSCart.Requests())
+	}
+}
+
+func TestSyncProductsTurnsFilterFeaturesIntoVariationFeatures(t *testing.T) {
+	env := newEnvironment(t)
+	addConfigurable(env, false)
+	if _, err := services.CreateJob(services.FeaturesJob, services.BodyRequest{}); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	env.Queue.Wait()
+	if color, _ := env.Store.GetFeature("color"); color.Purpose != services.FilterFeaturePurpose {
+		t.Fatalf("color feature mapping = %+v, want the features job to migrate it as a filter", color)
+	}
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["SILLA"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "SILLA", ResponseCode: 1})
+	features := env.CSCart.Features()
+	if len(features) != 2 || features[1].Purpose != services.VariationFeaturePurpose || features[2].Purpose != services.VariationFeaturePurpose {
+		t.Errorf("CS-Cart features = %+v, want the filters updated to variation features", features)
+	}
+	for _, code := range []string{"color", "size"} {
+		if mapping, _ := env.Store.GetFeature(code); !mapping.Result || mapping.Purpose != services.VariationFeaturePurpose {
+			t.Errorf("feature mapping %s = %+v", code, mapping)
+		}
+	}
+	if group := env.CSCart.VariationGroups()[1]; len(group.ProductIds) != 2 || len(group.FeatureIds) != 2 {
+		t.Errorf("variation group = %+v", group)
+	}
+}
+
+func addGallery(env *fakes.Environment, product *services.MagentoProduct) {
+	env.Magento.AddMedia("/m/e/mesa-frente.jpg", []byte("frente"))
+	env.Magento.AddMedia("/m/e/mesa-detalle.jpg", []byte("detalle"))
+	env.Magento.AddMedia("/m/e/mesa-detalle-copia.jpg", []byte("detalle"))
+	product.MediaGalleryEntries = []services.MagentoMediaEntry{
+		{Id: 1, MediaType: "image", Label: "Detalle", Position: 2, File: "/m/e/mesa-detalle.jpg"},
+		{Id: 2, MediaType: "image", Label: "Frente", Position: 1, Types: []string{"image", "small_image", "thumbnail"}, File: "/m/e/mesa-frente.jpg"},
+		{Id: 3, MediaType: "image", Label: "Oculta", Position: 3, Disabled: true, File: "/m/e/mesa-oculta.jpg"},
+		{Id: 4, MediaType: "image", Label: "Copia", Position: 4, File: "/m/e/mesa-detalle-copia.jpg"},
+		{Id: 5, MediaType: "external-video", Label: "Video", Position: 5, File: "/m/e/mesa-video.jpg"},
+	}
+}
+
+func TestSyncProductsUploadsGalleryOnce(t *testing.T) {
+	env := newEnvironment(t)
+	product := magentoProduct("MESA-01", "Mesa Roble")
+	addGallery(env, &product)
+	env.Magento.AddProduct(product)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 1})
+	images := env.CSCart.Images(1)
+	if images.Main == nil || images.Main.Detailed.ImageName != "mesa-frente.jpg" || images.Main.Detailed.Alt != "Frente" || images.Main.Position != 1 || images.Main.Detailed.ImageData != base64.StdEncoding.EncodeToString([]byte("frente")) {
+		t.Errorf("main image = %+v", images.Main)
+	}
+	if len(images.Additional) != 1 || images.Additional[0].Detailed.ImageName != "mesa-detalle.jpg" || images.Additional[0].Position != 2 {
+		t.Errorf("additional images = %+v, want only the detail once", images.Additional)
+	}
+
+	job = env.RunJob(t, SyncProducts, `{"force": true, "skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 2})
+	if images := env.CSCart.Images(1); len(images.Additional) != 1 {
+		t.Errorf("additional images after force = %+v, want the images uploaded once", images.Additional)
+	}
+	// a request by image and the update of the product
+	if len(env.CSCart.RequestsTo(http.MethodPut, "api/products/1")) != 3 {
+		t.Errorf("the images were uploaded again: %+v", env.CSCart.Requests())
+	}
+}
+
+func TestSyncProductsKeepsMainImageCopiedOnTheGallery(t *testing.T) {
+	env := newEnvironment(t)
+	product := magentoProduct("MESA-01", "Mesa Roble")
+	env.Magento.AddMedia("/m/e/mesa-galeria.jpg", []byte("frente"))
+	env.Magento.AddMedia("/m/e/mesa-frente.jpg", []byte("frente"))
+	product.MediaGalleryEntries = []services.MagentoMediaEntry{
+		{Id: 1, MediaType: "image", Label: "Galería", Position: 1, File: "/m/e/mesa-galeria.jpg"},
+		{Id: 2, MediaType: "image", Label: "Frente", Position: 2, Types: []string{"image"}, File: "/m/e/mesa-frente.jpg"},
+	}
+	env.Magento.AddProduct(product)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 1})
+	images := env.CSCart.Images(1)
+	if images.Main == nil || images.Main.Detailed.ImageName != "mesa-frente.jpg" || len(images.Additional) != 0 {
+		t.Errorf("images = %+v, want only the main image", images)
+	}
+
+	env.RunJob(t, SyncProducts, `{"force": true, "skus": ["MESA-01"]}`)
+
+	if images := env.CSCart.Images(1); len(images.Additional) != 0 {
+		t.Errorf("additional images after force = %+v, want the copy of the main image skipped", images.Additional)
+	}
+}
+
+func TestSyncProductsRetriesOnlyFailedImages(t *testing.T) {
+	env := newEnvironment(t)
+	product := magentoProduct("MESA-01", "Mesa Roble")
+	addGallery(env, &product)
+	env.Magento.AddProduct(product)
+	env.CSCart.FailAllAttempts(http.MethodPut, "api/products/1", http.StatusInternalServerError)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	result := job.Results[0]
+	if result.ResponseCode != 3 || !strings.HasPrefix(result.Reason, "product migrated with id 1 but its images failed: error uploading the images of MESA-01") {
+		t.Errorf("result = %+v", result)
+	}
+	if mapping, _ := env.Store.GetProduct("MESA-01"); !mapping.Result || mapping.Images {
+		t.Errorf("product mapping = %+v, want migrated without images", mapping)
+	}
+
+	job = env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 2})
+	if len(env.CSCart.RequestsTo(http.MethodPost, "api/products")) != 1 || env.CSCart.Images(1).Main == nil {
+		t.Errorf("only the images should be sent again: %+v", env.CSCart.Requests())
+	}
+	if mapping, _ := env.Store.GetProduct("MESA-01"); !mapping.Images {
+		t.Errorf("product mapping = %+v, want images", mapping)
+	}
+}
+
+func TestSyncProductsUploadsVariationImages(t *testing.T) {
+	env := newEnvironment(t)
+	addConfigurable(env, false)
+	configurable := magentoProduct("SILLA", "Silla Eames")
+	env.Magento.AddMedia("/s/i/silla.jpg", []byte("silla"))
+	env.Magento.AddMedia("/s/i/silla-azul.jpg", []byte("silla azul"))
+	configurable.Id, configurable.TypeId = 1, "configurable"
+	configurable.MediaGalleryEntries = []services.MagentoMediaEntry{{Id: 1, MediaType: "image", Types: []string{"image"}, File: "/s/i/silla.jpg"}}
+	env.Magento.UpdateProduct(configurable)
+	blue := magentoProduct("SILLA-A-GR", "Silla Eames Azul Grande")
+	blue.Id, blue.Price, blue.Visibility = 3, 1500, 1
+	blue.CustomAttributes = []services.ProductAttribute{{Code: "color", Value: "50"}, {Code: "size", Value: "61"}}
+	blue.MediaGalleryEntries = []services.MagentoMediaEntry{{Id: 2, MediaType: "image", Label: "Azul", Types: []string{"image"}, File: "/s/i/silla-azul.jpg"}}
+	env.Magento.UpdateProduct(blue)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["SILLA"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "SILLA", ResponseCode: 1})
+	if main := env.CSCart.Images(1).Main; main == nil || main.Detailed.ImageName != "silla.jpg" {
+		t.Errorf("parent variation image = %+v, want the configurable image", main)
+	}
+	if main := env.CSCart.Images(2).Main; main == nil || main.Detailed.ImageName != "silla-azul.jpg" || main.Detailed.Alt != "Azul" {
+		t.Errorf("child variation image = %+v, want its own image", main)
+	}
+}
+
+func TestSyncProductsSendsFeatureValues(t *testing.T) {
+	env := newEnvironment(t)
+	material := services.MagentoProductAttribute{AttributeId: 150, AttributeCode: "material", FrontendInput: "select", IsUserDefined: true, DefaultFrontendLabel: "Material",
+		Options: []services.MagentoAttributeOption{{Label: "Roble", Value: "21"}}}
+	ambientes := services.MagentoProductAttribute{AttributeId: 151, AttributeCode: "ambientes", FrontendInput: "multiselect", IsUserDefined: true, DefaultFrontendLabel: "Ambientes",
+		Options: []services.MagentoAttributeOption{{Label: "Comedor", Value: "31"}, {Label: "Cocina", Value: "32"}}}
+	garantia := services.MagentoProductAttribute{AttributeId: 152, AttributeCode: "garantia", FrontendInput: "text", IsUserDefined: true, DefaultFrontendLabel: "Garantía"}
+	for _, attribute := range []services.MagentoProductAttribute{material, ambientes, garantia} {
+		if result := services.ImportMagentoAttribute(attribute, false); result.ResponseCode != 1 {
+			t.Fatalf("ImportMagentoAttribute(%s) = %+v", attribute.AttributeCode, result)
+		}
+	}
+	// the option Pino is added to magento after the features were migrated
+	material.Options = append(material.Options, services.MagentoAttributeOption{Label: "Pino", Value: "22"})
+	env.Magento.AddAttribute(material)
+
+	product := magentoProduct("MESA-01", "Mesa Pino")
+	product.CustomAttributes = append(product.CustomAttributes,
+		services.ProductAttribute{Code: "material", Value: "22"},
+		services.ProductAttribute{Code: "ambientes", Value: "31,32"},
+		services.ProductAttribute{Code: "garantia", Value: "2 años"},
+	)
+	env.Magento.AddProduct(product)
+
+	job := env.RunJob(t, SyncProducts, `{"skus": ["MESA-01"]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Reference: "MESA-01", ResponseCode: 1})
+	mapping, _ := env.Store.GetFeature("material")
+	pino := mapping.Variants["22"]
+	if pino == 0 || len(env.CSCart.Features()[1].Variants) != 2 {
+		t.Fatalf("material mapping = %+v, want the new option added to the feature", mapping)
+	}
+	features := env.CSCart.Products()[1].ProductFeatures
+	if features["1"].VariantId != pino {
+		t.Errorf("material = %+v, want variant %d", features["1"], pino)
+	}
+	if len(features["2"].Variants) != 2 {
+		t.Errorf("ambientes = %+v, want 2 variants", features["2"])
+	}
+	if features["3"].Value != "2 años" {
+		t.Errorf("garantia = %+v, want the text value", features["3"])
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	getCategoriesEndpoint = "categories"
+	categoriesEndpoint    = "api/categories"
+	magentoRootLevel      = 1 // the root catalog (0) and the root categories of the stores (1) are not migrated
+)
+
+// MagentoCategory is a node of the magento categories tree
+type MagentoCategory struct {
+	Id           int               `json:"id"`
+	ParentId     int               `json:"parent_id"`
+	Name         string            `json:"name"`
+	IsActive     bool              `json:"is_active"`
+	Position     int               `json:"position"`
+	Level        int               `json:"level"`
+	ProductCount int               `json:"product_count"`
+	Children     []MagentoCategory `json:"children_data"`
+}
+
+type GamaCategory struct {
+	Category string `json:"category"`
+	ParentId int    `json:"parent_id"`
+	Position int    `json:"position"`
+	Status   string `json:"status"`
+}
+
+type GamaCategoryResponse struct {
+	CategoryId int `json:"category_id"`
+}
+
+// CategoryMapping relates a magento category_id (as string, it is the key) with the GAMA category_id
+type CategoryMapping struct {
+	Id       string `json:"id"`
+	ParentId int    `json:"parent_id"` // magento parent_id
+	GamaId   int    `json:"gama_id,omitempty"`
+	Result   bool   `json:"response_code"`
+}
+
+func GetMagentoCategories() (MagentoCategory, error) {
+	return GetMagentoClient().GetCategories(getCategoriesEndpoint)
+}
+
+// FlattenCategories returns the categories of the tree to migrate with every parent before its children,
+// the siblings sorted by position
+func FlattenCategories(root MagentoCategory) []MagentoCategory {
+	var categories []MagentoCategory
+	if root.Level > magentoRootLevel {
+		categories = append(categories, root)
+	}
+	children := append([]MagentoCategory(nil), root.Children...)
+	sort.SliceStable(children, func(i, j int) bool { return children[i].Position < children[j].Position })
+	for _, child := range children {
+		categories = append(categories, FlattenCategories(child)...)
+	}
+	return categories
+}
+
+// ImportMagentoCategory creates or, with force, updates the magento category on GAMA under the GAMA
+// category of its parent. The parent must be processed before.
+func ImportMagentoCategory(magentoCategory MagentoCategory, force bool) BodyResult {
+	bodyResult := BodyResult{
+		Entity:    "category",
+		Reference: strconv.Itoa(magentoCategory.Id),
+	}
+
+	mapping, err := GetCategoryFromDb(strconv.Itoa(magentoCategory.Id))
+	migrated := err == nil && mapping.Result
+	if migrated && !force {
+		bodyResult.ResponseCode = 1
+		bodyResult.Reason = "category already migrated with id " + strconv.Itoa(mapping.GamaId)
+		return bodyResult
+	}
+
+	gamaCategory, err := translateCategoryInformation(magentoCategory)
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+		return bodyResult
+	}
+
+	mapping = CategoryMapping{
+		Id:       strconv.Itoa(magentoCategory.Id),
+		ParentId: magentoCategory.ParentId,
+		GamaId:   mapping.GamaId,
+	}
+	if migrated {
+		err = GetCSCartClient().UpdateCategory(mapping.GamaId, gamaCategory)
+		bodyResult.ResponseCode = 2
+	} else {
+		var gamaCategoryResponse GamaCategoryResponse
+		gamaCategoryResponse, err = GetCSCartClient().CreateCategory(gamaCategory)
+		mapping.GamaId = gamaCategoryResponse.CategoryId
+		bodyResult.ResponseCode = 1
+	}
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+	}
+
+	mapping.Result = err == nil && mapping.GamaId != 0
+	err = SaveCategoryToDb(mapping)
+	if err != nil {
+		fmt.Println("Error returned by SaveCategoryToDb function: ", err.Error())
+	}
+
+	return bodyResult
+}
+
+func translateCategoryInformation(magentoCategory MagentoCategory) (GamaCategory, error) {
+	gamaCategory := GamaCategory{
+		Category: magentoCategory.Name,
+		Position: magentoCategory.Position,
+		Status:   "A",
+	}
+	if !magentoCategory.IsActive {
+		gamaCategory.Status = "D"
+	}
+
+	// the children of the store root categories are the top level categories of GAMA
+	if magentoCategory.Level-1 > magentoRootLevel {
+		parentId, ok := getGamaCategoryId(strconv.Itoa(magentoCategory.ParentId))
+		if !ok {
+			return gamaCategory, errors.New("parent category " + strconv.Itoa(magentoCategory.ParentId) + " was not migrated")
+		}
+		gamaCategory.ParentId = parentId
+	}
+
+	return gamaCategory, nil
+}
+
+// getGamaCategoryId returns the id of the migrated category with the magento category_id
+func getGamaCategoryId(magentoId string) (int, bool) {
+	mapping, err := GetCategoryFromDb(magentoId)
+	if err != nil || !mapping.Result {
+		return 0, false
+	}
+	return mapping.GamaId, true
+}
@@ -0,0 +1,751 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MagentoClient reads the magento REST api
+type MagentoClient interface {
+	// SearchCustomers requests customers/search with the searchCriteria query of request
+	SearchCustomers(request string) (MagentoResults, error)
+	SearchOrders(request string) (MagentoOrderResults, error)
+	SearchProducts(request string) (MagentoProductResults, error)
+	// GetCategories returns the root of the categories tree
+	GetCategories(request string) (MagentoCategory, error)
+	// GetProducts requests endpoints that return a list of products like configurable-products/{sku}/children
+	GetProducts(request string) ([]MagentoProduct, error)
+	GetConfigurableOptions(request string) ([]MagentoConfigurableOption, error)
+	GetProductAttribute(request string) (MagentoProductAttribute, error)
+	SearchProductAttributes(request string) (MagentoAttributeResults, error)
+	GetCustomerGroup(request string) (MagentoCustomerGroup, error)
+	// GetMedia downloads a file of the catalog/product media folder
+	GetMedia(file string) ([]byte, error)
+}
+
+// CSCartClient writes the users, profiles, usergroups, orders, products, categories and features on the CS-Cart (GAMA) api
+type CSCartClient interface {
+	GetUserByEmail(email string) (GamaResult, error)
+	GetUser(gamaUserId string) (GamaUser, error)
+	// GetProfiles returns the profiles of the user as sent by GAMA, they are compared field by field
+	GetProfiles(email string) ([]map[string]interface{}, error)
+	CreateUser(gamaUser GamaUser) (GamaUserResponse, error)
+	UpdateUser(gamaUserId string, gamaUser GamaUser) (GamaUserResponse, error)
+	CreateProfiles(profileRequest GamaProfileRequest) (GamaProfileResponse, error)
+	UpdateProfiles(profileRequest GamaProfileRequest) (GamaUpdateProfileResponse, error)
+	// DeleteProfiles deletes the profiles of the request by profile_id
+	DeleteProfiles(profileRequest GamaProfileRequest) (GamaUpdateProfileResponse, error)
+	CreateOrder(gamaOrder GamaOrder) (GamaOrderResponse, error)
+	UpdateOrder(orderId int, gamaOrder GamaOrder) error
+	CreateProduct(gamaProduct GamaProduct) (GamaProductResponse, error)
+	UpdateProduct(productId int, gamaProduct GamaProduct) error
+	UpdateProductImages(productId int, images GamaProductImages) error
+	CreateCategory(gamaCategory GamaCategory) (GamaCategoryResponse, error)
+	UpdateCategory(categoryId int, gamaCategory GamaCategory) error
+	GetFeature(featureId int) (GamaFeatureResult, error)
+	CreateFeature(gamaFeature GamaFeature) (GamaFeatureResponse, error)
+	UpdateFeature(featureId int, gamaFeature GamaFeature) error
+	CreateVariationGroup(group GamaVariationGroup) (GamaVariationGroupResponse, error)
+	UpdateVariationGroup(groupId int, group GamaVariationGroup) error
+	GetProfileFields() ([]GamaProfileField, error)
+	// GetStates returns the states of the country
+	GetStates(countryCode string) ([]GamaState, error)
+	CreateUsergroup(gamaUsergroup GamaUsergroup) (GamaUsergroupResponse, error)
+	GetUserUsergroups(gamaUserId string) ([]GamaUserUsergroup, error)
+	// AssignUsergroup makes the user an active member of the usergroup
+	AssignUsergroup(gamaUserId string, usergroupId int) error
+}
+
+type magentoClient struct {
+	baseUrl string
+	bearer  string
+	client  *http.Client
+	limiter *rateLimiter
+}
+
+type csCartClient struct {
+	baseUrl  string
+	user     string
+	password string
+	client   *http.Client
+	limiter  *rateLimiter
+}
+
+var (
+	defaultMagentoClient MagentoClient
+	defaultCSCartClient  CSCartClient
+)
+
+// NewMagentoClient creates a client for the magento api on baseUrl, http.DefaultTransport is used when transport is nil.
+// Its requests are limited by the magentoRateLimit, magentoRateBurst and magentoMaxConcurrent of the env.
+func NewMagentoClient(baseUrl string, bearer string, transport http.RoundTripper) MagentoClient {
+	return &magentoClient{
+		baseUrl: baseUrl,
+		bearer:  bearer,
+		client:  &http.Client{Transport: transport},
+		limiter: getRateLimiter("magento", defaultMagentoRateLimit, defaultMagentoMaxConcurrent),
+	}
+}
+
+// NewCSCartClient creates a client for the CS-Cart api on baseUrl, http.DefaultTransport is used when transport is nil.
+// Its requests are limited by the gamaRateLimit, gamaRateBurst and gamaMaxConcurrent of the env.
+func NewCSCartClient(baseUrl string, user string, password string, transport http.RoundTripper) CSCartClient {
+	return &csCartClient{
+		baseUrl:  baseUrl,
+		user:     user,
+		password: password,
+		client:   &http.Client{Transport: transport},
+		limiter:  getRateLimiter("gama", defaultGamaRateLimit, defaultGamaMaxConcurrent),
+	}
+}
+
+// GetMagentoClient returns the client configured with SetMagentoClient, by default the one of the stage env
+func GetMagentoClient() MagentoClient {
+	if defaultMagentoClient == nil {
+		defaultMagentoClient = NewMagentoClient(os.Getenv("magentoUrl"), os.Getenv("magentoBearer"), nil)
+	}
+	return defaultMagentoClient
+}
+
+func SetMagentoClient(client MagentoClient) {
+	defaultMagentoClient = client
+}
+
+// GetCSCartClient returns the client configured with SetCSCartClient, by default the one of the stage env
+func GetCSCartClient() CSCartClient {
+	if defaultCSCartClient == nil {
+		defaultCSCartClient = NewCSCartClient(os.Getenv("gamaUrl"), os.Getenv("gamaUser"), os.Getenv("gamaPassword"), nil)
+	}
+	return defaultCSCartClient
+}
+
+func SetCSCartClient(client CSCartClient) {
+	defaultCSCartClient = client
+}
+
+func (c *magentoClient) SearchCustomers(request string) (MagentoResults, error) {
+	magentoResults := MagentoResults{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoResults, err
+	}
+
+	err = json.Unmarshal(response, &magentoResults)
+	if err != nil {
+		return magentoResults, err
+	}
+
+	return magentoResults, nil
+}
+
+func (c *magentoClient) SearchOrders(request string) (MagentoOrderResults, error) {
+	magentoOrderResults := MagentoOrderResults{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoOrderResults, err
+	}
+
+	err = json.Unmarshal(response, &magentoOrderResults)
+	if err != nil {
+		return magentoOrderResults, err
+	}
+
+	return magentoOrderResults, nil
+}
+
+func (c *magentoClient) SearchProducts(request string) (MagentoProductResults, error) {
+	magentoProductResults := MagentoProductResults{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoProductResults, err
+	}
+
+	err = json.Unmarshal(response, &magentoProductResults)
+	if err != nil {
+		return magentoProductResults, err
+	}
+
+	return magentoProductResults, nil
+}
+
+func (c *magentoClient) GetCategories(request string) (MagentoCategory, error) {
+	magentoCategory := MagentoCategory{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoCategory, err
+	}
+
+	err = json.Unmarshal(response, &magentoCategory)
+	if err != nil {
+		return magentoCategory, err
+	}
+
+	return magentoCategory, nil
+}
+
+func (c *magentoClient) GetProducts(request string) ([]MagentoProduct, error) {
+	var magentoProducts []MagentoProduct
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoProducts, err
+	}
+
+	err = json.Unmarshal(response, &magentoProducts)
+	if err != nil {
+		return magentoProducts, err
+	}
+
+	return magentoProducts, nil
+}
+
+func (c *magentoClient) GetConfigurableOptions(request string) ([]MagentoConfigurableOption, error) {
+	var magentoOptions []MagentoConfigurableOption
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoOptions, err
+	}
+
+	err = json.Unmarshal(response, &magentoOptions)
+	if err != nil {
+		return magentoOptions, err
+	}
+
+	return magentoOptions, nil
+}
+
+func (c *magentoClient) GetProductAttribute(request string) (MagentoProductAttribute, error) {
+	magentoAttribute := MagentoProductAttribute{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoAttribute, err
+	}
+
+	err = json.Unmarshal(response, &magentoAttribute)
+	if err != nil {
+		return magentoAttribute, err
+	}
+
+	return magentoAttribute, nil
+}
+
+func (c *magentoClient) SearchProductAttributes(request string) (MagentoAttributeResults, error) {
+	magentoAttributeResults := MagentoAttributeResults{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoAttributeResults, err
+	}
+
+	err = json.Unmarshal(response, &magentoAttributeResults)
+	if err != nil {
+		return magentoAttributeResults, err
+	}
+
+	return magentoAttributeResults, nil
+}
+
+func (c *magentoClient) GetCustomerGroup(request string) (MagentoCustomerGroup, error) {
+	magentoCustomerGroup := MagentoCustomerGroup{}
+
+	response, err := c.get(request)
+	if err != nil {
+		fmt.Println("Error returned by magento get function: ", err.Error())
+		return magentoCustomerGroup, err
+	}
+
+	err = json.Unmarshal(response, &magentoCustomerGroup)
+	if err != nil {
+		return magentoCustomerGroup, err
+	}
+
+	return magentoCustomerGroup, nil
+}
+
+// GetMedia downloads the file from the media folder of the host of the api, magento serves it on
+// media/catalog/product next to the rest/ path of the api
+func (c *magentoClient) GetMedia(file string) ([]byte, error) {
+	host := c.baseUrl
+	if index := strings.Index(host, "rest/"); index >= 0 {
+		host = host[:index]
+	}
+	return c.download(host + "media/catalog/product/" + strings.TrimPrefix(file, "/"))
+}
+
+func (c *magentoClient) get(request string) ([]byte, error) {
+	return c.download(c.baseUrl + request)
+}
+
+func (c *magentoClient) download(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil) // Create a new request using http
+	if err != nil {
+		fmt.Println("Error create http object ("+url+") function magento get: ", err.Error())
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+c.bearer) // Add authorization header to the req
+
+	resp, err := doWithRetries(requestsContext(), c.client, c.limiter, req, nil)
+	if err != nil {
+		fmt.Println("Error on request of endpoint ("+url+"): ", err.Error())
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("magento endpoint (" + url + ") returned a non 200 status, reurned: " + resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("Error reading resp.Body function magento get: ", err.Error())
+		return nil, err
+	}
+	return body, nil
+}
+
+func (c *csCartClient) GetUserByEmail(email string) (GamaResult, error) {
+	var gamaResult = GamaResult{}
+
+	body, err := c.send(http.MethodGet, getUserByEmailEndpoint+email+"&"+gamaParam, nil)
+	if err != nil {
+		return gamaResult, err
+	}
+
+	json.Unmarshal(body, &gamaResult)
+
+	return gamaResult, nil
+}
+
+func (c *csCartClient) GetUser(gamaUserId string) (GamaUser, error) {
+	var gamaUser = GamaUser{}
+
+	body, err := c.send(http.MethodGet, userEndpoint+"/"+gamaUserId+"&"+gamaParam, nil)
+	if err != nil {
+		return gamaUser, err
+	}
+
+	err = json.Unmarshal(body, &gamaUser)
+	if err != nil {
+		return gamaUser, err
+	}
+
+	return gamaUser, nil
+}
+
+func (c *csCartClient) GetProfiles(email string) ([]map[string]interface{}, error) {
+	var profilesResponse = struct {
+		Profiles []map[string]interface{} `json:"profiles"`
+	}{}
+
+	body, err := c.send(http.MethodGet, getProfilesByEmailEndpoint+email+"&"+gamaParam, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(body, &profilesResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return profilesResponse.Profiles, nil
+}
+
+// CreateUser creates the user, a POST that failed after GAMA saved the user is not sent again: the user
+// is looked up by email before retrying it and the one found is returned
+func (c *csCartClient) CreateUser(gamaUser GamaUser) (GamaUserResponse, error) {
+	var createdUser = GamaUserResponse{}
+
+	body, err := c.sendChecked(http.MethodPost, userEndpoint+"&"+gamaParam, gamaUser, func() (bool, error) {
+		var err error
+		createdUser, err = c.findCreatedUser(gamaUser.Email)
+		return createdUser.UserId != "", err
+	})
+	if err == errRequestApplied {
+		fmt.Println("The user " + gamaUser.Email + " was created by a failed request, using user_id " + createdUser.UserId)
+		return createdUser, nil
+	}
+	if err != nil {
+		return createdUser, err
+	}
+
+	err = json.Unmarshal(body, &createdUser)
+	if err != nil {
+		return createdUser, errors.New("gama returned an invalid response creating the user " + gamaUser.Email + ": " + err.Error())
+	}
+	if createdUser.UserId == "" {
+		return createdUser, errors.New("gama returned no user_id creating the user " + gamaUser.Email)
+	}
+
+	return createdUser, nil
+}
+
+// findCreatedUser returns the user_id and the main profile of the user with the email, empty when GAMA doesn't have it
+func (c *csCartClient) findCreatedUser(email string) (GamaUserResponse, error) {
+	var gamaUserResponse = GamaUserResponse{}
+
+	gamaResult, err := c.GetUserByEmail(email)
+	if err != nil || len(gamaResult.Users) == 0 {
+		return gamaUserResponse, err
+	}
+	gamaUserResponse.UserId = gamaResult.Users[0].Id
+
+	profiles, err := c.GetProfiles(email)
+	if err != nil {
+		return gamaUserResponse, err
+	}
+	for _, profile := range profiles {
+		if fmt.Sprint(profile["profile_type"]) == "P" {
+			gamaUserResponse.ProfileId, _ = strconv.Atoi(fmt.Sprint(profile["profile_id"]))
+		}
+	}
+
+	return gamaUserResponse, nil
+}
+
+func (c *csCartClient) UpdateUser(gamaUserId string, gamaUser GamaUser) (GamaUserResponse, error) {
+	return c.sendUser(http.MethodPut, userEndpoint+"/"+gamaUserId+"&"+gamaParam, gamaUser)
+}
+
+func (c *csCartClient) sendUser(method string, endpoint string, gamaUser GamaUser) (GamaUserResponse, error) {
+	var gamaUserResponse = GamaUserResponse{}
+
+	body, err := c.send(method, endpoint, gamaUser)
+	if err != nil {
+		return gamaUserResponse, err
+	}
+
+	json.Unmarshal(body, &gamaUserResponse)
+
+	return gamaUserResponse, nil
+}
+
+func (c *csCartClient) CreateProfiles(profileRequest GamaProfileRequest) (GamaProfileResponse, error) {
+	var gamaProfileResponse = GamaProfileResponse{}
+
+	body, err := c.send(http.MethodPost, profilesEndpoint+"&"+gamaParam, profileRequest)
+	if err != nil {
+		return gamaProfileResponse, err
+	}
+
+	json.Unmarshal(body, &gamaProfileResponse)
+
+	return gamaProfileResponse, nil
+}
+
+func (c *csCartClient) UpdateProfiles(profileRequest GamaProfileRequest) (GamaUpdateProfileResponse, error) {
+	var gamaUpdateProfileResponse = GamaUpdateProfileResponse{}
+
+	body, err := c.send(http.MethodPut, profilesEndpoint+"/1"+"&"+gamaParam, profileRequest)
+	if err != nil {
+		return gamaUpdateProfileResponse, err
+	}
+
+	json.Unmarshal(body, &gamaUpdateProfileResponse)
+
+	return gamaUpdateProfileResponse, nil
+}
+
+func (c *csCartClient) DeleteProfiles(profileRequest GamaProfileRequest) (GamaUpdateProfileResponse, error) {
+	var gamaDeleteProfileResponse = GamaUpdateProfileResponse{}
+
+	body, err := c.send(http.MethodDelete, profilesEndpoint+"/1"+"&"+gamaParam, profileRequest)
+	if err != nil {
+		return gamaDeleteProfileResponse, err
+	}
+
+	err = json.Unmarshal(body, &gamaDeleteProfileResponse)
+	if err != nil {
+		return gamaDeleteProfileResponse, err
+	}
+
+	return gamaDeleteProfileResponse, nil
+}
+
+func (c *csCartClient) CreateOrder(gamaOrder GamaOrder) (GamaOrderResponse, error) {
+	var gamaOrderResponse = GamaOrderResponse{}
+
+	body, err := c.send(http.MethodPost, ordersEndpoint+"&"+gamaParam, gamaOrder)
+	if err != nil {
+		return gamaOrderResponse, err
+	}
+
+	err = json.Unmarshal(body, &gamaOrderResponse)
+	if err != nil {
+		return gamaOrderResponse, err
+	}
+	if gamaOrderResponse.OrderId == 0 {
+		return gamaOrderResponse, errors.New("gama endpoint (" + ordersEndpoint + ") didn't return the order_id")
+	}
+
+	return gamaOrderResponse, nil
+}
+
+func (c *csCartClient) UpdateOrder(orderId int, gamaOrder GamaOrder) error {
+	_, err := c.send(http.MethodPut, ordersEndpoint+"/"+strconv.Itoa(orderId)+"&"+gamaParam, gamaOrder)
+	return err
+}
+
+func (c *csCartClient) CreateProduct(gamaProduct GamaProduct) (GamaProductResponse, error) {
+	var gamaProductResponse = GamaProductResponse{}
+
+	body, err := c.send(http.MethodPost, productsEndpoint+"&"+gamaParam, gamaProduct)
+	if err != nil {
+		return gamaProductResponse, err
+	}
+
+	err = json.Unmarshal(body, &gamaProductResponse)
+	if err != nil {
+		return gamaProductResponse, err
+	}
+	if gamaProductResponse.ProductId == 0 {
+		return gamaProductResponse, errors.New("gama endpoint (" + productsEndpoint + ") didn't return the product_id")
+	}
+
+	return gamaProductResponse, nil
+}
+
+func (c *csCartClient) UpdateProduct(productId int, gamaProduct GamaProduct) error {
+	_, err := c.send(http.MethodPut, productsEndpoint+"/"+strconv.Itoa(productId)+"&"+gamaParam, gamaProduct)
+	return err
+}
+
+// UpdateProductImages adds the images to the product, the images it already has are kept
+func (c *csCartClient) UpdateProductImages(productId int, images GamaProductImages) error {
+	_, err := c.send(http.MethodPut, productsEndpoint+"/"+strconv.Itoa(productId)+"&"+gamaParam, images)
+	return err
+}
+
+func (c *csCartClient) CreateCategory(gamaCategory GamaCategory) (GamaCategoryResponse, error) {
+	var gamaCategoryResponse = GamaCategoryResponse{}
+
+	body, err := c.send(http.MethodPost, categoriesEndpoint+"&"+gamaParam, gamaCategory)
+	if err != nil {
+		return gamaCategoryResponse, err
+	}
+
+	err = json.Unmarshal(body, &gamaCategoryResponse)
+	if err != nil {
+		return gamaCategoryResponse, err
+	}
+	if gamaCategoryResponse.CategoryId == 0 {
+		return gamaCategoryResponse, errors.New("gama endpoint (" + categoriesEndpoint + ") didn't return the category_id")
+	}
+
+	return gamaCategoryResponse, nil
+}
+
+func (c *csCartClient) UpdateCategory(categoryId int, gamaCategory GamaCategory) error {
+	_, err := c.send(http.MethodPut, categoriesEndpoint+"/"+strconv.Itoa(categoryId)+"&"+gamaParam, gamaCategory)
+	return err
+}
+
+func (c *csCartClient) GetFeature(featureId int) (GamaFeatureResult, error) {
+	var gamaFeature = GamaFeatureResult{}
+
+	body, err := c.send(http.MethodGet, featuresEndpoint+"/"+strconv.Itoa(featureId)+"&"+gamaParam, nil)
+	if err != nil {
+		return gamaFeature, err
+	}
+
+	err = json.Unmarshal(body, &gamaFeature)
+	if err != nil {
+		return gamaFeature, err
+	}
+
+	return gamaFeature, nil
+}
+
+func (c *csCartClient) CreateFeature(gamaFeature GamaFeature) (GamaFeatureResponse, error) {
+	var gamaFeatureResponse = GamaFeatureResponse{}
+
+	body, err := c.send(http.MethodPost, featuresEndpoint+"&"+gamaParam, gamaFeature)
+	if err != nil {
+		return gamaFeatureResponse, err
+	}
+
+	err = json.Unmarshal(body, &gamaFeatureResponse)
+	if err != nil {
+		return gamaFeatureResponse, err
+	}
+	if gamaFeatureResponse.FeatureId == 0 {
+		return gamaFeatureResponse, errors.New("gama endpoint (" + featuresEndpoint + ") didn't return the feature_id")
+	}
+
+	return gamaFeatureResponse, nil
+}
+
+func (c *csCartClient) UpdateFeature(featureId int, gamaFeature GamaFeature) error {
+	_, err := c.send(http.MethodPut, featuresEndpoint+"/"+strconv.Itoa(featureId)+"&"+gamaParam, gamaFeature)
+	return err
+}
+
+func (c *csCartClient) CreateVariationGroup(group GamaVariationGroup) (GamaVariationGroupResponse, error) {
+	var groupResponse = GamaVariationGroupResponse{}
+
+	body, err := c.send(http.MethodPost, variationGroupsEndpoint+"&"+gamaParam, group)
+	if err != nil {
+		return groupResponse, err
+	}
+
+	err = json.Unmarshal(body, &groupResponse)
+	if err != nil {
+		return groupResponse, err
+	}
+	if groupResponse.GroupId == 0 {
+		return groupResponse, errors.New("gama endpoint (" + variationGroupsEndpoint + ") didn't return the group_id")
+	}
+
+	return groupResponse, nil
+}
+
+func (c *csCartClient) UpdateVariationGroup(groupId int, group GamaVariationGroup) error {
+	_, err := c.send(http.MethodPut, variationGroupsEndpoint+"/"+strconv.Itoa(groupId)+"&"+gamaParam, group)
+	return err
+}
+
+// send requests the endpoint with the json of payload (when not nil) and returns the body of 2xx responses
+func (c *csCartClient) send(method string, endpoint string, payload interface{}) ([]byte, error) {
+	return c.sendChecked(method, endpoint, payload, nil)
+}
+
+// sendChecked is send with the check of doWithRetries that allows retrying a POST on 5xx and network errors
+func (c *csCartClient) sendChecked(method string, endpoint string, payload interface{}, applied func() (bool, error)) ([]byte, error) {
+	url := c.baseUrl + endpoint
+
+	var requestBody []byte
+	if payload != nil {
+		var err error
+		requestBody, err = json.Marshal(payload)
+		if err != nil {
+			return nil, errors.New("error marshaling the payload of gama endpoint (" + url + ")")
+		}
+	}
+
+	request, err := http.NewRequest(method, url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		fmt.Println("Error create http object ("+url+") function gama send: ", err.Error())
+		return nil, err
+	}
+	request.SetBasicAuth(c.user, c.password)
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := doWithRetries(requestsContext(), c.client, c.limiter, request, applied)
+	if err == errRequestApplied {
+		return nil, err
+	}
+	if err != nil {
+		fmt.Println("Error on request of endpoint ("+url+"): ", err.Error())
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		return nil, errors.New("gama endpoint (" + url + ") returned a non 2xx status, reurned: " + response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		fmt.Println("Error reading resp.Body function gama send: ", err.Error())
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (c *csCartClient) GetProfileFields() ([]GamaProfileField, error) {
+	var profileFields []GamaProfileField
+
+	body, err := c.send(http.MethodGet, profileFieldsEndpoint+"?items_per_page=1000&"+gamaParam, nil)
+	if err != nil {
+		return profileFields, err
+	}
+
+	err = json.Unmarshal(body, &profileFields)
+	if err != nil {
+		return profileFields, err
+	}
+
+	return profileFields, nil
+}
+
+func (c *csCartClient) GetStates(countryCode string) ([]GamaState, error) {
+	var gamaStatesResult = GamaStatesResult{}
+
+	body, err := c.send(http.MethodGet, getStatesEndpoint+url.QueryEscape(countryCode)+"&"+gamaParam, nil)
+	if err != nil {
+		return gamaStatesResult.States, err
+	}
+
+	err = json.Unmarshal(body, &gamaStatesResult)
+	if err != nil {
+		return gamaStatesResult.States, err
+	}
+
+	return gamaStatesResult.States, nil
+}
+
+func (c *csCartClient) CreateUsergroup(gamaUsergroup GamaUsergroup) (GamaUsergroupResponse, error) {
+	var gamaUsergroupResponse = GamaUsergroupResponse{}
+
+	body, err := c.send(http.MethodPost, usergroupsEndpoint+"&"+gamaParam, gamaUsergroup)
+	if err != nil {
+		return gamaUsergroupResponse, err
+	}
+
+	err = json.Unmarshal(body, &gamaUsergroupResponse)
+	if err != nil {
+		return gamaUsergroupResponse, err
+	}
+	if gamaUsergroupResponse.UsergroupId == 0 {
+		return gamaUsergroupResponse, errors.New("gama endpoint (" + usergroupsEndpoint + ") didn't return the usergroup_id")
+	}
+
+	return gamaUsergroupResponse, nil
+}
+
+func (c *csCartClient) GetUserUsergroups(gamaUserId string) ([]GamaUserUsergroup, error) {
+	var usergroups []GamaUserUsergroup
+
+	body, err := c.send(http.MethodGet, userEndpoint+"/"+gamaUserId+"/usergroups&"+gamaParam, nil)
+	if err != nil {
+		return usergroups, err
+	}
+
+	err = json.Unmarshal(body, &usergroups)
+	if err != nil {
+		return usergroups, err
+	}
+
+	return usergroups, nil
+}
+
+func (c *csCartClient) AssignUsergroup(gamaUserId string, usergroupId int) error {
+	_, err := c.send(http.MethodPut, userEndpoint+"/"+gamaUserId+"/usergroups/"+strconv.Itoa(usergroupId)+"&"+gamaParam, map[string]string{"status": "A"})
+	return err
+}
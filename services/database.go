@@ -1,19 +1,35 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"errors"
+	"strconv"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
+var ErrNotFound = errors.New("Element not found")
+
+const (
+	dynamoBatchSize     = 25  // the most items of a BatchWriteItem
+	dynamoBatchGetSize  = 100 // the most keys of a BatchGetItem
+	dynamoBatchAttempts = 5
+)
+
 type BodyResult struct {
-	Email        string `json:"email"`
-	ResponseCode int    `json:"response_code"`
-	Reason       string `json:"reason"`
+	Email        string         `json:"email,omitempty"`
+	Entity       string         `json:"entity,omitempty"`    // empty for users, the entity of other migrations
+	Reference    string         `json:"reference,omitempty"` // magento id of the entity
+	ResponseCode int            `json:"response_code"`
+	Reason       string         `json:"reason"`
+	ErrorCode    string         `json:"error_code,omitempty"` // with code 3, set for the errors of the request data like invalid_hash_format
+	Preview      *ImportPreview `json:"preview,omitempty"`    // only on dry runs
+	Diff         *UserDiff      `json:"diff,omitempty"`       // only on updates
 }
 
 type AddressProfile struct {
@@ -21,157 +37,163 @@ type AddressProfile struct {
 	GamaId    int    `json:"gama_id,omitempty"`
 	Email     string `json:"email"`
 	Result    bool   `json:"response_code"`
+	Orphaned  bool   `json:"orphaned,omitempty"` // the address was deleted on magento
 }
 
 type UserHash struct {
-	Email string `json:"email"`
-	Hash  string `json:"hash"`
+	Email   string `json:"email"`
+	Hash    string `json:"hash"`
+	Pending bool   `json:"pending,omitempty"` // imported from a customer export and not sent to GAMA yet
 }
 
-func SaveResultToDb(bodyResult BodyResult) {
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	})) // Creating session for client
-	svc := dynamodb.New(sess) // Create DynamoDB client
+// Checkpoint is the cursor of a bulk migration, used to resume it on the next invocation
+type Checkpoint struct {
+	Id            string `json:"id"`
+	PageSize      int    `json:"page_size"`
+	Total         int    `json:"total"`          // magento total_count
+	LastPage      int    `json:"last_page"`      // magento pages fully processed
+	LastEntityId  int    `json:"last_entity_id"` // last magento customer processed
+	Processed     int    `json:"processed"`
+	Created       int    `json:"created"`
+	Updated       int    `json:"updated"`
+	Unchanged     int    `json:"unchanged"`
+	Skipped       int    `json:"skipped"`
+	Failed        int    `json:"failed"`
+	Finished      bool   `json:"finished"`
+	HighWaterMark string `json:"high_water_mark,omitempty"` // magento updated_at reached by the delta sync
+	ProcessedIds  []int  `json:"processed_ids,omitempty"`   // magento ids processed by a categories job
+	StartedAt     string `json:"started_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
 
-	av, err := dynamodbattribute.MarshalMap(bodyResult)
+func SaveResultToDb(bodyResult BodyResult) {
+	err := GetStore().SaveResult(bodyResult)
 	if err != nil {
-		fmt.Println("Error marshalling item: ", err.Error())
+		fmt.Println("Error saving the result of " + bodyResult.Email + ": " + err.Error())
 	}
+}
 
-	tableName := os.Getenv("MIGRATED_USERS_TABLE")
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
-	}
-	_, err = svc.PutItem(input)
-	if err != nil {
-		fmt.Println("Got error calling PutItem: ", err.Error())
-	}
+// GetMigratedUser returns an empty result when the user was never migrated
+func GetMigratedUser(email string) (BodyResult, error) {
+	return GetStore().GetMigratedUser(email)
+}
 
+func SaveAddressToDb(addressProfile AddressProfile) error {
+	return GetStore().SaveAddress(addressProfile)
 }
 
-func GetMigratedUser(email string) (BodyResult, error) {
-	item := BodyResult{}
+func GetAddressFromDb(magentoId string) (AddressProfile, error) {
+	return GetStore().GetAddress(magentoId)
+}
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	})) // Creating session for client
-	svc := dynamodb.New(sess) // Create DynamoDB client
+func SaveHashToDb(userHash UserHash) error {
+	return GetStore().SaveHash(userHash)
+}
 
-	// GetItem request
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(os.Getenv("MIGRATED_USERS_TABLE")),
-		Key: map[string]*dynamodb.AttributeValue{
-			"email": {
-				S: aws.String(email),
-			},
-		},
-	})
+// SaveHashesToDb writes the hashes in batches of the store
+func SaveHashesToDb(userHashes []UserHash) error {
+	return GetStore().SaveHashes(userHashes)
+}
 
-	// Checking for errors, return error
-	if err != nil {
-		fmt.Println(err.Error())
-		return item, err
-	}
+func GetHashFromDb(email string) (UserHash, error) {
+	return GetStore().GetHash(email)
+}
 
-	// Checking type
-	if len(result.Item) == 0 {
-        fmt.Println("element not found")
-    }
+// GetHashesFromDb returns the saved hashes of the emails by email, the emails without hash are missing
+func GetHashesFromDb(emails []string) (map[string]UserHash, error) {
+	return GetStore().GetHashes(emails)
+}
 
-	// result is of type *dynamodb.GetItemOutput
-	// result.Item is of type map[string]*dynamodb.AttributeValue
-	// UnmarshallMap result.item into item
-	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+func SaveOrderToDb(orderMapping OrderMapping) error {
+	return GetStore().SaveOrder(orderMapping)
+}
 
-	if err != nil {
-		return item, err
-	}
+func GetOrderFromDb(key string) (OrderMapping, error) {
+	return GetStore().GetOrder(key)
+}
 
-	return item, nil
+func SaveProductToDb(productMapping ProductMapping) error {
+	return GetStore().SaveProduct(productMapping)
 }
 
-func SaveAddressToDb(addressProfile AddressProfile) error{
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := dynamodb.New(sess)
+func GetProductFromDb(sku string) (ProductMapping, error) {
+	return GetStore().GetProduct(sku)
+}
 
-	av, err := dynamodbattribute.MarshalMap(addressProfile)
-	if err != nil {
-		fmt.Println("Error marshalling item: ", err.Error())
-		return err
-	}
+func SaveCategoryToDb(categoryMapping CategoryMapping) error {
+	return GetStore().SaveCategory(categoryMapping)
+}
 
-	tableName := os.Getenv("MIGRATED_ADDRESSES_TABLE")
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
-	}
-	_, err = svc.PutItem(input)
-	if err != nil {
-		fmt.Println("Got error calling PutItem: ", err.Error())
-		return err
-	}
+func GetCategoryFromDb(id string) (CategoryMapping, error) {
+	return GetStore().GetCategory(id)
+}
 
-	return nil
+func SaveFeatureToDb(featureMapping FeatureMapping) error {
+	return GetStore().SaveFeature(featureMapping)
 }
 
-func GetAddressFromDb(magentoId string) (AddressProfile, error) {
-	item := AddressProfile{}
+func GetFeatureFromDb(attributeCode string) (FeatureMapping, error) {
+	return GetStore().GetFeature(attributeCode)
+}
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := dynamodb.New(sess)
+func SaveImageToDb(imageMapping ImageMapping) error {
+	return GetStore().SaveImage(imageMapping)
+}
 
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(os.Getenv("MIGRATED_ADDRESSES_TABLE")),
-		Key: map[string]*dynamodb.AttributeValue{
-			"email": {
-				S: aws.String(magentoId),
-			},
-		},
-	})
+func GetImageFromDb(id string) (ImageMapping, error) {
+	return GetStore().GetImage(id)
+}
 
-	if err != nil {
-		fmt.Println(err.Error())
-		return item, err
-	}
+func SaveUsergroupToDb(usergroupMapping UsergroupMapping) error {
+	return GetStore().SaveUsergroup(usergroupMapping)
+}
 
-	if len(result.Item) == 0 {
-        fmt.Println("Element not found")
-		return item, errors.New("Element not found")
-    }
+func GetUsergroupFromDb(groupId string) (UsergroupMapping, error) {
+	return GetStore().GetUsergroup(groupId)
+}
 
-	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+func SaveCheckpointToDb(checkpoint Checkpoint) error {
+	return GetStore().SaveCheckpoint(checkpoint)
+}
 
-	if err != nil {
-		return item, err
-	}
+// GetCheckpointFromDb returns an empty checkpoint when the migration was never started
+func GetCheckpointFromDb(id string) (Checkpoint, error) {
+	return GetStore().GetCheckpoint(id)
+}
+
+func SaveJobToDb(job Job) error {
+	return GetStore().SaveJob(job)
+}
 
-	return item, nil
+func GetJobFromDb(id string) (Job, error) {
+	return GetStore().GetJob(id)
 }
 
-func SaveHashToDb(userHash UserHash) error{
+// dynamoBackend keeps every table on the dynamodb table named by the env var of the table
+type dynamoBackend struct {
+	svc *dynamodb.DynamoDB
+}
+
+func NewDynamoStore() MigrationStore {
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := dynamodb.New(sess)
+	})) // Creating session for client
 
-	av, err := dynamodbattribute.MarshalMap(userHash)
+	return &migrationStore{backend: &dynamoBackend{svc: dynamodb.New(sess)}}
+}
+
+func (b *dynamoBackend) putItem(table storeTable, key string, item interface{}) error {
+	av, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
 		fmt.Println("Error marshalling item: ", err.Error())
 		return err
 	}
 
-	tableName := os.Getenv("MIGRATED_HASH_TABLE")
 	input := &dynamodb.PutItemInput{
 		Item:      av,
-		TableName: aws.String(tableName),
+		TableName: aws.String(os.Getenv(table.envName)),
 	}
-	_, err = svc.PutItem(input)
+	_, err = b.svc.PutItem(input)
 	if err != nil {
 		fmt.Println("Got error calling PutItem: ", err.Error())
 		return err
@@ -180,38 +202,109 @@ func SaveHashToDb(userHash UserHash) error{
 	return nil
 }
 
-func GetHashFromDb(email string) (UserHash, error) {
-	item := UserHash{}
+// putItems writes the items with BatchWriteItem, 25 per request, the unprocessed items of a request
+// are sent again a few times before failing
+func (b *dynamoBackend) putItems(table storeTable, items []storeItem) error {
+	for start := 0; start < len(items); start += dynamoBatchSize {
+		end := start + dynamoBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var requests []*dynamodb.WriteRequest
+		for _, item := range items[start:end] {
+			av, err := dynamodbattribute.MarshalMap(item.item)
+			if err != nil {
+				fmt.Println("Error marshalling item: ", err.Error())
+				return err
+			}
+			requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: av}})
+		}
+
+		pending := map[string][]*dynamodb.WriteRequest{os.Getenv(table.envName): requests}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == dynamoBatchAttempts {
+				return errors.New("dynamodb did not process " + strconv.Itoa(len(pending[os.Getenv(table.envName)])) + " items of the batch")
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt*100) * time.Millisecond)
+			}
+			output, err := b.svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				fmt.Println("Got error calling BatchWriteItem: ", err.Error())
+				return err
+			}
+			pending = output.UnprocessedItems
+		}
+	}
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := dynamodb.New(sess)
+	return nil
+}
+
+// getItems reads the items with BatchGetItem, 100 keys per request, the unprocessed keys of a request
+// are sent again a few times before failing
+func (b *dynamoBackend) getItems(table storeTable, keys []string, item func(key string) interface{}) error {
+	tableName := os.Getenv(table.envName)
+	for start := 0; start < len(keys); start += dynamoBatchGetSize {
+		end := start + dynamoBatchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var requestKeys []map[string]*dynamodb.AttributeValue
+		for _, key := range keys[start:end] {
+			requestKeys = append(requestKeys, map[string]*dynamodb.AttributeValue{table.keyName: {S: aws.String(key)}})
+		}
+
+		pending := map[string]*dynamodb.KeysAndAttributes{tableName: {Keys: requestKeys}}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == dynamoBatchAttempts {
+				return errors.New("dynamodb did not read " + strconv.Itoa(len(pending[tableName].Keys)) + " items of the batch")
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt*100) * time.Millisecond)
+			}
+			output, err := b.svc.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				fmt.Println("Got error calling BatchGetItem: ", err.Error())
+				return err
+			}
+			for _, av := range output.Responses[tableName] {
+				key := av[table.keyName]
+				if key == nil || key.S == nil {
+					continue
+				}
+				err = dynamodbattribute.UnmarshalMap(av, item(*key.S))
+				if err != nil {
+					return err
+				}
+			}
+			pending = output.UnprocessedKeys
+		}
+	}
+
+	return nil
+}
 
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(os.Getenv("MIGRATED_HASH_TABLE")),
+func (b *dynamoBackend) getItem(table storeTable, key string, item interface{}) error {
+	result, err := b.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(os.Getenv(table.envName)),
 		Key: map[string]*dynamodb.AttributeValue{
-			"email": {
-				S: aws.String(email),
+			table.keyName: {
+				S: aws.String(key),
 			},
 		},
 	})
 
 	if err != nil {
 		fmt.Println(err.Error())
-		return item, err
+		return err
 	}
 
 	if len(result.Item) == 0 {
-        fmt.Println("Element not found")
-		return item, errors.New("Element not found")
-    }
-
-	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
-
-	if err != nil {
-		return item, err
+		return ErrNotFound
 	}
 
-	return item, nil
-}
\ No newline at end of file
+	// result.Item is of type map[string]*dynamodb.AttributeValue
+	return dynamodbattribute.UnmarshalMap(result.Item, item)
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FieldChange is a field whose value on GAMA differs from the one translated from magento
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// UserDiff has the changes of a forced update, the profiles are keyed by magento address id
+type UserDiff struct {
+	User        []FieldChange         `json:"user,omitempty"`
+	Profiles    map[int][]FieldChange `json:"profiles,omitempty"`
+	NewProfiles []int                 `json:"new_profiles,omitempty"` // magento addresses without profile on GAMA
+	Usergroup   *FieldChange          `json:"usergroup,omitempty"`    // the active usergroups of the user and the one of its magento group
+	// OrphanProfiles are the magento addresses deleted on magento whose profile is deleted or flagged
+	OrphanProfiles []int `json:"orphan_profiles,omitempty"`
+}
+
+// userUpdate is what a forced update sends to GAMA once the data GAMA already has is removed
+type userUpdate struct {
+	user             GamaUser
+	userHash         UserHash
+	sendUser         bool
+	profilesToCreate []Profile
+	profilesToUpdate []Profile
+	orphanProfiles   []Profile
+	diff             UserDiff
+}
+
+// fields that are not compared because GAMA generates them or magento doesn't have them
+var (
+	ignoredUserFields    = map[string]bool{"user_id": true, "password": true, "created": true, "company_id": true, "user_type": true}
+	ignoredProfileFields = map[string]bool{"profile_id": true, "profile_name": true}
+)
+
+func (diff UserDiff) IsEmpty() bool {
+	return len(diff.User) == 0 && len(diff.Profiles) == 0 && len(diff.NewProfiles) == 0 && diff.Usergroup == nil && len(diff.OrphanProfiles) == 0
+}
+
+// buildUserUpdate reads the user, usergroups and profiles saved on GAMA and compares them with the translated magento user
+func buildUserUpdate(magentoUser MagentoUser, gamaUserId string, usergroupId int) (update userUpdate, err error) {
+	existingUser, err := GetCSCartClient().GetUser(gamaUserId)
+	if err != nil {
+		return update, err
+	}
+
+	update.user, update.userHash, err = translateUserInformation(magentoUser, "update")
+	if err != nil {
+		return update, err
+	}
+	update.diff.User, err = diffFields(existingUser, update.user, ignoredUserFields)
+	if err != nil {
+		return update, err
+	}
+	update.sendUser = len(update.diff.User) > 0
+
+	update.diff.Usergroup, err = diffUsergroup(gamaUserId, usergroupId)
+	if err != nil {
+		return update, err
+	}
+
+	if magentoUser.Addresses == nil {
+		return update, nil
+	}
+
+	existingProfiles, err := GetCSCartClient().GetProfiles(magentoUser.Email)
+	if err != nil {
+		return update, err
+	}
+	profilesById := make(map[int]map[string]interface{})
+	for _, profile := range existingProfiles {
+		profileId, _ := strconv.Atoi(fmt.Sprint(profile["profile_id"]))
+		profilesById[profileId] = profile
+	}
+
+	profilesToCreate, profilesToUpdate, err := translateProfileInformation(magentoUser.Addresses, magentoUser)
+	if err != nil {
+		return update, err
+	}
+	for _, profile := range profilesToCreate {
+		update.profilesToCreate = append(update.profilesToCreate, profile)
+		update.diff.NewProfiles = append(update.diff.NewProfiles, profile.ProfileName)
+	}
+	for _, profile := range profilesToUpdate {
+		// a profile deleted on GAMA is compared with an empty one, so every field is sent again
+		changes, err := diffFields(profilesById[profile.ProfileId], profile, ignoredProfileFields)
+		if err != nil {
+			return update, err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if update.diff.Profiles == nil {
+			update.diff.Profiles = make(map[int][]FieldChange)
+		}
+		update.diff.Profiles[profile.ProfileName] = changes
+		update.profilesToUpdate = append(update.profilesToUpdate, profile)
+	}
+
+	update.orphanProfiles = findOrphanProfiles(magentoUser.Email, *magentoUser.Addresses, existingProfiles)
+	for _, profile := range update.orphanProfiles {
+		update.diff.OrphanProfiles = append(update.diff.OrphanProfiles, profile.ProfileName)
+	}
+
+	return update, nil
+}
+
+// diffFields compares the json fields of updated with the ones of existing, nested objects (like the
+// profile fields) are compared by key as parent.key
+func diffFields(existing interface{}, updated interface{}, ignored map[string]bool) ([]FieldChange, error) {
+	existingFields, err := flattenFields(existing)
+	if err != nil {
+		return nil, err
+	}
+	updatedFields, err := flattenFields(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FieldChange
+	for field, value := range updatedFields {
+		if ignored[field] {
+			continue
+		}
+		if existingFields[field] != value {
+			changes = append(changes, FieldChange{Field: field, Old: existingFields[field], New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes, nil
+}
+
+// flattenFields returns the json fields of value as text, so "1" from GAMA equals 1 from the translation
+func flattenFields(value interface{}) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fields, err
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(content, &decoded)
+	if err != nil {
+		return fields, err
+	}
+
+	for key, field := range decoded {
+		if nested, ok := field.(map[string]interface{}); ok {
+			for nestedKey, nestedField := range nested {
+				fields[key+"."+nestedKey] = textOf(nestedField)
+			}
+			continue
+		}
+		fields[key] = textOf(field)
+	}
+
+	return fields, nil
+}
+
+func textOf(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -16,17 +16,102 @@ func DefineEnv(stage string) error {
 		os.Setenv("gamaUrl", os.Getenv("STG_GAMA_URL"))
 		os.Setenv("gamaUser", os.Getenv("STG_GAMA_USERNAME"))
 		os.Setenv("gamaPassword", os.Getenv("STG_GAMA_PASSWORD"))
+		os.Setenv("gamaDefaultCategory", os.Getenv("STG_GAMA_DEFAULT_CATEGORY"))
+		os.Setenv("gamaUsergroups", os.Getenv("STG_GAMA_USERGROUPS"))
+		os.Setenv("gamaCreateUsergroups", os.Getenv("STG_GAMA_CREATE_USERGROUPS"))
+		os.Setenv("gamaRequireUsergroups", os.Getenv("STG_GAMA_REQUIRE_USERGROUPS"))
+		os.Setenv("regionsFile", os.Getenv("STG_REGIONS_FILE"))
+		os.Setenv("profileFields", os.Getenv("STG_PROFILE_FIELDS"))
+		os.Setenv("discoverProfileFields", os.Getenv("STG_DISCOVER_PROFILE_FIELDS"))
+		os.Setenv("orphanProfiles", os.Getenv("STG_ORPHAN_PROFILES"))
+		os.Setenv("hashesS3Endpoint", os.Getenv("STG_HASHES_S3_ENDPOINT"))
+		os.Setenv("hashesS3Region", os.Getenv("STG_HASHES_S3_REGION"))
+		os.Setenv("hashesS3AccessKey", os.Getenv("STG_HASHES_S3_ACCESS_KEY"))
+		os.Setenv("hashesS3SecretKey", os.Getenv("STG_HASHES_S3_SECRET_KEY"))
+		os.Setenv("httpRetries", os.Getenv("STG_HTTP_RETRIES"))
+		os.Setenv("httpRetryDelay", os.Getenv("STG_HTTP_RETRY_DELAY"))
+		os.Setenv("httpRetryMaxDelay", os.Getenv("STG_HTTP_RETRY_MAX_DELAY"))
+		os.Setenv("gamaRateLimit", os.Getenv("STG_GAMA_RATE_LIMIT"))
+		os.Setenv("gamaRateBurst", os.Getenv("STG_GAMA_RATE_BURST"))
+		os.Setenv("gamaMaxConcurrent", os.Getenv("STG_GAMA_MAX_CONCURRENT"))
+		os.Setenv("magentoRateLimit", os.Getenv("STG_MAGENTO_RATE_LIMIT"))
+		os.Setenv("magentoRateBurst", os.Getenv("STG_MAGENTO_RATE_BURST"))
+		os.Setenv("magentoMaxConcurrent", os.Getenv("STG_MAGENTO_MAX_CONCURRENT"))
 		os.Setenv("gamaParam", gamaParam)
-		return nil
 	} else if stage == "prod" {
 		os.Setenv("magentoUrl", os.Getenv("PROD_MAGENTO_URL"))
 		os.Setenv("magentoBearer", os.Getenv("PROD_MAGENTO_BEARER"))
 		os.Setenv("gamaUrl", os.Getenv("PROD_GAMA_URL"))
 		os.Setenv("gamaUser", os.Getenv("PROD_GAMA_USERNAME"))
 		os.Setenv("gamaPassword", os.Getenv("PROD_GAMA_PASSWORD"))
+		os.Setenv("gamaDefaultCategory", os.Getenv("PROD_GAMA_DEFAULT_CATEGORY"))
+		os.Setenv("gamaUsergroups", os.Getenv("PROD_GAMA_USERGROUPS"))
+		os.Setenv("gamaCreateUsergroups", os.Getenv("PROD_GAMA_CREATE_USERGROUPS"))
+		os.Setenv("gamaRequireUsergroups", os.Getenv("PROD_GAMA_REQUIRE_USERGROUPS"))
+		os.Setenv("regionsFile", os.Getenv("PROD_REGIONS_FILE"))
+		os.Setenv("profileFields", os.Getenv("PROD_PROFILE_FIELDS"))
+		os.Setenv("discoverProfileFields", os.Getenv("PROD_DISCOVER_PROFILE_FIELDS"))
+		os.Setenv("orphanProfiles", os.Getenv("PROD_ORPHAN_PROFILES"))
+		os.Setenv("hashesS3Endpoint", os.Getenv("PROD_HASHES_S3_ENDPOINT"))
+		os.Setenv("hashesS3Region", os.Getenv("PROD_HASHES_S3_REGION"))
+		os.Setenv("hashesS3AccessKey", os.Getenv("PROD_HASHES_S3_ACCESS_KEY"))
+		os.Setenv("hashesS3SecretKey", os.Getenv("PROD_HASHES_S3_SECRET_KEY"))
+		os.Setenv("httpRetries", os.Getenv("PROD_HTTP_RETRIES"))
+		os.Setenv("httpRetryDelay", os.Getenv("PROD_HTTP_RETRY_DELAY"))
+		os.Setenv("httpRetryMaxDelay", os.Getenv("PROD_HTTP_RETRY_MAX_DELAY"))
+		os.Setenv("gamaRateLimit", os.Getenv("PROD_GAMA_RATE_LIMIT"))
+		os.Setenv("gamaRateBurst", os.Getenv("PROD_GAMA_RATE_BURST"))
+		os.Setenv("gamaMaxConcurrent", os.Getenv("PROD_GAMA_MAX_CONCURRENT"))
+		os.Setenv("magentoRateLimit", os.Getenv("PROD_MAGENTO_RATE_LIMIT"))
+		os.Setenv("magentoRateBurst", os.Getenv("PROD_MAGENTO_RATE_BURST"))
+		os.Setenv("magentoMaxConcurrent", os.Getenv("PROD_MAGENTO_MAX_CONCURRENT"))
 		os.Setenv("gamaParam", gamaParam)
-		return nil
+	} else if stage == "local" {
+		os.Setenv("magentoUrl", os.Getenv("LOCAL_MAGENTO_URL"))
+		os.Setenv("magentoBearer", os.Getenv("LOCAL_MAGENTO_BEARER"))
+		os.Setenv("gamaUrl", os.Getenv("LOCAL_GAMA_URL"))
+		os.Setenv("gamaUser", os.Getenv("LOCAL_GAMA_USERNAME"))
+		os.Setenv("gamaPassword", os.Getenv("LOCAL_GAMA_PASSWORD"))
+		os.Setenv("gamaDefaultCategory", os.Getenv("LOCAL_GAMA_DEFAULT_CATEGORY"))
+		os.Setenv("gamaUsergroups", os.Getenv("LOCAL_GAMA_USERGROUPS"))
+		os.Setenv("gamaCreateUsergroups", os.Getenv("LOCAL_GAMA_CREATE_USERGROUPS"))
+		os.Setenv("gamaRequireUsergroups", os.Getenv("LOCAL_GAMA_REQUIRE_USERGROUPS"))
+		os.Setenv("regionsFile", os.Getenv("LOCAL_REGIONS_FILE"))
+		os.Setenv("profileFields", os.Getenv("LOCAL_PROFILE_FIELDS"))
+		os.Setenv("discoverProfileFields", os.Getenv("LOCAL_DISCOVER_PROFILE_FIELDS"))
+		os.Setenv("orphanProfiles", os.Getenv("LOCAL_ORPHAN_PROFILES"))
+		os.Setenv("hashesS3Endpoint", os.Getenv("LOCAL_HASHES_S3_ENDPOINT"))
+		os.Setenv("hashesS3Region", os.Getenv("LOCAL_HASHES_S3_REGION"))
+		os.Setenv("hashesS3AccessKey", os.Getenv("LOCAL_HASHES_S3_ACCESS_KEY"))
+		os.Setenv("hashesS3SecretKey", os.Getenv("LOCAL_HASHES_S3_SECRET_KEY"))
+		os.Setenv("httpRetries", os.Getenv("LOCAL_HTTP_RETRIES"))
+		os.Setenv("httpRetryDelay", os.Getenv("LOCAL_HTTP_RETRY_DELAY"))
+		os.Setenv("httpRetryMaxDelay", os.Getenv("LOCAL_HTTP_RETRY_MAX_DELAY"))
+		os.Setenv("gamaRateLimit", os.Getenv("LOCAL_GAMA_RATE_LIMIT"))
+		os.Setenv("gamaRateBurst", os.Getenv("LOCAL_GAMA_RATE_BURST"))
+		os.Setenv("gamaMaxConcurrent", os.Getenv("LOCAL_GAMA_MAX_CONCURRENT"))
+		os.Setenv("magentoRateLimit", os.Getenv("LOCAL_MAGENTO_RATE_LIMIT"))
+		os.Setenv("magentoRateBurst", os.Getenv("LOCAL_MAGENTO_RATE_BURST"))
+		os.Setenv("magentoMaxConcurrent", os.Getenv("LOCAL_MAGENTO_MAX_CONCURRENT"))
+		os.Setenv("gamaParam", gamaParam)
+		if os.Getenv("MIGRATION_STORE") == "" {
+			os.Setenv("MIGRATION_STORE", "file") // local runs don't need aws
+		}
 	} else {
 		return errors.New("Stage " + stage + " is not defined")
 	}
+	if os.Getenv("regionsFile") == "" {
+		os.Setenv("regionsFile", defaultRegionsFile) // packaged with the functions
+	}
+
+	SetMagentoClient(NewMagentoClient(os.Getenv("magentoUrl"), os.Getenv("magentoBearer"), nil))
+	SetCSCartClient(NewCSCartClient(os.Getenv("gamaUrl"), os.Getenv("gamaUser"), os.Getenv("gamaPassword"), nil))
+
+	migrationStore, err := NewStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	SetStore(migrationStore)
+
+	return nil
 }
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	getProductAttributeEndpoint  = "products/attributes/"
+	getProductAttributesEndpoint = "products/attributes?searchCriteria[filter_groups][0][filters][0][field]=is_user_defined&searchCriteria[filter_groups][0][filters][0][value]=1&searchCriteria[filter_groups][1][filters][0][field]=frontend_input&searchCriteria[filter_groups][1][filters][0][condition_type]=in&searchCriteria[filter_groups][1][filters][0][value]=select,multiselect,text"
+	featuresEndpoint             = "api/features"
+
+	VariationFeaturePurpose = "group_variation_catalog_item"
+	FilterFeaturePurpose    = "find_products"
+	DescribeFeaturePurpose  = "describe_product"
+)
+
+type MagentoAttributeOption struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// MagentoProductAttribute is the definition of an EAV attribute of the magento products
+type MagentoProductAttribute struct {
+	AttributeId          int                      `json:"attribute_id"`
+	AttributeCode        string                   `json:"attribute_code"`
+	FrontendInput        string                   `json:"frontend_input"`
+	IsUserDefined        bool                     `json:"is_user_defined"`
+	DefaultFrontendLabel string                   `json:"default_frontend_label"`
+	Options              []MagentoAttributeOption `json:"options"`
+}
+
+type MagentoAttributeResults struct {
+	Items []MagentoProductAttribute `json:"items"`
+	Total int                       `json:"total_count"`
+}
+
+type GamaFeatureVariant struct {
+	VariantId int    `json:"variant_id,omitempty"`
+	Variant   string `json:"variant"`
+	Position  int    `json:"position"`
+}
+
+// GamaFeature is the payload of api/features, GAMA returns the variants by variant_id (see GamaFeatureResult)
+type GamaFeature struct {
+	Description string               `json:"description"`
+	FeatureType string               `json:"feature_type"`
+	Purpose     string               `json:"purpose,omitempty"`
+	Status      string               `json:"status"`
+	Variants    []GamaFeatureVariant `json:"variants,omitempty"`
+}
+
+type GamaFeatureResult struct {
+	FeatureId   int                           `json:"feature_id"`
+	Description string                        `json:"description"`
+	FeatureType string                        `json:"feature_type"`
+	Purpose     string                        `json:"purpose"`
+	Variants    map[string]GamaFeatureVariant `json:"variants"`
+}
+
+type GamaFeatureResponse struct {
+	FeatureId int `json:"feature_id"`
+}
+
+// FeatureMapping relates a magento attribute_code with the GAMA feature, Variants has the variant_id
+// of every magento option value
+type FeatureMapping struct {
+	Id          string         `json:"id"`
+	AttributeId int            `json:"attribute_id"`
+	FeatureType string         `json:"feature_type"`
+	Purpose     string         `json:"purpose"`
+	FeatureId   int            `json:"feature_id,omitempty"`
+	Variants    map[string]int `json:"variants,omitempty"`
+	Result      bool           `json:"response_code"`
+}
+
+// GetMagentoProductAttribute returns the attribute with the attribute_code or attribute_id
+func GetMagentoProductAttribute(attribute string) (MagentoProductAttribute, error) {
+	return GetMagentoClient().GetProductAttribute(getProductAttributeEndpoint + url.PathEscape(attribute))
+}
+
+// GetMagentoProductAttributes returns every select, multiselect and text attribute defined by the store
+func GetMagentoProductAttributes() ([]MagentoProductAttribute, error) {
+	var attributes []MagentoProductAttribute
+	for page := 1; ; page++ {
+		request := getProductAttributesEndpoint + "&searchCriteria[pageSize]=" + strconv.Itoa(DefaultPageSize) + "&searchCriteria[currentPage]=" + strconv.Itoa(page)
+		magentoResults, err := GetMagentoClient().SearchProductAttributes(request)
+		if err != nil {
+			return attributes, err
+		}
+		attributes = append(attributes, magentoResults.Items...)
+
+		if len(magentoResults.Items) == 0 || page*DefaultPageSize >= magentoResults.Total {
+			return attributes, nil
+		}
+	}
+}
+
+// ImportMagentoAttribute creates the GAMA feature and variants of the magento attribute, the selects
+// and multiselects are filters and the texts describe the product
+func ImportMagentoAttribute(attribute MagentoProductAttribute, force bool) BodyResult {
+	bodyResult := BodyResult{
+		Entity:    "feature",
+		Reference: attribute.AttributeCode,
+	}
+
+	featureType, ok := getMapFeatureTypes()[attribute.FrontendInput]
+	if !ok {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = "magento attribute input " + attribute.FrontendInput + " has no GAMA feature type"
+		return bodyResult
+	}
+	purpose := FilterFeaturePurpose
+	if featureType == "T" {
+		purpose = DescribeFeaturePurpose
+	}
+
+	mapping, err := GetFeatureFromDb(attribute.AttributeCode)
+	if err == nil && mapping.Result && hasAllVariants(mapping, attribute) && !force {
+		bodyResult.ResponseCode = 1
+		bodyResult.Reason = "feature already migrated with id " + strconv.Itoa(mapping.FeatureId)
+		return bodyResult
+	}
+	bodyResult.ResponseCode = 1
+	if mapping.FeatureId != 0 {
+		bodyResult.ResponseCode = 2
+	}
+
+	_, err = migrateAttributeFeature(attribute, featureType, purpose, true)
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+	}
+
+	return bodyResult
+}
+
+// getProductFeatures returns the values of the migrated features of the product by feature_id. The
+// options added to magento after the feature was migrated are added to the feature.
+func getProductFeatures(magentoProduct MagentoProduct) (map[string]GamaProductFeature, error) {
+	productFeatures := make(map[string]GamaProductFeature)
+	for _, productAttribute := range magentoProduct.CustomAttributes {
+		mapping, err := GetFeatureFromDb(productAttribute.Code)
+		if err != nil || !mapping.Result || mapping.Purpose == VariationFeaturePurpose {
+			continue // not a feature or the value is set by the variations group
+		}
+		value := getProductAttribute(magentoProduct, productAttribute.Code)
+		if value == "" {
+			continue
+		}
+
+		values := strings.Split(value, ",")
+		if mapping.FeatureType == "T" {
+			values = nil
+		}
+		for _, optionValue := range values {
+			if _, ok := mapping.Variants[optionValue]; ok {
+				continue
+			}
+			attribute, err := GetMagentoProductAttribute(productAttribute.Code)
+			if err != nil {
+				fmt.Println("Error returned by GetMagentoProductAttribute function: ", err.Error())
+				return productFeatures, err
+			}
+			mapping, err = migrateAttributeFeature(attribute, mapping.FeatureType, mapping.Purpose, false)
+			if err != nil {
+				return productFeatures, err
+			}
+			if _, ok := mapping.Variants[optionValue]; !ok {
+				return productFeatures, errors.New("magento attribute " + productAttribute.Code + " has no option " + optionValue)
+			}
+		}
+
+		productFeature := GamaProductFeature{FeatureId: mapping.FeatureId}
+		switch mapping.FeatureType {
+		case "S":
+			productFeature.VariantId = mapping.Variants[value]
+		case "M":
+			for _, optionValue := range values {
+				productFeature.Variants = append(productFeature.Variants, mapping.Variants[optionValue])
+			}
+		default:
+			productFeature.Value = value
+		}
+		productFeatures[strconv.Itoa(mapping.FeatureId)] = productFeature
+	}
+
+	return productFeatures, nil
+}
+
+func getMapFeatureTypes() map[string]string {
+	return map[string]string{
+		"select":      "S", // text select box
+		"multiselect": "M", // multiple checkboxes
+		"text":        "T", // text
+	}
+}
+
+// migrateAttributeFeature returns the GAMA feature of the magento attribute, the feature is created
+// the first time and the options added to magento later are added as variants. Features that are
+// already complete are only sent again with force, keeping the type and purpose they were created with,
+// except the filters used as options of a configurable product: they are updated to variation features.
+func migrateAttributeFeature(attribute MagentoProductAttribute, featureType string, purpose string, force bool) (FeatureMapping, error) {
+	mapping, err := GetFeatureFromDb(attribute.AttributeCode)
+	toVariation := purpose == VariationFeaturePurpose && mapping.FeatureType != "" && mapping.Purpose != VariationFeaturePurpose
+	if err == nil && mapping.Result && hasAllVariants(mapping, attribute) && !force && !toVariation {
+		return mapping, nil
+	}
+	if toVariation && mapping.FeatureType != featureType {
+		return mapping, errors.New("the attribute " + attribute.AttributeCode + " is an option of a configurable product but its GAMA feature " +
+			strconv.Itoa(mapping.FeatureId) + " has the type " + mapping.FeatureType + ", the variation features must be " + featureType)
+	}
+	if mapping.FeatureType != "" && !toVariation {
+		featureType = mapping.FeatureType
+		purpose = mapping.Purpose
+	}
+
+	gamaFeature := GamaFeature{
+		Description: attribute.DefaultFrontendLabel,
+		FeatureType: featureType,
+		Purpose:     purpose,
+		Status:      "A",
+	}
+	if gamaFeature.Description == "" {
+		gamaFeature.Description = attribute.AttributeCode
+	}
+	for index, option := range attribute.Options {
+		if option.Value == "" {
+			continue // magento lists the empty option of the selects
+		}
+		gamaFeature.Variants = append(gamaFeature.Variants, GamaFeatureVariant{
+			VariantId: mapping.Variants[option.Value],
+			Variant:   option.Label,
+			Position:  index,
+		})
+	}
+
+	mapping = FeatureMapping{
+		Id:          attribute.AttributeCode,
+		AttributeId: attribute.AttributeId,
+		FeatureType: featureType,
+		Purpose:     purpose,
+		FeatureId:   mapping.FeatureId,
+		Variants:    make(map[string]int),
+	}
+	if mapping.FeatureId != 0 {
+		err = GetCSCartClient().UpdateFeature(mapping.FeatureId, gamaFeature)
+	} else {
+		var gamaFeatureResponse GamaFeatureResponse
+		gamaFeatureResponse, err = GetCSCartClient().CreateFeature(gamaFeature)
+		mapping.FeatureId = gamaFeatureResponse.FeatureId
+	}
+	if err == nil {
+		err = readFeatureVariants(&mapping, attribute)
+	}
+
+	mapping.Result = err == nil && mapping.FeatureId != 0
+	saveErr := SaveFeatureToDb(mapping)
+	if saveErr != nil {
+		fmt.Println("Error returned by SaveFeatureToDb function: ", saveErr.Error())
+	}
+	if err != nil {
+		return mapping, errors.New("error migrating the attribute " + attribute.AttributeCode + ": " + err.Error())
+	}
+
+	return mapping, nil
+}
+
+// readFeatureVariants maps the magento option values to the variant_id that GAMA assigned to their labels
+func readFeatureVariants(mapping *FeatureMapping, attribute MagentoProductAttribute) error {
+	gamaFeature, err := GetCSCartClient().GetFeature(mapping.FeatureId)
+	if err != nil {
+		return err
+	}
+
+	variantIds := make(map[string]int)
+	for _, variant := range gamaFeature.Variants {
+		variantIds[variant.Variant] = variant.VariantId
+	}
+	for _, option := range attribute.Options {
+		if option.Value == "" {
+			continue
+		}
+		variantId, ok := variantIds[option.Label]
+		if !ok {
+			return errors.New("GAMA feature " + strconv.Itoa(mapping.FeatureId) + " has no variant " + option.Label)
+		}
+		mapping.Variants[option.Value] = variantId
+	}
+
+	return nil
+}
+
+func hasAllVariants(mapping FeatureMapping, attribute MagentoProductAttribute) bool {
+	for _, option := range attribute.Options {
+		if _, ok := mapping.Variants[option.Value]; option.Value != "" && !ok {
+			return false
+		}
+	}
+	return true
+}
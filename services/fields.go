@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const profileFieldsEndpoint = "api/profile_fields"
+
+// GamaProfileField is a field of the GAMA profiles, section is S for shipping and B for billing
+type GamaProfileField struct {
+	FieldId   int    `json:"field_id,string"`
+	FieldName string `json:"field_name"`
+	Section   string `json:"section"`
+}
+
+// ProfileFieldIds are the GAMA profile fields of the shipping and billing sections that receive the
+// value of a magento address attribute, 0 when the section doesn't have it
+type ProfileFieldIds struct {
+	Shipping int
+	Billing  int
+}
+
+// the profile fields of GAMA read by the discovery, they are read again when the client changes
+var (
+	profileFieldsMu sync.Mutex
+	profileFields   []GamaProfileField
+	profileFieldsOf CSCartClient
+)
+
+func getDefaultProfileFields() map[string]ProfileFieldIds {
+	return map[string]ProfileFieldIds{
+		"external_number":  {Shipping: 59, Billing: 58},
+		"internal_number":  {Shipping: 61, Billing: 60},
+		"receptor_details": {Shipping: 63, Billing: 62},
+		"suburb":           {Shipping: 65, Billing: 64},
+	}
+}
+
+// GetMapProfileFields returns the GAMA profile fields of the magento address attributes. The stage maps
+// them on profileFields as attribute_code:shipping_field_id:billing_field_id separated by commas, the
+// attributes without ids (or with 0) are found by their name when profile fields discovery is enabled.
+// With discovery the fields of GAMA named as the attributes replace the default ids too.
+func GetMapProfileFields() (map[string]ProfileFieldIds, error) {
+	mapping := getDefaultProfileFields()
+	configured := make(map[string]ProfileFieldIds)
+	config := strings.TrimSpace(os.Getenv("profileFields"))
+	if config != "" {
+		mapping = make(map[string]ProfileFieldIds)
+		for _, entry := range strings.Split(config, ",") {
+			parts := strings.Split(strings.TrimSpace(entry), ":")
+			if parts[0] == "" || len(parts) > 3 {
+				return mapping, errors.New("invalid profile fields mapping " + entry + ", use attribute_code:shipping_field_id:billing_field_id")
+			}
+			var ids [2]int
+			for index, id := range parts[1:] {
+				var err error
+				ids[index], err = strconv.Atoi(id)
+				if err != nil {
+					return mapping, errors.New("invalid profile fields mapping " + entry + ", use attribute_code:shipping_field_id:billing_field_id")
+				}
+			}
+			mapping[parts[0]] = ProfileFieldIds{Shipping: ids[0], Billing: ids[1]}
+			configured[parts[0]] = mapping[parts[0]]
+		}
+	}
+	if os.Getenv("discoverProfileFields") != "true" {
+		return mapping, nil
+	}
+
+	fields, err := getGamaProfileFields()
+	if err != nil {
+		return mapping, errors.New("error reading the GAMA profile fields: " + err.Error())
+	}
+	for _, field := range fields {
+		ids, ok := mapping[field.FieldName]
+		if !ok {
+			continue
+		}
+		if field.Section == "S" && configured[field.FieldName].Shipping == 0 {
+			ids.Shipping = field.FieldId
+		} else if field.Section == "B" && configured[field.FieldName].Billing == 0 {
+			ids.Billing = field.FieldId
+		}
+		mapping[field.FieldName] = ids
+	}
+
+	return mapping, nil
+}
+
+// getGamaProfileFields reads the profile fields of GAMA once per client
+func getGamaProfileFields() ([]GamaProfileField, error) {
+	profileFieldsMu.Lock()
+	defer profileFieldsMu.Unlock()
+
+	client := GetCSCartClient()
+	if profileFields != nil && profileFieldsOf == client {
+		return profileFields, nil
+	}
+	fields, err := client.GetProfileFields()
+	if err != nil {
+		return nil, err
+	}
+	profileFields = fields
+	profileFieldsOf = client
+	return fields, nil
+}
+
+// translateProfileFields returns the values of the attributes of the shipping and billing addresses by
+// GAMA profile field id
+func translateProfileFields(shipping map[string]string, billing map[string]string, mapping map[string]ProfileFieldIds) Fields {
+	fields := make(Fields)
+	for code, ids := range mapping {
+		if ids.Shipping != 0 {
+			fields[strconv.Itoa(ids.Shipping)] = shipping[code]
+		}
+		if ids.Billing != 0 {
+			fields[strconv.Itoa(ids.Billing)] = billing[code]
+		}
+	}
+	return fields
+}
@@ -1,22 +1,19 @@
 package services
 
 import (
-	"bytes"
-	"encoding/base64"
-	"strings"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
 	"strconv"
+	"strings"
+
+	magentohash "migration-m2-gama/magentohash"
 )
 
 const (
-	getUserByEmailEndpoint = "api/users?email="
-	userEndpoint           = "api/users"
-	profilesEndpoint       = "api/profiles"
+	getUserByEmailEndpoint     = "api/users?email="
+	userEndpoint               = "api/users"
+	profilesEndpoint           = "api/profiles"
+	getProfilesByEmailEndpoint = "api/profiles?email="
 )
 
 type GamaResult struct {
@@ -43,6 +40,7 @@ type GamaUser struct {
 type Profile struct {
 	ProfileId   int    `json:"profile_id,omitempty"`
 	ProfileName int    `json:"profile_name"`
+	ProfileType string `json:"profile_type,omitempty"` // P for the main profile of the user, S for the rest
 	Sfirstname  string `json:"s_firstname"`
 	Slastname   string `json:"s_lastname"`
 	Saddress    string `json:"s_address"`
@@ -64,16 +62,8 @@ type Profile struct {
 	Fields      Fields `json:"fields"`
 }
 
-type Fields struct {
-	NumExt	   string `json:"59"`
-	NumInt	   string `json:"61"`
-	Reference  string `json:"63"`
-	Suburb	   string `json:"65"`
-	BNumExt	   string `json:"58"`
-	BNumInt	   string `json:"60"`
-	BReference string `json:"62"`
-	BSuburb	   string `json:"64"`
-}
+// Fields are the values of the custom profile fields by field id, see GetMapProfileFields
+type Fields map[string]string
 
 type GamaProfileRequest struct {
 	Email    string    `json:"email"`
@@ -89,124 +79,168 @@ type GamaUpdateProfileResponse struct {
 }
 
 type GamaUserResponse struct {
-	ProfileId int `json:"profile_id,string"`
+	UserId    string `json:"user_id"`
+	ProfileId int    `json:"profile_id,string"`
 }
 
-func GamaImportUser(magentoUser MagentoUser, force bool) (responseCode int, err error) {
-	gamaResult, err := getGamaUserByEmail(magentoUser.Email)
+// GamaImportUser inserts or, when force is true, updates the magento user on GAMA and assigns it to the
+// usergroup of its customer group. Updates only send what changed on GAMA and return the diff, the
+// response code is 4 when nothing changed.
+func GamaImportUser(magentoUser MagentoUser, force bool) (responseCode int, diff *UserDiff, err error) {
+	gamaResult, err := GetCSCartClient().GetUserByEmail(magentoUser.Email)
 
 	if err != nil {
-		return 3, err
+		return 3, nil, err
 	}
 
-	totalItems, _ := strconv.ParseInt(gamaResult.Params.TotalItems, 10, 0)
+	mode, err := getImportMode(gamaResult, force)
+	if err != nil {
+		return 3, nil, err
+	}
 
-	if totalItems > 1 {
-		return 3, errors.New("more of one user found in GAMA with this email")
-	} else if totalItems == 1 && !force {
-		return 3, errors.New("user already exists on GAMA, try send force param equals to 'true' (string)")
-	} else if totalItems == 1 && force || totalItems == 0 {
-		if totalItems == 1 {
-			_, err = sentToGama(magentoUser, gamaResult.Users[0].Id, "update")
-			if err != nil {
-				return 3, err
-			}
-			err = sendGamaAddresses(magentoUser)
-			if err != nil {
-				return 3, err
-			}
-			return 2, err
-		} else if totalItems == 0 {
-			gamaUserResponse, err := sentToGama(magentoUser, "", "insert")
+	// a hash that can't be read fails the user before anything is written on GAMA
+	if mode == "insert" && magentoUser.Hash != "" {
+		_, err = magentohash.Decode(magentoUser.Hash)
+		if err != nil {
+			return 3, nil, err
+		}
+	}
+
+	usergroupId, err := getGamaUsergroupId(magentoUser.GroupId, true)
+	if err != nil {
+		return 3, nil, err
+	}
+
+	if mode == "update" {
+		update, err := buildUserUpdate(magentoUser, gamaResult.Users[0].Id, usergroupId)
+		if err != nil {
+			return 3, nil, err
+		}
+		if update.diff.IsEmpty() {
+			return 4, nil, nil
+		}
+		if update.sendUser {
+			_, err = GetCSCartClient().UpdateUser(gamaResult.Users[0].Id, update.user)
 			if err != nil {
-				return 3, err
+				return 3, &update.diff, err
 			}
-			savePrincipalProfileId(magentoUser, gamaUserResponse)
-			err = sendGamaAddresses(magentoUser)
+			saveUserHash(update.userHash)
+		}
+		err = sendGamaProfiles(magentoUser.Email, update.profilesToCreate, update.profilesToUpdate)
+		if err != nil {
+			return 3, &update.diff, err
+		}
+		err = removeOrphanProfiles(magentoUser.Email, update.orphanProfiles)
+		if err != nil {
+			return 3, &update.diff, err
+		}
+		if update.diff.Usergroup != nil {
+			err = GetCSCartClient().AssignUsergroup(gamaResult.Users[0].Id, usergroupId)
 			if err != nil {
-				return 3, err
+				return 3, &update.diff, err
 			}
-			return 1, err
 		}
+		return 2, &update.diff, nil
 	}
 
-	return 1, nil
-}
-
-func sentToGama(magentoUser MagentoUser, gamaUserId string, mode string) (gamaUserResponse GamaUserResponse, err error) {
-	gamaUser, userHash := translateUserInformation(magentoUser, mode)
-	methodRequest := http.MethodGet // to prevent not allowed actions
-	url := os.Getenv("gamaUrl") + userEndpoint
-
-	if mode == "update" {
-		methodRequest = http.MethodPut
-		url += "/" + gamaUserId + "&" + gamaParam
-	} else if mode == "insert" {
-		methodRequest = http.MethodPost
-		url += "&" + gamaParam
+	// the profiles are translated before creating the user, so it is not created when a region has no state
+	var profilesToCreate, profilesToUpdate []Profile
+	if magentoUser.Addresses != nil {
+		profilesToCreate, profilesToUpdate, err = translateProfileInformation(magentoUser.Addresses, magentoUser)
+		if err != nil {
+			return 3, nil, err
+		}
 	}
 
-	payload, err := json.Marshal(gamaUser)
+	gamaUserResponse, err := sentToGama(magentoUser, "", "insert")
 	if err != nil {
-		return gamaUserResponse, errors.New("error marshaling gamaUser in order to create the payload")
+		return 3, nil, err
+	}
+	savePrincipalProfileId(magentoUser, gamaUserResponse)
+	if usergroupId != 0 {
+		err = GetCSCartClient().AssignUsergroup(gamaUserResponse.UserId, usergroupId)
+		if err != nil {
+			return 3, nil, errors.New("user created but not assigned to the usergroup " + strconv.Itoa(usergroupId) + ": " + err.Error())
+		}
 	}
+	profilesToCreate, profilesToUpdate = usePrincipalProfile(magentoUser, gamaUserResponse, profilesToCreate, profilesToUpdate)
+	err = sendGamaProfiles(magentoUser.Email, profilesToCreate, profilesToUpdate)
+	if err != nil {
+		return 3, nil, err
+	}
+	return 1, nil, err
+}
 
-	client := &http.Client{}
+// getImportMode decides if the user is inserted or updated on GAMA from the users found with its email
+func getImportMode(gamaResult GamaResult, force bool) (string, error) {
+	totalItems, _ := strconv.ParseInt(gamaResult.Params.TotalItems, 10, 0)
 
-	request, err := http.NewRequest(methodRequest, url, bytes.NewBuffer(payload))
-	if err != nil {
-		return gamaUserResponse, err
+	if totalItems > 1 {
+		return "", errors.New("more of one user found in GAMA with this email")
+	} else if totalItems == 1 && !force {
+		return "", errors.New("user already exists on GAMA, try send force param equals to 'true' (string)")
+	} else if totalItems == 1 {
+		return "update", nil
 	}
-	request.SetBasicAuth(os.Getenv("gamaUser"), os.Getenv("gamaPassword"))
-	request.Header.Set("Content-Type", "application/json")
 
-	response, err := client.Do(request)
+	return "insert", nil
+}
+
+func sentToGama(magentoUser MagentoUser, gamaUserId string, mode string) (gamaUserResponse GamaUserResponse, err error) {
+	gamaUser, userHash, err := translateUserInformation(magentoUser, mode)
 	if err != nil {
 		return gamaUserResponse, err
 	}
 
-	defer response.Body.Close()
-
-	if response.StatusCode != 201 && response.StatusCode != 200 {
-		return gamaUserResponse, errors.New("gama endpoint (" + url + ") returned a non 2xx status, reurned: " + response.Status)
+	if mode == "update" {
+		gamaUserResponse, err = GetCSCartClient().UpdateUser(gamaUserId, gamaUser)
+	} else if mode == "insert" {
+		gamaUserResponse, err = GetCSCartClient().CreateUser(gamaUser)
+	} else {
+		return gamaUserResponse, errors.New("mode " + mode + " is not allowed") // to prevent not allowed actions
 	}
-	
-	saveUserHash(userHash)
-	
-	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		fmt.Println("Error reading resp.Body function consumeMagentoEndpoint: ", err.Error())
 		return gamaUserResponse, err
 	}
 
-	json.Unmarshal(body, &gamaUserResponse)
+	saveUserHash(userHash)
 
 	return gamaUserResponse, nil
 }
 
-func translateUserInformation(magentoUser MagentoUser, mode string) (gamaUser GamaUser, userHash UserHash) {
-	var err error
-
+// translateUserInformation returns the GAMA user and the hash to save of the magento user, the hash is
+// only sent on inserts and fails with a magentohash.InvalidHashError when it is not a magento hash. The
+// users sent without hash use the one imported from the customer export, if any.
+func translateUserInformation(magentoUser MagentoUser, mode string) (gamaUser GamaUser, userHash UserHash, err error) {
+	var sentHash UserHash
 	if mode == "update" {
 		magentoUser.Hash = ""
+	} else {
+		savedHash, err := GetHashFromDb(magentoUser.Email)
+		if err == nil && savedHash.Pending {
+			if magentoUser.Hash == "" {
+				magentoUser.Hash = savedHash.Hash
+			}
+		} else if err == nil {
+			sentHash = savedHash
+		}
 	}
 	if magentoUser.Hash != "" {
-		userHash, err = GetHashFromDb(magentoUser.Email)
-		userHash.Email = magentoUser.Email
-		rawDecodedText, _ := base64.StdEncoding.DecodeString(magentoUser.Hash)
-		hash := strings.Split(string(rawDecodedText), ":")
-		if err == nil && userHash.Hash != "" {
-			rawDecodedText, _ := base64.StdEncoding.DecodeString(userHash.Hash)
-			hashDB := strings.Split(string(rawDecodedText), ":")
-			if hashDB[1] == hash[1] {
-				hash[1] = ""
-			} else {
-				userHash.Hash = magentoUser.Hash
+		hash, err := magentohash.Decode(magentoUser.Hash)
+		if err != nil {
+			return gamaUser, userHash, err
+		}
+		userHash = UserHash{Email: magentoUser.Email, Hash: magentoUser.Hash}
+		magentoUser.Hash = hash.Salt
+
+		// the password is not sent again when the hash sent before has the same salt
+		if sentHash.Hash != "" {
+			sent, err := magentohash.Decode(sentHash.Hash)
+			if err == nil && sent.Salt == hash.Salt {
+				magentoUser.Hash = ""
+				userHash = sentHash
 			}
-		} else {
-			userHash.Hash = magentoUser.Hash
 		}
-		magentoUser.Hash = hash[1]
 	}
 
 	var user = GamaUser{
@@ -222,63 +256,48 @@ func translateUserInformation(magentoUser MagentoUser, mode string) (gamaUser Ga
 		user.UserType = "C"
 	}
 
-	return user,userHash
+	return user, userHash, nil
 }
 
-func getGamaUserByEmail(email string) (GamaResult, error) {
-	var gamaResult = GamaResult{}
-
-	url := os.Getenv("gamaUrl") + getUserByEmailEndpoint + email + "&" + gamaParam
-
-	req, err := http.NewRequest("GET", url, nil) // Create a new request using http
-	if err != nil {
-		fmt.Println("Error create http object ("+url+") function GetGamaUserByEmail: ", err.Error())
-		return gamaResult, err
+// usePrincipalProfile sends the main address as an update of the profile GAMA creates with the user
+func usePrincipalProfile(magentoUser MagentoUser, gamaUserResponse GamaUserResponse, profilesToCreate []Profile, profilesToUpdate []Profile) ([]Profile, []Profile) {
+	mainAddressId := getMainAddressId(magentoUser)
+	if mainAddressId == 0 || gamaUserResponse.ProfileId == 0 {
+		return profilesToCreate, profilesToUpdate
 	}
-	req.SetBasicAuth(os.Getenv("gamaUser"), os.Getenv("gamaPassword"))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
 
-	if resp.StatusCode != 200 {
-		return gamaResult, errors.New("gama endpoint (" + url + ") returned a non 200 status, reurned: " + resp.Status)
-	}
-
-	if err != nil {
-		fmt.Println("Error on request of endpoint ("+url+"): ", err.Error())
-		return gamaResult, err
-	}
-
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading resp.Body function getGamaUserByEmail: ", err.Error())
-		return gamaResult, err
+	var remaining []Profile
+	for _, profile := range profilesToCreate {
+		if profile.ProfileName == mainAddressId {
+			profile.ProfileId = gamaUserResponse.ProfileId
+			profilesToUpdate = append(profilesToUpdate, profile)
+		} else {
+			remaining = append(remaining, profile)
+		}
 	}
-
-	json.Unmarshal(body, &gamaResult)
-
-	return gamaResult, nil
+	return remaining, profilesToUpdate
 }
 
-func sendGamaAddresses(magentoUser MagentoUser) (error){
-	var gamaProfileResponse = GamaProfileResponse{}
-	var gamaUpdateProfileResponse = GamaUpdateProfileResponse{}
-	if magentoUser.Addresses != nil {
-		addressesToCreate, addressToUpdate := translateProfileInformation(magentoUser.Addresses, magentoUser)
-		body, err := gamaCreateProfile(magentoUser, addressesToCreate, "insert")
+func sendGamaProfiles(email string, addressesToCreate []Profile, addressToUpdate []Profile) error {
+	if len(addressesToCreate) > 0 {
+		gamaProfileResponse, err := GetCSCartClient().CreateProfiles(GamaProfileRequest{
+			Email:    email,
+			Profiles: addressesToCreate,
+		})
 		if err != nil {
 			return err
 		}
-		json.Unmarshal(body, &gamaProfileResponse)
-		checkProfilesResponse(magentoUser.Email, gamaProfileResponse)
-		body, err = gamaCreateProfile(magentoUser, addressToUpdate, "update")
+		checkProfilesResponse(email, gamaProfileResponse)
+	}
+	if len(addressToUpdate) > 0 {
+		gamaUpdateProfileResponse, err := GetCSCartClient().UpdateProfiles(GamaProfileRequest{
+			Email:    email,
+			Profiles: addressToUpdate,
+		})
 		if err != nil {
 			return err
 		}
-		json.Unmarshal(body, &gamaUpdateProfileResponse)
-		err = checkUpdateProfilesResponse(magentoUser.Email, gamaUpdateProfileResponse, addressToUpdate)
+		err = checkUpdateProfilesResponse(email, gamaUpdateProfileResponse, addressToUpdate)
 		if err != nil {
 			return err
 		}
@@ -286,99 +305,66 @@ func sendGamaAddresses(magentoUser MagentoUser) (error){
 	return nil
 }
 
-func gamaCreateProfile(magentoUser MagentoUser, addresses []Profile,  mode string) ([]byte, error) {
-	var gamaResponse []byte
-	methodRequest := http.MethodGet // to prevent not allowed actions
-	url := os.Getenv("gamaUrl") + profilesEndpoint
-	gamaRequestProfile := GamaProfileRequest{
-		Email:	  magentoUser.Email,
-		Profiles: addresses,
-	}
-
-	if mode == "update" {
-		methodRequest = http.MethodPut
-		url += "/1" + "&" + gamaParam
-	} else if mode == "insert" {
-		methodRequest = http.MethodPost
-		url += "&" + gamaParam
-	}
-
-	payload, err := json.Marshal(gamaRequestProfile)
-	if err != nil {
-		return gamaResponse, errors.New("error marshaling gamaRequestProfile in order to create the payload")
-	}
-
-	client := &http.Client{}
-
-	request, err := http.NewRequest(methodRequest, url, bytes.NewBuffer(payload))
+// translateProfileInformation returns the profiles to create and update of the addresses, the addresses
+// whose region has no GAMA state are returned on the error. The shipping side of every profile is its
+// address and the billing side is the default billing address (or the address itself when there is
+// none), the profile of the main address is the main profile (P) of the user.
+func translateProfileInformation(addresses *[]Address, magentoUser MagentoUser) ([]Profile, []Profile, error) {
+	var profilesToCreate, profilesToUpdate []Profile
+	states, err := newRegionMapper()
 	if err != nil {
-		return gamaResponse, err
+		return profilesToCreate, profilesToUpdate, err
 	}
-	request.SetBasicAuth(os.Getenv("gamaUser"), os.Getenv("gamaPassword"))
-	request.Header.Set("Content-Type", "application/json")
-
-	response, err := client.Do(request)
+	profileFields, err := GetMapProfileFields()
 	if err != nil {
-		return gamaResponse, err
+		return profilesToCreate, profilesToUpdate, err
 	}
 
-	defer response.Body.Close()
-
-	if response.StatusCode != 201 && response.StatusCode != 200 {
-		return gamaResponse, errors.New("gama endpoint (" + url + ") returned a non 2xx status, reurned: " + response.Status)
+	stateIds := make(map[int]int)
+	var regionErrors []string
+	for _, address := range *addresses {
+		stateIds[address.Id], err = states.stateId(address.CountryId, address.Region.RegionId, address.Region.RegionCode, address.Region.Region)
+		if err != nil {
+			regionErrors = append(regionErrors, "address "+strconv.Itoa(address.Id)+": "+err.Error())
+		}
 	}
-
-	gamaResponse, err = ioutil.ReadAll(response.Body)
-	if err != nil {
-		fmt.Println("Error reading resp.Body function consumeMagentoEndpoint: ", err.Error())
-		return gamaResponse, err
+	if len(regionErrors) > 0 {
+		return profilesToCreate, profilesToUpdate, errors.New(strings.Join(regionErrors, "; "))
 	}
 
-	return gamaResponse, nil
-}
-
-func translateProfileInformation(addresses *[]Address, magentoUser MagentoUser) ([]Profile, []Profile) {
-	var states = GetMapStates()
-	var profilesToCreate, profilesToUpdate []Profile
+	billing, hasBilling := findAddress(*addresses, magentoUser.DefaultBilling)
+	mainAddressId := getMainAddressId(magentoUser)
 	for _, address := range *addresses {
-		var attributes = make(map[string]string)
-		for _, attribute := range address.Attributes {
-			attributes[attribute.Code] = attribute.Value
-		}
-		fields := Fields{
-			NumExt: attributes["external_number"],
-			NumInt: attributes["internal_number"],
-			Suburb: attributes["suburb"],
-			Reference: attributes["receptor_details"],
-			BNumExt: attributes["external_number"],
-			BNumInt: attributes["internal_number"],
-			BSuburb: attributes["suburb"],
-			BReference: attributes["receptor_details"],
-		}
-		if attributes["township"] == "" {
-			attributes["township"] = address.City
+		if !hasBilling {
+			billing = address
 		}
+		shippingAttributes := getAddressAttributes(address)
+		billingAttributes := getAddressAttributes(billing)
 		profile := Profile{
 			ProfileName: address.Id,
-			Sfirstname: address.Firstname,
-			Slastname:  address.Lastname,
-			Saddress:   address.Street[0],
-			Saddress2:	attributes["township"],
-			Scity:      address.City,
-			Scountry:   address.CountryId,
-			Sstate:		states[address.Region.RegionId],
-			Szipcode:   address.Postcode,
-			Sphone:     address.Telephone,
-			Bfirstname: address.Firstname,
-			Blastname:  address.Lastname,
-			Baddress:   address.Street[0],
-			Baddress2:	attributes["township"],
-			Bcity:      address.City,
-			Bcountry:   address.CountryId,
-			Bstate:		states[address.Region.RegionId],
-			Bzipcode:   address.Postcode,
-			Bphone:     address.Telephone,
-			Fields:		fields,
+			ProfileType: "S",
+			Sfirstname:  address.Firstname,
+			Slastname:   address.Lastname,
+			Saddress:    streetLine(address.Street, 0),
+			Saddress2:   shippingAttributes["township"],
+			Scity:       address.City,
+			Scountry:    address.CountryId,
+			Sstate:      stateIds[address.Id],
+			Szipcode:    address.Postcode,
+			Sphone:      address.Telephone,
+			Bfirstname:  billing.Firstname,
+			Blastname:   billing.Lastname,
+			Baddress:    streetLine(billing.Street, 0),
+			Baddress2:   billingAttributes["township"],
+			Bcity:       billing.City,
+			Bcountry:    billing.CountryId,
+			Bstate:      stateIds[billing.Id],
+			Bzipcode:    billing.Postcode,
+			Bphone:      billing.Telephone,
+			Fields:      translateProfileFields(shippingAttributes, billingAttributes, profileFields),
+		}
+		if address.Id == mainAddressId {
+			profile.ProfileType = "P"
 		}
 		migratedProfile, err := GetAddressFromDb(magentoUser.Email + fmt.Sprint(address.Id))
 		if err != nil || !migratedProfile.Result {
@@ -389,7 +375,38 @@ func translateProfileInformation(addresses *[]Address, magentoUser MagentoUser)
 		}
 	}
 
-	return profilesToCreate, profilesToUpdate
+	return profilesToCreate, profilesToUpdate, nil
+}
+
+// getMainAddressId returns the address of the main profile: the default shipping address or the
+// default billing address when the user has no default shipping address
+func getMainAddressId(magentoUser MagentoUser) int {
+	if magentoUser.DefaultShipping != 0 {
+		return magentoUser.DefaultShipping
+	}
+	return magentoUser.DefaultBilling
+}
+
+func findAddress(addresses []Address, addressId int) (Address, bool) {
+	for _, address := range addresses {
+		if addressId != 0 && address.Id == addressId {
+			return address, true
+		}
+	}
+	return Address{}, false
+}
+
+// getAddressAttributes returns the custom attributes of the address by code, the township is the city
+// when the address doesn't have it
+func getAddressAttributes(address Address) map[string]string {
+	var attributes = make(map[string]string)
+	for _, attribute := range address.Attributes {
+		attributes[attribute.Code] = attribute.Value
+	}
+	if attributes["township"] == "" {
+		attributes["township"] = address.City
+	}
+	return attributes
 }
 
 func checkProfilesResponse(email string, gamaProfileResponse GamaProfileResponse) {
@@ -405,12 +422,12 @@ func checkProfilesResponse(email string, gamaProfileResponse GamaProfileResponse
 }
 
 func savePrincipalProfileId(magentoUser MagentoUser, gamaUserResponse GamaUserResponse) {
-	if magentoUser.DefaultShipping != 0 {
+	if mainAddressId := getMainAddressId(magentoUser); mainAddressId != 0 {
 		var addressProfile = AddressProfile{
-			MagentoId: magentoUser.DefaultShipping,
-			GamaId: gamaUserResponse.ProfileId,
-			Email: magentoUser.Email + fmt.Sprint(magentoUser.DefaultShipping),
-			Result: gamaUserResponse.ProfileId != 0,
+			MagentoId: mainAddressId,
+			GamaId:    gamaUserResponse.ProfileId,
+			Email:     magentoUser.Email + fmt.Sprint(mainAddressId),
+			Result:    gamaUserResponse.ProfileId != 0,
 		}
 		SaveAddressToDb(addressProfile)
 	}
@@ -438,4 +455,4 @@ func saveUserHash(userHash UserHash) (err error){
 		err = SaveHashToDb(userHash)
 	}
 	return err
-}
\ No newline at end of file
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	magentohash "migration-m2-gama/magentohash"
+)
+
+const (
+	CSVHashesFormat    = "csv"
+	NDJSONHashesFormat = "ndjson"
+
+	hashesBatchSize = 100 // records validated before writing their hashes
+)
+
+// HashRecord is a row of the export of the magento customer_entity table, only email and password_hash are read
+type HashRecord struct {
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+
+	record  int    // position on the export, starting on 1
+	problem string // why the record can't be read, the rest of the export is still read
+}
+
+// hashRecordReader returns the records of an export one by one and io.EOF at the end
+type hashRecordReader interface {
+	next() (HashRecord, error)
+}
+
+// GetHashesFormat returns the format of the export of source: the one requested, or ndjson for the
+// .ndjson and .jsonl files and csv for the rest
+func GetHashesFormat(source string, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case CSVHashesFormat:
+		return CSVHashesFormat, nil
+	case NDJSONHashesFormat:
+		return NDJSONHashesFormat, nil
+	case "":
+		extension := strings.ToLower(filepath.Ext(source))
+		if extension == ".ndjson" || extension == ".jsonl" {
+			return NDJSONHashesFormat, nil
+		}
+		return CSVHashesFormat, nil
+	}
+	return "", errors.New("format " + format + " is not supported, use csv or ndjson")
+}
+
+// processHashesJob imports the password hashes of a customer_entity export into the hash table, the
+// hashes are saved as pending so the users sync sends them to GAMA. The records are written in batches
+// and the job is resumed after the records already imported, only the invalid records have results.
+func processHashesJob(ctx context.Context, job *Job) error {
+	format, err := GetHashesFormat(job.Request.Source, job.Request.Format)
+	if err != nil {
+		return err
+	}
+	source, err := openHashesSource(job.Request.Source)
+	if err != nil {
+		return errors.New("error opening " + job.Request.Source + ": " + err.Error())
+	}
+	defer source.Close()
+
+	var reader hashRecordReader
+	if format == NDJSONHashesFormat {
+		reader = newNDJSONHashReader(source)
+	} else {
+		reader, err = newCSVHashReader(source)
+		if err != nil {
+			return err
+		}
+	}
+
+	for skipped := 0; skipped < job.Processed; skipped++ {
+		_, err = reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var userHashes []UserHash
+	var bodyResults []BodyResult
+	read := 0
+	saveBatch := func() error {
+		if len(userHashes) > 0 {
+			pending, err := pendingHashes(userHashes)
+			if err == nil && len(pending) > 0 {
+				err = SaveHashesToDb(pending)
+			}
+			if err != nil {
+				return errors.New("error saving the hashes after record " + strconv.Itoa(job.Processed) + ": " + err.Error())
+			}
+		}
+		addJobResults(job, bodyResults...)
+		job.Processed += read
+		job.Total = job.Processed
+		userHashes, bodyResults, read = nil, nil, 0
+		return saveJob(job)
+	}
+
+	for {
+		if read == 0 && IsRunningOutOfTime(ctx) {
+			job.Status = JobQueued
+			return saveJob(job)
+		}
+
+		record, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		read++
+
+		userHash, bodyResult := validateHashRecord(record)
+		if bodyResult.ResponseCode == 3 {
+			bodyResults = append(bodyResults, bodyResult)
+		} else if userHash.Hash != "" {
+			userHashes = append(userHashes, userHash)
+		}
+
+		if read == hashesBatchSize {
+			err = saveBatch()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = saveBatch()
+	if err != nil {
+		return err
+	}
+	job.Status = JobFinished
+	return saveJob(job)
+}
+
+// pendingHashes returns the hashes of the batch to save: an email repeated on the export keeps its last
+// record and the hashes already sent to GAMA (not pending) are never replaced
+func pendingHashes(userHashes []UserHash) ([]UserHash, error) {
+	var unique []UserHash
+	indexes := make(map[string]int)
+	for _, userHash := range userHashes {
+		if index, ok := indexes[userHash.Email]; ok {
+			unique[index] = userHash
+			continue
+		}
+		indexes[userHash.Email] = len(unique)
+		unique = append(unique, userHash)
+	}
+
+	emails := make([]string, len(unique))
+	for index, userHash := range unique {
+		emails[index] = userHash.Email
+	}
+	savedHashes, err := GetHashesFromDb(emails)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []UserHash
+	for _, userHash := range unique {
+		if savedHash, ok := savedHashes[userHash.Email]; ok && !savedHash.Pending {
+			continue
+		}
+		pending = append(pending, userHash)
+	}
+	return pending, nil
+}
+
+// validateHashRecord returns the pending hash of the record, or a result with code 3 when it can't be
+// imported. The customers without password (created by the admin) have no hash.
+func validateHashRecord(record HashRecord) (UserHash, BodyResult) {
+	bodyResult := BodyResult{
+		Email:        strings.TrimSpace(record.Email),
+		Entity:       "hash",
+		Reference:    "record " + strconv.Itoa(record.record),
+		ResponseCode: 1, // only the errors are kept on the job
+	}
+	if record.problem != "" {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = record.problem
+		return UserHash{}, bodyResult
+	}
+	if !strings.Contains(bodyResult.Email, "@") {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = "the record has no valid email"
+		return UserHash{}, bodyResult
+	}
+
+	passwordHash := strings.TrimSpace(record.PasswordHash)
+	if passwordHash == "" {
+		return UserHash{}, bodyResult
+	}
+	_, err := magentohash.Parse(passwordHash)
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+		bodyResult.ErrorCode = getErrorCode(err)
+		return UserHash{}, bodyResult
+	}
+
+	return UserHash{
+		Email:   bodyResult.Email,
+		Hash:    base64.StdEncoding.EncodeToString([]byte(passwordHash)),
+		Pending: true,
+	}, bodyResult
+}
+
+// openHashesSource opens a local file or an s3://bucket/key object, the objects are read from the
+// S3-compatible store of hashesS3Endpoint when it is defined
+func openHashesSource(source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "s3://") {
+		return os.Open(source)
+	}
+
+	location := strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+	if len(location) != 2 || location[0] == "" || location[1] == "" {
+		return nil, errors.New("the source must be s3://bucket/key")
+	}
+
+	config := aws.NewConfig()
+	if os.Getenv("hashesS3Endpoint") != "" {
+		config = config.WithEndpoint(os.Getenv("hashesS3Endpoint")).WithS3ForcePathStyle(true)
+	}
+	if os.Getenv("hashesS3Region") != "" {
+		config = config.WithRegion(os.Getenv("hashesS3Region"))
+	}
+	if os.Getenv("hashesS3AccessKey") != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(os.Getenv("hashesS3AccessKey"), os.Getenv("hashesS3SecretKey"), ""))
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(location[0]),
+		Key:    aws.String(location[1]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// csvHashReader reads a csv export with header, the columns are found by name
+type csvHashReader struct {
+	reader      *csv.Reader
+	emailColumn int
+	hashColumn  int
+	record      int
+}
+
+func newCSVHashReader(source io.Reader) (*csvHashReader, error) {
+	reader := csv.NewReader(source)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("error reading the header of the export: " + err.Error())
+	}
+	hashReader := &csvHashReader{reader: reader, emailColumn: -1, hashColumn: -1}
+	for index, column := range header {
+		switch strings.ToLower(strings.Trim(column, " \ufeff")) {
+		case "email":
+			hashReader.emailColumn = index
+		case "password_hash":
+			hashReader.hashColumn = index
+		}
+	}
+	if hashReader.emailColumn == -1 || hashReader.hashColumn == -1 {
+		return nil, errors.New("the export must have the email and password_hash columns")
+	}
+
+	return hashReader, nil
+}
+
+func (r *csvHashReader) next() (HashRecord, error) {
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return HashRecord{}, err
+	}
+	r.record++
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		return HashRecord{record: r.record, problem: "the row is not valid csv: " + parseErr.Err.Error()}, nil
+	}
+	if err != nil {
+		return HashRecord{}, err
+	}
+
+	if len(row) <= r.emailColumn || len(row) <= r.hashColumn {
+		return HashRecord{record: r.record, problem: "the row doesn't have the email and password_hash columns"}, nil
+	}
+	return HashRecord{Email: row[r.emailColumn], PasswordHash: row[r.hashColumn], record: r.record}, nil
+}
+
+// ndjsonHashReader reads an export with a json object by line, the empty lines are skipped
+type ndjsonHashReader struct {
+	scanner *bufio.Scanner
+	record  int
+}
+
+func newNDJSONHashReader(source io.Reader) *ndjsonHashReader {
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ndjsonHashReader{scanner: scanner}
+}
+
+func (r *ndjsonHashReader) next() (HashRecord, error) {
+	for r.scanner.Scan() {
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		r.record++
+
+		record := HashRecord{}
+		err := json.Unmarshal([]byte(text), &record)
+		if err != nil {
+			return HashRecord{record: r.record, problem: "the line is not a json object: " + err.Error()}, nil
+		}
+		record.record = r.record
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return HashRecord{}, err
+	}
+	return HashRecord{}, io.EOF
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobFinished = "finished"
+	JobFailed   = "failed"
+
+	UsersJob      = "users"
+	OrdersJob     = "orders"
+	ProductsJob   = "products"
+	CategoriesJob = "categories"
+	FeaturesJob   = "features"
+	HashesJob     = "hashes"
+
+	maxJobResultsSize = 300 * 1024 // bytes of results kept on the job item, the rest of the item is small
+)
+
+// Job is a migration requested to POST /users, /orders, /products, /categories, /features or /hashes and processed by the worker
+type Job struct {
+	Id        string       `json:"id"`
+	Type      string       `json:"type"` // users, orders, products, categories, features or hashes
+	Status    string       `json:"status"`
+	Request   BodyRequest  `json:"request"`
+	Total     int          `json:"total"`
+	Processed int          `json:"processed"`
+	Results   []BodyResult `json:"results"` // jobs with all=true and hashes jobs only keep the errors
+	// OmittedResults counts the results left out of Results once it reached maxJobResultsSize
+	OmittedResults int         `json:"omitted_results,omitempty"`
+	Checkpoint     *Checkpoint `json:"checkpoint,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	CreatedAt      string      `json:"created_at"`
+	UpdatedAt      string      `json:"updated_at"`
+
+	resultsSize    int // json size of Results, counted again when the job is read from the store
+	resultsCounted bool
+}
+
+// JobQueue hands the id of a saved job to the worker
+type JobQueue interface {
+	Enqueue(jobId string) error
+}
+
+type sqsJobQueue struct {
+	url string
+}
+
+// LocalJobQueue processes the jobs in goroutines of the same process, used for local runs
+type LocalJobQueue struct {
+	jobs chan string
+	wg   sync.WaitGroup
+}
+
+var jobQueue JobQueue
+
+// GetJobQueue returns the queue configured with SetJobQueue, otherwise sqs when JOBS_QUEUE_URL is defined
+// and the in process queue when not
+func GetJobQueue() JobQueue {
+	if jobQueue == nil {
+		if os.Getenv("JOBS_QUEUE_URL") != "" {
+			jobQueue = NewSQSJobQueue(os.Getenv("JOBS_QUEUE_URL"))
+		} else {
+			jobQueue = NewLocalJobQueue(1)
+		}
+	}
+	return jobQueue
+}
+
+func SetJobQueue(queue JobQueue) {
+	jobQueue = queue
+}
+
+func NewSQSJobQueue(url string) JobQueue {
+	return &sqsJobQueue{url: url}
+}
+
+func (q *sqsJobQueue) Enqueue(jobId string) error {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	svc := sqs.New(sess)
+
+	_, err := svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(jobId),
+	})
+	if err != nil {
+		fmt.Println("Got error calling SendMessage: ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func NewLocalJobQueue(workers int) *LocalJobQueue {
+	q := &LocalJobQueue{jobs: make(chan string, 100)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for jobId := range q.jobs {
+				err := ProcessJob(context.Background(), jobId)
+				if err != nil {
+					fmt.Println("Error returned by ProcessJob function: ", err.Error())
+				}
+				q.wg.Done()
+			}
+		}()
+	}
+	return q
+}
+
+func (q *LocalJobQueue) Enqueue(jobId string) error {
+	q.wg.Add(1)
+	// the workers enqueue again the jobs stopped before the timeout, sending from another goroutine
+	// keeps a worker from blocking on itself when the channel is full
+	go func() {
+		q.jobs <- jobId
+	}()
+	return nil
+}
+
+// Wait blocks until every enqueued job, including the ones enqueued meanwhile, was processed
+func (q *LocalJobQueue) Wait() {
+	q.wg.Wait()
+}
+
+// CreateJob saves a queued job of the type for the request and sends it to the worker
+func CreateJob(jobType string, bodyRequest BodyRequest) (Job, error) {
+	id, err := newJobId()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := Job{
+		Id:        id,
+		Type:      jobType,
+		Status:    JobQueued,
+		Request:   bodyRequest,
+		Total:     len(bodyRequest.Users),
+		Results:   []BodyResult{},
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if jobType == ProductsJob {
+		job.Total = len(bodyRequest.Skus)
+	}
+	err = saveJob(&job)
+	if err != nil {
+		return job, err
+	}
+
+	err = GetJobQueue().Enqueue(job.Id)
+	if err != nil {
+		return job, err
+	}
+
+	return job, nil
+}
+
+// ProcessJob runs the migration of a job. When the lambda is running out of time the progress is
+// saved and the job is enqueued again, so the next worker continues with the pending users.
+func ProcessJob(ctx context.Context, jobId string) error {
+	job, err := GetJobFromDb(jobId)
+	if err != nil {
+		fmt.Println("Error returned by GetJobFromDb function: ", err.Error())
+		return err
+	}
+	if job.Status == JobFinished || job.Status == JobFailed {
+		fmt.Println("Job " + job.Id + " was already processed, status: " + job.Status)
+		return nil
+	}
+
+	job.Status = JobRunning
+	err = saveJob(&job)
+	if err != nil {
+		return err
+	}
+	defer withRequestsContext(ctx)()
+
+	request := job.Request
+	if job.Type == OrdersJob {
+		err = processListJob(ctx, &job, len(request.Users), func(index int) ([]BodyResult, error) {
+			return SyncOrders(request.Users[index], request.Force)
+		})
+	} else if job.Type == CategoriesJob {
+		err = processCategoriesJob(ctx, &job)
+	} else if job.Type == FeaturesJob {
+		err = processFeaturesJob(ctx, &job)
+	} else if job.Type == HashesJob {
+		err = processHashesJob(ctx, &job)
+	} else if job.Type == ProductsJob && request.All {
+		err = processAllJob(ctx, &job, SyncAllProducts)
+	} else if job.Type == ProductsJob {
+		err = processListJob(ctx, &job, len(request.Skus), func(index int) ([]BodyResult, error) {
+			return SyncProduct(request.Skus[index], request.Force)
+		})
+	} else if request.All {
+		err = processAllJob(ctx, &job, SyncAllUsers)
+	} else {
+		err = processListJob(ctx, &job, len(request.Users), func(index int) ([]BodyResult, error) {
+			return SyncUser(request.Users[index], request.Force, request.DryRun)
+		})
+	}
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		saveJob(&job)
+		return err
+	}
+
+	if job.Status == JobQueued {
+		return GetJobQueue().Enqueue(job.Id)
+	}
+
+	return nil
+}
+
+// processListJob runs sync for every user or sku of the request that was not processed yet
+func processListJob(ctx context.Context, job *Job, total int, sync func(index int) ([]BodyResult, error)) error {
+	for index := job.Processed; index < total; index++ {
+		if IsRunningOutOfTime(ctx) {
+			fmt.Println("Stopping job " + job.Id + " before lambda timeout at item: " + strconv.Itoa(index))
+			job.Status = JobQueued
+			return saveJob(job)
+		}
+
+		results, err := sync(index)
+		if err != nil {
+			return err
+		}
+		addJobResults(job, results...)
+		job.Processed++
+
+		err = saveJob(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	job.Status = JobFinished
+	return saveJob(job)
+}
+
+// processCategoriesJob migrates the magento categories tree top-down. The tree is read again when the
+// job is resumed, the ids of the categories already processed are kept on the checkpoint of the job so
+// the categories added or moved meanwhile are neither skipped nor repeated.
+func processCategoriesJob(ctx context.Context, job *Job) error {
+	root, err := GetMagentoCategories()
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoCategories function: ", err.Error())
+		return err
+	}
+	categories := FlattenCategories(root)
+	job.Total = len(categories)
+
+	if job.Checkpoint == nil {
+		job.Checkpoint = &Checkpoint{Id: CategoriesJob, StartedAt: time.Now().Format(time.RFC3339)}
+	}
+	processed := make(map[int]bool)
+	for _, categoryId := range job.Checkpoint.ProcessedIds {
+		processed[categoryId] = true
+	}
+
+	for _, category := range categories {
+		if processed[category.Id] {
+			continue
+		}
+		if IsRunningOutOfTime(ctx) {
+			fmt.Println("Stopping job " + job.Id + " before lambda timeout at category: " + strconv.Itoa(category.Id))
+			job.Status = JobQueued
+			return saveJob(job)
+		}
+
+		result := ImportMagentoCategory(category, job.Request.Force)
+		addJobResults(job, result)
+		countResult(job.Checkpoint, result)
+		job.Checkpoint.ProcessedIds = append(job.Checkpoint.ProcessedIds, category.Id)
+		job.Checkpoint.Processed = len(job.Checkpoint.ProcessedIds)
+		job.Processed = job.Checkpoint.Processed
+
+		err = saveJob(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	job.Checkpoint.Total = job.Total
+	job.Checkpoint.Finished = true
+	job.Status = JobFinished
+	return saveJob(job)
+}
+
+// processFeaturesJob migrates the magento product attributes, they are read again when the job is resumed
+func processFeaturesJob(ctx context.Context, job *Job) error {
+	attributes, err := GetMagentoProductAttributes()
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoProductAttributes function: ", err.Error())
+		return err
+	}
+	job.Total = len(attributes)
+
+	return processListJob(ctx, job, len(attributes), func(index int) ([]BodyResult, error) {
+		return []BodyResult{ImportMagentoAttribute(attributes[index], job.Request.Force)}, nil
+	})
+}
+
+// processAllJob runs a bulk migration (SyncAllUsers or SyncAllProducts) until its checkpoint is finished
+func processAllJob(ctx context.Context, job *Job, syncAll func(ctx context.Context, force bool, pageSize int, restart bool) ([]BodyResult, Checkpoint, error)) error {
+	// only the first run of the job can restart the migration, the next ones resume it
+	restart := job.Request.Restart && job.Checkpoint == nil
+
+	results, checkpoint, err := syncAll(ctx, job.Request.Force, job.Request.PageSize, restart)
+	for _, result := range results {
+		if result.ResponseCode == 3 {
+			addJobResults(job, result)
+		}
+	}
+	job.Checkpoint = &checkpoint
+	job.Processed = checkpoint.Processed
+	job.Total = checkpoint.Total
+	if err != nil {
+		return err
+	}
+
+	job.Status = JobQueued
+	if checkpoint.Finished {
+		job.Status = JobFinished
+	}
+	return saveJob(job)
+}
+
+// addJobResults appends the results while the json of Results stays under maxJobResultsSize, the job is
+// saved as a single DynamoDB item (400KB at most) so the rest are only counted on OmittedResults
+func addJobResults(job *Job, results ...BodyResult) {
+	if !job.resultsCounted {
+		job.resultsSize = 0
+		for _, result := range job.Results {
+			job.resultsSize += resultSize(result)
+		}
+		job.resultsCounted = true
+	}
+
+	for _, result := range results {
+		size := resultSize(result)
+		if job.OmittedResults > 0 || job.resultsSize+size > maxJobResultsSize {
+			job.OmittedResults++
+			continue
+		}
+		job.Results = append(job.Results, result)
+		job.resultsSize += size
+	}
+}
+
+func resultSize(result BodyResult) int {
+	marshaledResult, _ := json.Marshal(result)
+	return len(marshaledResult)
+}
+
+func saveJob(job *Job) error {
+	job.UpdatedAt = time.Now().Format(time.RFC3339)
+	err := SaveJobToDb(*job)
+	if err != nil {
+		fmt.Println("Error returned by SaveJobToDb function: ", err.Error())
+	}
+	return err
+}
+
+func newJobId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", errors.New("error generating the job id: " + err.Error())
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -1,16 +1,15 @@
 package services
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
+	"net/url"
+	"strconv"
 )
 
 const (
-	getUserEndpoint = "customers/search?searchCriteria[filter_groups][0][filters][0][field]=email&searchCriteria[filter_groups][0][filters][0][value]="
+	getUserEndpoint         = "customers/search?searchCriteria[filter_groups][0][filters][0][field]=email&searchCriteria[filter_groups][0][filters][0][value]="
+	getUsersPageEndpoint    = "customers/search?searchCriteria[sortOrders][0][field]=entity_id&searchCriteria[sortOrders][0][direction]=ASC"
+	getUpdatedUsersEndpoint = "customers/search?searchCriteria[sortOrders][0][field]=updated_at&searchCriteria[sortOrders][0][direction]=ASC&searchCriteria[sortOrders][1][field]=entity_id&searchCriteria[sortOrders][1][direction]=ASC&searchCriteria[filter_groups][0][filters][0][field]=updated_at&searchCriteria[filter_groups][0][filters][0][condition_type]=gteq&searchCriteria[filter_groups][0][filters][0][value]="
+	DefaultPageSize         = 100
 )
 
 type Attribute struct {
@@ -43,9 +42,11 @@ type MagentoUser struct {
 	Firstname 		string 	   `json:"firstname"`
 	Lastname  		string 	   `json:"lastname"`
 	GroupId   		int    	   `json:"group_id"`
+	DefaultBilling  int    	   `json:"default_billing,string,omitempty"`
 	DefaultShipping int    	   `json:"default_shipping,string,omitempty"`
 	Addresses 		*[]Address `json:"addresses,omitempty"`
 	Hash      		string     `json:"hash"`
+	UpdatedAt 		string     `json:"updated_at,omitempty"`
 }
 
 type MagentoResults struct {
@@ -54,49 +55,27 @@ type MagentoResults struct {
 }
 
 func GetMagentoUser(email string) (MagentoResults, error) {
-	magentoResults := MagentoResults{}
-
-	response, err := consumeMagentoEndpoint(getUserEndpoint + email)
-
-	if err != nil {
-		fmt.Println("Error returned by consumeMagentoEndpoint function: ", err.Error())
-		return magentoResults, err
-	}
-
-	json.Unmarshal(response, &magentoResults)
-
-	return magentoResults, nil
+	return GetMagentoClient().SearchCustomers(getUserEndpoint + email)
 }
 
-func consumeMagentoEndpoint(request string) ([]byte, error) {
-	url := os.Getenv("magentoUrl") + request
-	var bearer = "Bearer " + os.Getenv("magentoBearer")
-
-	req, err := http.NewRequest("GET", url, nil) // Create a new request using http
-	if err != nil {
-		fmt.Println("Error create http object ("+url+") function consumeMagentoEndpoint: ", err.Error())
-		return nil, err
+// GetMagentoUsersPage returns the first page of the magento customers with an entity_id greater than
+// afterEntityId sorted by entity_id, total_count is the number of customers left
+func GetMagentoUsersPage(afterEntityId int, pageSize int) (MagentoResults, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
 	}
-	req.Header.Add("Authorization", bearer) // Add authorization header to the req
+	request := getUsersPageEndpoint + "&searchCriteria[filter_groups][0][filters][0][field]=entity_id&searchCriteria[filter_groups][0][filters][0][condition_type]=gt&searchCriteria[filter_groups][0][filters][0][value]=" + strconv.Itoa(afterEntityId) + "&searchCriteria[pageSize]=" + strconv.Itoa(pageSize) + "&searchCriteria[currentPage]=1"
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-
-	if resp.Status != "200 OK" {
-		return nil, errors.New("magento endpoint (" + url + ") returned a non 200 status, reurned: " + resp.Status)
-	}
+	return GetMagentoClient().SearchCustomers(request)
+}
 
-	if err != nil {
-		fmt.Println("Error on request of endpoint ("+url+"): ", err.Error())
-		return nil, err
+// GetMagentoUsersUpdatedSince returns one page of the magento customers updated at or after since
+// (magento date format, UTC) sorted by updated_at and entity_id
+func GetMagentoUsersUpdatedSince(since string, page int, pageSize int) (MagentoResults, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
 	}
+	request := getUpdatedUsersEndpoint + url.QueryEscape(since) + "&searchCriteria[pageSize]=" + strconv.Itoa(pageSize) + "&searchCriteria[currentPage]=" + strconv.Itoa(page)
 
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading resp.Body function consumeMagentoEndpoint: ", err.Error())
-		return nil, err
-	}
-	return body, nil
+	return GetMagentoClient().SearchCustomers(request)
 }
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+)
+
+const magentoMainImage = "image" // the role of the main image on the media_gallery_entries types
+
+type MagentoMediaEntry struct {
+	Id        int      `json:"id"`
+	MediaType string   `json:"media_type"`
+	Label     string   `json:"label"`
+	Position  int      `json:"position"`
+	Disabled  bool     `json:"disabled"`
+	Types     []string `json:"types"`
+	File      string   `json:"file"` // path under the catalog/product media folder
+}
+
+type GamaImage struct {
+	ImageName string `json:"image_name"`
+	ImageData string `json:"image_data"` // base64 content
+	Alt       string `json:"alt"`
+}
+
+type GamaImagePair struct {
+	Position int       `json:"position"`
+	Detailed GamaImage `json:"detailed"`
+}
+
+// GamaProductImages is the payload that adds images to a GAMA product, the additional images are
+// keyed by their order on the request
+type GamaProductImages struct {
+	MainPair   *GamaImagePair           `json:"main_pair,omitempty"`
+	ImagePairs map[string]GamaImagePair `json:"image_pairs,omitempty"`
+}
+
+// ImageMapping records an image uploaded to a GAMA product, the key is the product_id and the
+// sha256 of the content (plus :main for the main image) so the same file is never uploaded twice
+// to the same product
+type ImageMapping struct {
+	Id     string `json:"id"`
+	Sku    string `json:"sku"`
+	File   string `json:"file"`
+	GamaId int    `json:"gama_id"`
+	Main   bool   `json:"main"`
+	Result bool   `json:"response_code"`
+}
+
+// productImages are the gallery entries to upload to a GAMA product
+type productImages struct {
+	sku     string
+	gamaId  int
+	entries []MagentoMediaEntry
+}
+
+func GetMagentoMedia(file string) ([]byte, error) {
+	return GetMagentoClient().GetMedia(file)
+}
+
+// sendProductImages downloads the enabled images of the gallery and uploads the ones that the GAMA
+// product doesn't have yet, keeping their position, label and main image. Every image is sent on its
+// own request so the payloads stay small, the ones that fail are sent again on the next run.
+func sendProductImages(images productImages) error {
+	entries := getGalleryImages(images.entries)
+	if len(entries) == 0 {
+		return nil
+	}
+	// the main image goes first, a copy of it on the gallery is the one skipped
+	mainIndex := 0
+	for index, entry := range entries {
+		if hasType(entry, magentoMainImage) {
+			mainIndex = index
+			break
+		}
+	}
+	entries = append([]MagentoMediaEntry{entries[mainIndex]}, append(entries[:mainIndex:mainIndex], entries[mainIndex+1:]...)...)
+
+	var uploadErr error
+	uploaded := make(map[string]bool)
+	for index, entry := range entries {
+		isMain := index == 0
+		content, err := GetMagentoMedia(entry.File)
+		if err != nil {
+			fmt.Println("Error returned by GetMagentoMedia function: ", err.Error())
+			return errors.New("error downloading the image " + entry.File + ": " + err.Error())
+		}
+		hash := sha256.Sum256(content)
+		contentKey := strconv.Itoa(images.gamaId) + ":" + hex.EncodeToString(hash[:])
+		// the main image has its own key, it is uploaded even when the gallery had the same file
+		key := contentKey
+		if isMain {
+			key = contentKey + ":main"
+		}
+
+		if uploaded[contentKey] || isImageUploaded(key, false) || isImageUploaded(contentKey, isMain) || (!isMain && isImageUploaded(contentKey+":main", false)) {
+			continue
+		}
+		uploaded[contentKey] = true
+
+		pair := GamaImagePair{
+			Position: entry.Position,
+			Detailed: GamaImage{
+				ImageName: path.Base(entry.File),
+				ImageData: base64.StdEncoding.EncodeToString(content),
+				Alt:       entry.Label,
+			},
+		}
+		gamaImages := GamaProductImages{}
+		if isMain {
+			gamaImages.MainPair = &pair
+		} else {
+			gamaImages.ImagePairs = map[string]GamaImagePair{"0": pair}
+		}
+
+		err = GetCSCartClient().UpdateProductImages(images.gamaId, gamaImages)
+		if err != nil && uploadErr == nil {
+			uploadErr = errors.New("error uploading the images of " + images.sku + ": " + err.Error())
+		}
+		saveErr := SaveImageToDb(ImageMapping{
+			Id:     key,
+			Sku:    images.sku,
+			File:   entry.File,
+			GamaId: images.gamaId,
+			Main:   isMain,
+			Result: err == nil,
+		})
+		if saveErr != nil {
+			fmt.Println("Error returned by SaveImageToDb function: ", saveErr.Error())
+		}
+	}
+
+	return uploadErr
+}
+
+// isImageUploaded tells if the image of the key was uploaded, only as main image when main is true
+func isImageUploaded(key string, main bool) bool {
+	mapping, err := GetImageFromDb(key)
+	return err == nil && mapping.Result && (mapping.Main || !main)
+}
+
+// getGalleryImages returns the enabled images of the gallery sorted by position
+func getGalleryImages(entries []MagentoMediaEntry) []MagentoMediaEntry {
+	var images []MagentoMediaEntry
+	for _, entry := range entries {
+		if entry.MediaType == "image" && !entry.Disabled {
+			images = append(images, entry)
+		}
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Position < images[j].Position })
+	return images
+}
+
+func hasType(entry MagentoMediaEntry, mediaType string) bool {
+	for _, entryType := range entry.Types {
+		if entryType == mediaType {
+			return true
+		}
+	}
+	return false
+}
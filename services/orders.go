@@ -0,0 +1,346 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	getOrdersEndpoint = "orders?searchCriteria[sortOrders][0][field]=entity_id&searchCriteria[sortOrders][0][direction]=ASC&searchCriteria[filter_groups][0][filters][0][field]=customer_email&searchCriteria[filter_groups][0][filters][0][value]="
+	ordersEndpoint    = "api/orders"
+)
+
+type MagentoOrderItem struct {
+	ItemId       int     `json:"item_id"`
+	ParentItemId int     `json:"parent_item_id,omitempty"`
+	Sku          string  `json:"sku"`
+	Name         string  `json:"name"`
+	ProductType  string  `json:"product_type"`
+	QtyOrdered   float64 `json:"qty_ordered"`
+	Price        float64 `json:"price"`
+	RowTotal     float64 `json:"row_total"`
+}
+
+type MagentoOrderAddress struct {
+	Firstname  string   `json:"firstname"`
+	Lastname   string   `json:"lastname"`
+	Street     []string `json:"street"`
+	City       string   `json:"city"`
+	Postcode   string   `json:"postcode"`
+	CountryId  string   `json:"country_id"`
+	Region     string   `json:"region"`
+	RegionCode string   `json:"region_code"`
+	RegionId   int      `json:"region_id"`
+	Telephone  string   `json:"telephone"`
+}
+
+type MagentoOrderShipping struct {
+	Address MagentoOrderAddress `json:"address"`
+	Method  string              `json:"method"`
+}
+
+type MagentoShippingAssignment struct {
+	Shipping MagentoOrderShipping `json:"shipping"`
+}
+
+type MagentoOrderExtension struct {
+	ShippingAssignments []MagentoShippingAssignment `json:"shipping_assignments"`
+}
+
+type MagentoOrderPayment struct {
+	Method string `json:"method"`
+}
+
+type MagentoOrder struct {
+	EntityId            int                   `json:"entity_id"`
+	IncrementId         string                `json:"increment_id"`
+	CustomerEmail       string                `json:"customer_email"`
+	Status              string                `json:"status"`
+	CreatedAt           string                `json:"created_at"`
+	GrandTotal          float64               `json:"grand_total"`
+	Subtotal            float64               `json:"subtotal"`
+	ShippingAmount      float64               `json:"shipping_amount"`
+	DiscountAmount      float64               `json:"discount_amount"`
+	TaxAmount           float64               `json:"tax_amount"`
+	ShippingDescription string                `json:"shipping_description"`
+	Items               []MagentoOrderItem    `json:"items"`
+	BillingAddress      MagentoOrderAddress   `json:"billing_address"`
+	Payment             MagentoOrderPayment   `json:"payment"`
+	ExtensionAttributes MagentoOrderExtension `json:"extension_attributes"`
+}
+
+type MagentoOrderResults struct {
+	Items []MagentoOrder `json:"items"`
+	Total int            `json:"total_count"`
+}
+
+type GamaOrderProduct struct {
+	ProductId   int     `json:"product_id"`
+	ProductCode string  `json:"product_code"`
+	Product     string  `json:"product"`
+	Amount      float64 `json:"amount"`
+	Price       float64 `json:"price"`
+}
+
+type GamaOrderUserData struct {
+	Email      string `json:"email"`
+	Firstname  string `json:"firstname"`
+	Lastname   string `json:"lastname"`
+	Phone      string `json:"phone"`
+	Sfirstname string `json:"s_firstname"`
+	Slastname  string `json:"s_lastname"`
+	Saddress   string `json:"s_address"`
+	Saddress2  string `json:"s_address_2"`
+	Scity      string `json:"s_city"`
+	Scountry   string `json:"s_country"`
+	Sstate     int    `json:"s_state"`
+	Szipcode   string `json:"s_zipcode"`
+	Sphone     string `json:"s_phone"`
+	Bfirstname string `json:"b_firstname"`
+	Blastname  string `json:"b_lastname"`
+	Baddress   string `json:"b_address"`
+	Baddress2  string `json:"b_address_2"`
+	Bcity      string `json:"b_city"`
+	Bcountry   string `json:"b_country"`
+	Bstate     int    `json:"b_state"`
+	Bzipcode   string `json:"b_zipcode"`
+	Bphone     string `json:"b_phone"`
+}
+
+type GamaOrder struct {
+	UserId           string                      `json:"user_id"`
+	Status           string                      `json:"status"`
+	Timestamp        int64                       `json:"timestamp,omitempty"`
+	Total            float64                     `json:"total"`
+	Subtotal         float64                     `json:"subtotal"`
+	ShippingCost     float64                     `json:"shipping_cost"`
+	SubtotalDiscount float64                     `json:"subtotal_discount"`
+	TaxSubtotal      float64                     `json:"tax_subtotal"`
+	Notes            string                      `json:"notes"`
+	Products         map[string]GamaOrderProduct `json:"products"`
+	UserData         GamaOrderUserData           `json:"user_data"`
+}
+
+type GamaOrderResponse struct {
+	OrderId int `json:"order_id"`
+}
+
+// OrderMapping relates a magento order with the GAMA order, the key is email + increment_id like AddressProfile
+type OrderMapping struct {
+	MagentoId string `json:"magento_id"`
+	GamaId    int    `json:"gama_id,omitempty"`
+	Email     string `json:"email"`
+	Result    bool   `json:"response_code"`
+}
+
+// GetMagentoOrders returns one page of the orders of the customer with the email
+func GetMagentoOrders(email string, page int, pageSize int) (MagentoOrderResults, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	request := getOrdersEndpoint + email + "&searchCriteria[pageSize]=" + strconv.Itoa(pageSize) + "&searchCriteria[currentPage]=" + strconv.Itoa(page)
+
+	return GetMagentoClient().SearchOrders(request)
+}
+
+// SyncOrders migrates the magento orders of the user to GAMA, the user must be already migrated.
+// Orders with a mapping are only sent again when force is true.
+func SyncOrders(user User, force bool) ([]BodyResult, error) {
+	var bodyResults []BodyResult
+
+	fmt.Println("Migrating orders of: " + user.Email + " | force: " + strconv.FormatBool(force))
+	gamaResult, err := GetCSCartClient().GetUserByEmail(user.Email)
+	if err != nil {
+		fmt.Println("Error returned by GetUserByEmail function: ", err.Error())
+		return bodyResults, err
+	}
+	if len(gamaResult.Users) != 1 {
+		bodyResult := BodyResult{
+			Email:        user.Email,
+			Entity:       "order",
+			ResponseCode: 3,
+			Reason:       "user not found on GAMA, migrate the user before its orders",
+		}
+		return append(bodyResults, bodyResult), nil
+	}
+	gamaUserId := gamaResult.Users[0].Id
+
+	for page := 1; ; page++ {
+		magentoOrders, err := GetMagentoOrders(user.Email, page, DefaultPageSize)
+		if err != nil {
+			fmt.Println("Error returned by GetMagentoOrders function: ", err.Error())
+			return bodyResults, err
+		}
+
+		for _, magentoOrder := range magentoOrders.Items {
+			bodyResults = append(bodyResults, importMagentoOrder(magentoOrder, gamaUserId, force))
+		}
+
+		if len(magentoOrders.Items) == 0 || page*DefaultPageSize >= magentoOrders.Total {
+			return bodyResults, nil
+		}
+	}
+}
+
+func importMagentoOrder(magentoOrder MagentoOrder, gamaUserId string, force bool) BodyResult {
+	bodyResult := BodyResult{
+		Email:     magentoOrder.CustomerEmail,
+		Entity:    "order",
+		Reference: magentoOrder.IncrementId,
+	}
+
+	mapping, err := GetOrderFromDb(magentoOrder.CustomerEmail + magentoOrder.IncrementId)
+	migrated := err == nil && mapping.Result
+	if migrated && !force {
+		bodyResult.ResponseCode = 1
+		bodyResult.Reason = "order already migrated with id " + strconv.Itoa(mapping.GamaId)
+		return bodyResult
+	}
+
+	gamaOrder, err := translateOrderInformation(magentoOrder, gamaUserId)
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+		return bodyResult
+	}
+
+	mapping = OrderMapping{
+		MagentoId: magentoOrder.IncrementId,
+		GamaId:    mapping.GamaId,
+		Email:     magentoOrder.CustomerEmail + magentoOrder.IncrementId,
+	}
+	if migrated {
+		err = GetCSCartClient().UpdateOrder(mapping.GamaId, gamaOrder)
+		bodyResult.ResponseCode = 2
+	} else {
+		var gamaOrderResponse GamaOrderResponse
+		gamaOrderResponse, err = GetCSCartClient().CreateOrder(gamaOrder)
+		mapping.GamaId = gamaOrderResponse.OrderId
+		bodyResult.ResponseCode = 1
+	}
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+	}
+
+	mapping.Result = err == nil && mapping.GamaId != 0
+	err = SaveOrderToDb(mapping)
+	if err != nil {
+		fmt.Println("Error returned by SaveOrderToDb function: ", err.Error())
+	}
+
+	return bodyResult
+}
+
+func translateOrderInformation(magentoOrder MagentoOrder, gamaUserId string) (GamaOrder, error) {
+	status, ok := getMapOrderStatuses()[magentoOrder.Status]
+	if !ok {
+		return GamaOrder{}, errors.New("magento order status " + magentoOrder.Status + " has no GAMA status")
+	}
+
+	gamaOrder := GamaOrder{
+		UserId:           gamaUserId,
+		Status:           status,
+		Total:            magentoOrder.GrandTotal,
+		Subtotal:         magentoOrder.Subtotal,
+		ShippingCost:     magentoOrder.ShippingAmount,
+		SubtotalDiscount: -magentoOrder.DiscountAmount, // magento discounts are negative
+		TaxSubtotal:      magentoOrder.TaxAmount,
+		Notes:            "Magento order #" + magentoOrder.IncrementId + " " + magentoOrder.ShippingDescription,
+		Products:         make(map[string]GamaOrderProduct),
+	}
+	createdAt, err := time.Parse(magentoDateLayout, magentoOrder.CreatedAt)
+	if err == nil {
+		gamaOrder.Timestamp = createdAt.Unix()
+	}
+
+	for _, item := range magentoOrder.Items {
+		if item.ParentItemId != 0 {
+			continue // the children of configurable items are included on the parent item
+		}
+		gamaOrder.Products[strconv.Itoa(item.ItemId)] = GamaOrderProduct{
+			ProductId:   getGamaProductId(item.Sku),
+			ProductCode: item.Sku,
+			Product:     item.Name,
+			Amount:      item.QtyOrdered,
+			Price:       item.Price,
+		}
+	}
+
+	billing := magentoOrder.BillingAddress
+	shipping := billing // virtual orders don't have shipping address
+	if len(magentoOrder.ExtensionAttributes.ShippingAssignments) > 0 {
+		shipping = magentoOrder.ExtensionAttributes.ShippingAssignments[0].Shipping.Address
+	}
+	states, err := newRegionMapper()
+	if err != nil {
+		return gamaOrder, err
+	}
+	shippingState, err := states.stateId(shipping.CountryId, shipping.RegionId, shipping.RegionCode, shipping.Region)
+	if err != nil {
+		return gamaOrder, errors.New("shipping address: " + err.Error())
+	}
+	billingState, err := states.stateId(billing.CountryId, billing.RegionId, billing.RegionCode, billing.Region)
+	if err != nil {
+		return gamaOrder, errors.New("billing address: " + err.Error())
+	}
+	gamaOrder.UserData = GamaOrderUserData{
+		Email:      magentoOrder.CustomerEmail,
+		Firstname:  billing.Firstname,
+		Lastname:   billing.Lastname,
+		Phone:      billing.Telephone,
+		Sfirstname: shipping.Firstname,
+		Slastname:  shipping.Lastname,
+		Saddress:   streetLine(shipping.Street, 0),
+		Saddress2:  streetLine(shipping.Street, 1),
+		Scity:      shipping.City,
+		Scountry:   shipping.CountryId,
+		Sstate:     shippingState,
+		Szipcode:   shipping.Postcode,
+		Sphone:     shipping.Telephone,
+		Bfirstname: billing.Firstname,
+		Blastname:  billing.Lastname,
+		Baddress:   streetLine(billing.Street, 0),
+		Baddress2:  streetLine(billing.Street, 1),
+		Bcity:      billing.City,
+		Bcountry:   billing.CountryId,
+		Bstate:     billingState,
+		Bzipcode:   billing.Postcode,
+		Bphone:     billing.Telephone,
+	}
+
+	return gamaOrder, nil
+}
+
+// getGamaProductId returns the id of the migrated product with the sku, 0 when it was not migrated
+// and GAMA keeps the order line only with its code and name
+func getGamaProductId(sku string) int {
+	mapping, err := GetProductFromDb(sku)
+	if err != nil || !mapping.Result {
+		return 0
+	}
+	return mapping.GamaId
+}
+
+func streetLine(street []string, line int) string {
+	if line < len(street) {
+		return street[line]
+	}
+	return ""
+}
+
+func getMapOrderStatuses() map[string]string {
+	return map[string]string{
+		"pending":         "O",
+		"pending_payment": "O",
+		"payment_review":  "O",
+		"processing":      "P",
+		"holded":          "B",
+		"complete":        "C",
+		"closed":          "I",
+		"canceled":        "I",
+		"fraud":           "D",
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	DeleteOrphanProfiles = "delete" // default, the profile is deleted from GAMA
+	FlagOrphanProfiles   = "flag"   // the profile is kept on GAMA and only its record is flagged
+)
+
+// getOrphanProfilesMode returns what is done with the profiles of the addresses deleted on magento
+func getOrphanProfilesMode() (string, error) {
+	mode := os.Getenv("orphanProfiles")
+	if mode == "" {
+		return DeleteOrphanProfiles, nil
+	}
+	if mode != DeleteOrphanProfiles && mode != FlagOrphanProfiles {
+		return mode, errors.New("orphan profiles mode " + mode + " is not allowed, use delete or flag")
+	}
+	return mode, nil
+}
+
+// findOrphanProfiles returns the GAMA profiles migrated from magento addresses the customer doesn't have
+// anymore, the main profile is never an orphan because GAMA doesn't delete it
+func findOrphanProfiles(email string, addresses []Address, existingProfiles []map[string]interface{}) []Profile {
+	addressIds := make(map[int]bool)
+	for _, address := range addresses {
+		addressIds[address.Id] = true
+	}
+
+	var orphans []Profile
+	for _, existing := range existingProfiles {
+		profileId, _ := strconv.Atoi(fmt.Sprint(existing["profile_id"]))
+		magentoId, err := strconv.Atoi(fmt.Sprint(existing["profile_name"]))
+		if err != nil || addressIds[magentoId] || fmt.Sprint(existing["profile_type"]) == "P" {
+			continue
+		}
+		mapping, err := GetAddressFromDb(email + fmt.Sprint(magentoId))
+		if err != nil || !mapping.Result || mapping.Orphaned || mapping.GamaId != profileId {
+			continue // not created by the migration or already flagged
+		}
+		orphans = append(orphans, Profile{ProfileId: profileId, ProfileName: magentoId})
+	}
+
+	return orphans
+}
+
+// removeOrphanProfiles deletes or flags the orphan profiles and updates their address records
+func removeOrphanProfiles(email string, orphans []Profile) error {
+	if len(orphans) == 0 {
+		return nil
+	}
+	mode, err := getOrphanProfilesMode()
+	if err != nil {
+		return err
+	}
+
+	deleted := make(map[int]bool)
+	if mode == DeleteOrphanProfiles {
+		gamaDeleteProfileResponse, err := GetCSCartClient().DeleteProfiles(GamaProfileRequest{Email: email, Profiles: orphans})
+		if err != nil {
+			return err
+		}
+		deleted = gamaDeleteProfileResponse.Profiles
+	}
+
+	for _, orphan := range orphans {
+		if mode == DeleteOrphanProfiles && !deleted[orphan.ProfileId] {
+			return errors.New("GAMA didn't delete the profile " + strconv.Itoa(orphan.ProfileId) + " of the address " + strconv.Itoa(orphan.ProfileName))
+		}
+		var addressProfile = AddressProfile{
+			MagentoId: orphan.ProfileName,
+			GamaId:    orphan.ProfileId,
+			Email:     email + fmt.Sprint(orphan.ProfileName),
+			Result:    mode == FlagOrphanProfiles, // a deleted profile is created again if the address comes back
+			Orphaned:  true,
+		}
+		err = SaveAddressToDb(addressProfile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"strings"
+
+	magentohash "migration-m2-gama/magentohash"
+)
+
+// PasswordVerification is the answer to GAMA when a migrated user logs in for the first time, with a
+// valid password Rehash asks GAMA to save the password with its own hash: the magento hash is not of the
+// current version or GAMA has none for the user (the hash was imported and not sent yet)
+type PasswordVerification struct {
+	Email  string `json:"email"`
+	Valid  bool   `json:"valid"`
+	Rehash bool   `json:"rehash"`
+}
+
+// VerifyMagentoPassword checks the password against the magento hash migrated for the email, it returns
+// ErrNotFound for the users without hash
+func VerifyMagentoPassword(email string, password string) (PasswordVerification, error) {
+	email = strings.TrimSpace(email)
+	verification := PasswordVerification{Email: email}
+
+	userHash, err := GetHashFromDb(email)
+	if err != nil {
+		return verification, err
+	}
+	if userHash.Hash == "" {
+		return verification, ErrNotFound
+	}
+	hash, err := magentohash.Decode(userHash.Hash)
+	if err != nil {
+		return verification, err
+	}
+
+	verification.Valid = password != "" && hash.Verify(password)
+	verification.Rehash = verification.Valid && (!hash.IsCurrent() || userHash.Pending)
+	return verification, nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"fmt"
+)
+
+const hiddenPassword = "[hidden]"
+
+// ImportPreview is what GamaImportUser would send to GAMA for one user, returned by dry runs
+type ImportPreview struct {
+	Mode     string           `json:"mode"` // insert or update
+	User     GamaUser         `json:"user"`
+	Profiles []ProfilePreview `json:"profiles"`
+	Diff     *UserDiff        `json:"diff,omitempty"` // only on updates, the user and profiles without changes are not sent
+	// UsergroupId is the usergroup of the magento group, 0 when it has none or it would be created
+	UsergroupId int `json:"usergroup_id,omitempty"`
+}
+
+type ProfilePreview struct {
+	Mode    string  `json:"mode"` // insert, update, or delete and flag for the profiles of deleted addresses
+	Profile Profile `json:"profile"`
+}
+
+// GamaPreviewUser does the reads of GamaImportUser and returns the payloads it would send, nothing is
+// written on GAMA nor on the migration store
+func GamaPreviewUser(magentoUser MagentoUser, force bool) (responseCode int, preview ImportPreview, err error) {
+	gamaResult, err := GetCSCartClient().GetUserByEmail(magentoUser.Email)
+	if err != nil {
+		return 3, preview, err
+	}
+
+	preview.Mode, err = getImportMode(gamaResult, force)
+	if err != nil {
+		return 3, preview, err
+	}
+
+	// the usergroups are not created by dry runs
+	usergroupId, err := getGamaUsergroupId(magentoUser.GroupId, false)
+	if err != nil {
+		return 3, preview, err
+	}
+
+	if preview.Mode == "update" {
+		return previewUserUpdate(magentoUser, gamaResult.Users[0].Id, usergroupId)
+	}
+	preview.UsergroupId = usergroupId
+
+	preview.User, _, err = translateUserInformation(magentoUser, preview.Mode)
+	if err != nil {
+		return 3, preview, err
+	}
+	hidePassword(&preview.User)
+
+	if magentoUser.Addresses != nil {
+		addressesToCreate, addressesToUpdate, err := translateProfileInformation(magentoUser.Addresses, magentoUser)
+		if err != nil {
+			return 3, preview, err
+		}
+		for _, profile := range addressesToCreate {
+			mode := "insert"
+			// a new user gets its main profile on creation, the main address updates that profile
+			if profile.ProfileName == getMainAddressId(magentoUser) {
+				mode = "update"
+			}
+			preview.Profiles = append(preview.Profiles, ProfilePreview{Mode: mode, Profile: profile})
+		}
+		for _, profile := range addressesToUpdate {
+			preview.Profiles = append(preview.Profiles, ProfilePreview{Mode: "update", Profile: profile})
+		}
+	}
+
+	return 1, preview, nil
+}
+
+func previewUserUpdate(magentoUser MagentoUser, gamaUserId string, usergroupId int) (responseCode int, preview ImportPreview, err error) {
+	update, err := buildUserUpdate(magentoUser, gamaUserId, usergroupId)
+	if err != nil {
+		return 3, preview, err
+	}
+
+	preview.Mode = "update"
+	preview.UsergroupId = usergroupId
+	preview.User = update.user
+	preview.User.Id = gamaUserId
+	hidePassword(&preview.User)
+	preview.Diff = &update.diff
+	for _, profile := range update.profilesToCreate {
+		preview.Profiles = append(preview.Profiles, ProfilePreview{Mode: "insert", Profile: profile})
+	}
+	for _, profile := range update.profilesToUpdate {
+		preview.Profiles = append(preview.Profiles, ProfilePreview{Mode: "update", Profile: profile})
+	}
+	if len(update.orphanProfiles) > 0 {
+		mode, err := getOrphanProfilesMode()
+		if err != nil {
+			return 3, preview, err
+		}
+		for _, profile := range update.orphanProfiles {
+			preview.Profiles = append(preview.Profiles, ProfilePreview{Mode: mode, Profile: profile})
+		}
+	}
+
+	if update.diff.IsEmpty() {
+		return 4, preview, nil
+	}
+	return 2, preview, nil
+}
+
+// hidePassword masks the password of the previews, they are returned by the api
+func hidePassword(gamaUser *GamaUser) {
+	if gamaUser.Hash != "" {
+		gamaUser.Hash = hiddenPassword
+	}
+}
+
+// PreviewMagentoUser is the dry run version of ImportMagentoUser, the result is not saved
+func PreviewMagentoUser(magentoUser MagentoUser, force bool) BodyResult {
+	bodyResult := BodyResult{
+		Email:  magentoUser.Email,
+		Reason: "dry run, nothing was sent to GAMA",
+	}
+
+	responseCode, preview, err := GamaPreviewUser(magentoUser, force)
+	bodyResult.ResponseCode = responseCode
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+		bodyResult.ErrorCode = getErrorCode(err)
+		return bodyResult
+	}
+	bodyResult.Preview = &preview
+	if warning := usergroupWarning(magentoUser.GroupId); warning != "" {
+		bodyResult.Reason += ", " + warning
+	}
+
+	fmt.Println("Dry run of: " + magentoUser.Email + " | mode: " + preview.Mode)
+
+	return bodyResult
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProductsCheckpointId    = "products"
+	getProductsPageEndpoint = "products?searchCriteria[sortOrders][0][field]=entity_id&searchCriteria[sortOrders][0][direction]=ASC"
+	getProductEndpoint      = "products?searchCriteria[filter_groups][0][filters][0][field]=sku&searchCriteria[filter_groups][0][filters][0][value]="
+	productsEndpoint        = "api/products"
+)
+
+// ProductAttribute is a custom attribute of a magento product, the value is a string or a list of strings
+type ProductAttribute struct {
+	Code  string      `json:"attribute_code"`
+	Value interface{} `json:"value"`
+}
+
+type MagentoCategoryLink struct {
+	Position   int    `json:"position"`
+	CategoryId string `json:"category_id"`
+}
+
+type MagentoStockItem struct {
+	Qty       float64 `json:"qty"`
+	IsInStock bool    `json:"is_in_stock"`
+}
+
+type MagentoProductExtension struct {
+	CategoryLinks []MagentoCategoryLink `json:"category_links,omitempty"`
+	StockItem     *MagentoStockItem     `json:"stock_item,omitempty"`
+}
+
+type MagentoProduct struct {
+	Id                  int                     `json:"id"`
+	Sku                 string                  `json:"sku"`
+	Name                string                  `json:"name"`
+	AttributeSetId      int                     `json:"attribute_set_id"`
+	Price               float64                 `json:"price"`
+	Status              int                     `json:"status"` // 1 enabled, 2 disabled
+	Visibility          int                     `json:"visibility"`
+	TypeId              string                  `json:"type_id"`
+	Weight              float64                 `json:"weight"`
+	UpdatedAt           string                  `json:"updated_at,omitempty"`
+	ExtensionAttributes MagentoProductExtension `json:"extension_attributes"`
+	CustomAttributes    []ProductAttribute      `json:"custom_attributes"`
+	MediaGalleryEntries []MagentoMediaEntry     `json:"media_gallery_entries,omitempty"`
+}
+
+type MagentoProductResults struct {
+	Items []MagentoProduct `json:"items"`
+	Total int              `json:"total_count"`
+}
+
+type GamaProduct struct {
+	Product          string  `json:"product"`
+	ProductCode      string  `json:"product_code"`
+	Price            float64 `json:"price"`
+	Status           string  `json:"status"`
+	Weight           float64 `json:"weight"`
+	Amount           int     `json:"amount"`
+	FullDescription  string  `json:"full_description"`
+	ShortDescription string  `json:"short_description"`
+	MainCategory     int     `json:"main_category"`
+	CategoryIds      []int   `json:"category_ids"`
+	// ProductFeatures are the values of the features by feature_id
+	ProductFeatures map[string]GamaProductFeature `json:"product_features,omitempty"`
+}
+
+type GamaProductResponse struct {
+	ProductId int `json:"product_id"`
+}
+
+// ProductMapping relates the sku of a magento product with the GAMA product_id. The configurable
+// products have the id of the parent of the variations group and their children the sku of the
+// configurable product.
+type ProductMapping struct {
+	Sku       string `json:"sku"`
+	MagentoId int    `json:"magento_id"`
+	GamaId    int    `json:"gama_id,omitempty"`
+	GroupId   int    `json:"group_id,omitempty"`
+	ParentSku string `json:"parent_sku,omitempty"`
+	Result    bool   `json:"response_code"`
+	Images    bool   `json:"images"` // the gallery was uploaded
+}
+
+// GetMagentoProductsPage returns the first page of the magento products with an entity_id greater than
+// afterEntityId sorted by entity_id, total_count is the number of products left
+func GetMagentoProductsPage(afterEntityId int, pageSize int) (MagentoProductResults, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	request := getProductsPageEndpoint + "&searchCriteria[filter_groups][0][filters][0][field]=entity_id&searchCriteria[filter_groups][0][filters][0][condition_type]=gt&searchCriteria[filter_groups][0][filters][0][value]=" + strconv.Itoa(afterEntityId) + "&searchCriteria[pageSize]=" + strconv.Itoa(pageSize) + "&searchCriteria[currentPage]=1"
+
+	return GetMagentoClient().SearchProducts(request)
+}
+
+func GetMagentoProduct(sku string) (MagentoProductResults, error) {
+	return GetMagentoClient().SearchProducts(getProductEndpoint + url.QueryEscape(sku))
+}
+
+// SyncProduct migrates the magento product with the sku, the products already migrated are only
+// sent again when force is true
+func SyncProduct(sku string, force bool) ([]BodyResult, error) {
+	var bodyResults []BodyResult
+
+	fmt.Println("Migrating product: " + sku + " | force: " + strconv.FormatBool(force))
+	magentoResult, err := GetMagentoProduct(sku)
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoProduct function: ", err.Error())
+		return bodyResults, err
+	}
+	if magentoResult.Total <= 0 {
+		bodyResult := BodyResult{
+			Entity:       "product",
+			Reference:    sku,
+			ResponseCode: 3,
+			Reason:       "Not product found on magento databse",
+		}
+		return append(bodyResults, bodyResult), nil
+	}
+
+	for _, magentoProduct := range magentoResult.Items {
+		bodyResults = append(bodyResults, ImportMagentoProduct(magentoProduct, force))
+	}
+
+	return bodyResults, nil
+}
+
+// SyncAllProducts walks the whole magento catalog page by page like SyncAllUsers, the cursor is
+// saved on the products checkpoint
+func SyncAllProducts(ctx context.Context, force bool, pageSize int, restart bool) ([]BodyResult, Checkpoint, error) {
+	var magentoProducts []MagentoProduct
+
+	return syncAllPages(ctx, pagedMigration{
+		checkpointId: ProductsCheckpointId,
+		entity:       "products",
+		readPage: func(afterEntityId int, pageSize int) ([]int, int, error) {
+			magentoResult, err := GetMagentoProductsPage(afterEntityId, pageSize)
+			if err != nil {
+				fmt.Println("Error returned by GetMagentoProductsPage function: ", err.Error())
+				return nil, 0, err
+			}
+			magentoProducts = magentoResult.Items
+			entityIds := make([]int, len(magentoProducts))
+			for index, magentoProduct := range magentoProducts {
+				entityIds[index] = magentoProduct.Id
+			}
+			return entityIds, magentoResult.Total, nil
+		},
+		importItem: func(index int, checkpoint *Checkpoint) (BodyResult, error) {
+			bodyResult := ImportMagentoProduct(magentoProducts[index], force)
+			countResult(checkpoint, bodyResult)
+			return bodyResult, nil
+		},
+	}, pageSize, restart)
+}
+
+// ImportMagentoProduct sends one magento product and its images to GAMA and saves the sku mapping, the
+// configurable products are sent as a group of variations. When only the images failed on the last
+// run, the next run without force sends only the images.
+func ImportMagentoProduct(magentoProduct MagentoProduct, force bool) BodyResult {
+	bodyResult := BodyResult{
+		Entity:    "product",
+		Reference: magentoProduct.Sku,
+	}
+
+	mapping, err := GetProductFromDb(magentoProduct.Sku)
+	if err == nil && mapping.ParentSku != "" {
+		bodyResult.ResponseCode = 1
+		bodyResult.Reason = "variation of " + mapping.ParentSku + ", it is migrated with its configurable product"
+		return bodyResult
+	}
+	migrated := err == nil && mapping.Result
+	if migrated && mapping.Images && !force {
+		bodyResult.ResponseCode = 1
+		bodyResult.Reason = "product already migrated with id " + strconv.Itoa(mapping.GamaId)
+		return bodyResult
+	}
+
+	mapping.Sku = magentoProduct.Sku
+	mapping.MagentoId = magentoProduct.Id
+	bodyResult.ResponseCode = 1
+	if mapping.GamaId != 0 {
+		bodyResult.ResponseCode = 2
+	}
+	if !migrated || force {
+		if magentoProduct.TypeId == configurableProductType {
+			mapping, err = importConfigurableProduct(magentoProduct, mapping)
+		} else {
+			var gamaProduct GamaProduct
+			gamaProduct, err = translateProductInformation(magentoProduct)
+			if err != nil {
+				bodyResult.ResponseCode = 3
+				bodyResult.Reason = err.Error()
+				return bodyResult
+			}
+			mapping.GamaId, err = sendGamaProduct(mapping.GamaId, gamaProduct)
+		}
+		mapping.Result = err == nil && mapping.GamaId != 0
+		if err != nil {
+			bodyResult.ResponseCode = 3
+			bodyResult.Reason = err.Error()
+		}
+	}
+
+	if mapping.Result {
+		err = sendImages(magentoProduct, mapping)
+		mapping.Images = err == nil
+		if err != nil {
+			bodyResult.ResponseCode = 3
+			bodyResult.Reason = "product migrated with id " + strconv.Itoa(mapping.GamaId) + " but its images failed: " + err.Error()
+		}
+	}
+
+	err = SaveProductToDb(mapping)
+	if err != nil {
+		fmt.Println("Error returned by SaveProductToDb function: ", err.Error())
+	}
+
+	return bodyResult
+}
+
+// sendImages uploads the gallery of the product, the variations of a configurable product upload
+// their own gallery or the one of the configurable product when they have none
+func sendImages(magentoProduct MagentoProduct, mapping ProductMapping) error {
+	if magentoProduct.TypeId != configurableProductType {
+		return sendProductImages(productImages{sku: magentoProduct.Sku, gamaId: mapping.GamaId, entries: magentoProduct.MediaGalleryEntries})
+	}
+
+	children, err := GetMagentoConfigurableChildren(magentoProduct.Sku)
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoConfigurableChildren function: ", err.Error())
+		return err
+	}
+	for _, child := range children {
+		childMapping, err := GetProductFromDb(child.Sku)
+		if err != nil || !childMapping.Result {
+			return errors.New("variation " + child.Sku + " was not migrated")
+		}
+		entries := child.MediaGalleryEntries
+		if len(getGalleryImages(entries)) == 0 {
+			entries = magentoProduct.MediaGalleryEntries
+		}
+		err = sendProductImages(productImages{sku: child.Sku, gamaId: childMapping.GamaId, entries: entries})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendGamaProduct updates the GAMA product with the id or creates it when the id is 0, it returns the id
+func sendGamaProduct(gamaId int, gamaProduct GamaProduct) (int, error) {
+	if gamaId != 0 {
+		return gamaId, GetCSCartClient().UpdateProduct(gamaId, gamaProduct)
+	}
+
+	gamaProductResponse, err := GetCSCartClient().CreateProduct(gamaProduct)
+	return gamaProductResponse.ProductId, err
+}
+
+func translateProductInformation(magentoProduct MagentoProduct) (GamaProduct, error) {
+	gamaProduct := GamaProduct{
+		Product:          magentoProduct.Name,
+		ProductCode:      magentoProduct.Sku,
+		Price:            magentoProduct.Price,
+		Status:           "A",
+		Weight:           magentoProduct.Weight,
+		FullDescription:  getProductAttribute(magentoProduct, "description"),
+		ShortDescription: getProductAttribute(magentoProduct, "short_description"),
+	}
+	if magentoProduct.Status != 1 {
+		gamaProduct.Status = "D"
+	}
+	gamaProduct.Amount = getProductStock(magentoProduct)
+
+	categoryIds, err := getProductCategories(magentoProduct)
+	if err != nil {
+		return gamaProduct, err
+	}
+	gamaProduct.MainCategory = categoryIds[0]
+	gamaProduct.CategoryIds = categoryIds
+
+	gamaProduct.ProductFeatures, err = getProductFeatures(magentoProduct)
+	if err != nil {
+		return gamaProduct, err
+	}
+
+	return gamaProduct, nil
+}
+
+// getProductCategories returns the migrated categories of the product, the one with the lowest
+// position first (the main category). Products without migrated categories are created on the
+// default category of the stage.
+func getProductCategories(magentoProduct MagentoProduct) ([]int, error) {
+	links := append([]MagentoCategoryLink(nil), magentoProduct.ExtensionAttributes.CategoryLinks...)
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Position < links[j].Position })
+
+	var categoryIds []int
+	for _, link := range links {
+		if gamaId, ok := getGamaCategoryId(link.CategoryId); ok {
+			categoryIds = append(categoryIds, gamaId)
+		}
+	}
+	if len(categoryIds) > 0 {
+		return categoryIds, nil
+	}
+
+	defaultCategory, err := strconv.Atoi(os.Getenv("gamaDefaultCategory"))
+	if err != nil || defaultCategory <= 0 {
+		return nil, errors.New("there is no GAMA category for product " + magentoProduct.Sku + ", migrate its categories or define the default category of the stage")
+	}
+	return []int{defaultCategory}, nil
+}
+
+// getProductStock returns the quantity of the products in stock, 0 when they are out of stock
+func getProductStock(magentoProduct MagentoProduct) int {
+	stock := magentoProduct.ExtensionAttributes.StockItem
+	if stock == nil || !stock.IsInStock {
+		return 0
+	}
+	return int(stock.Qty)
+}
+
+// getProductAttribute returns the custom attribute with the code, the lists are joined by comma
+func getProductAttribute(magentoProduct MagentoProduct, code string) string {
+	for _, attribute := range magentoProduct.CustomAttributes {
+		if attribute.Code != code {
+			continue
+		}
+		switch value := attribute.Value.(type) {
+		case string:
+			return value
+		case []interface{}:
+			values := make([]string, len(value))
+			for index, item := range value {
+				values[index] = fmt.Sprint(item)
+			}
+			return strings.Join(values, ",")
+		case nil:
+			return ""
+		default:
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
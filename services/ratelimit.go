@@ -0,0 +1,93 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGamaRateLimit        = 5 // requests per second
+	defaultGamaMaxConcurrent    = 2
+	defaultMagentoRateLimit     = 10
+	defaultMagentoMaxConcurrent = 4
+)
+
+// rateLimiter is a token bucket shared by the requests of a client: rate tokens are added every second up
+// to burst and every request takes one, waiting for it when the bucket is empty. slots limits the requests
+// in flight. A nil limiter, or a rate of 0, lets every request through.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	slots  chan struct{}
+}
+
+func newRateLimiter(rate float64, burst int, maxConcurrent int) *rateLimiter {
+	limiter := &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	if limiter.burst < 1 {
+		limiter.burst, limiter.tokens = 1, 1
+	}
+	if maxConcurrent > 0 {
+		limiter.slots = make(chan struct{}, maxConcurrent)
+	}
+	return limiter
+}
+
+// getRateLimiter creates the limiter of the env variables with the prefix (gama or magento): <prefix>RateLimit
+// requests per second (0 is unlimited), <prefix>RateBurst requests sent at once after being idle (the rate by
+// default) and <prefix>MaxConcurrent requests in flight (0 is unlimited)
+func getRateLimiter(prefix string, defaultRate float64, defaultMaxConcurrent int) *rateLimiter {
+	rate := defaultRate
+	if value, err := strconv.ParseFloat(os.Getenv(prefix+"RateLimit"), 64); err == nil && value >= 0 {
+		rate = value
+	}
+	burst := int(rate)
+	if value, err := strconv.Atoi(os.Getenv(prefix + "RateBurst")); err == nil && value > 0 {
+		burst = value
+	}
+	maxConcurrent := defaultMaxConcurrent
+	if value, err := strconv.Atoi(os.Getenv(prefix + "MaxConcurrent")); err == nil && value >= 0 {
+		maxConcurrent = value
+	}
+	return newRateLimiter(rate, burst, maxConcurrent)
+}
+
+// acquire waits for a token and a free slot, call the returned function once the body of the response was closed
+func (l *rateLimiter) acquire() func() {
+	if l == nil {
+		return func() {}
+	}
+	time.Sleep(l.reserve())
+	if l.slots == nil {
+		return func() {}
+	}
+	l.slots <- struct{}{}
+	return func() { <-l.slots }
+}
+
+// reserve takes a token and returns how long to wait for it, the tokens go negative so the waiting
+// requests are sent in order at the rate
+func (l *rateLimiter) reserve() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHttpRetries    = 3
+	defaultHttpRetryDelay = 500 * time.Millisecond
+	defaultHttpMaxDelay   = 30 * time.Second
+)
+
+// errRequestApplied is returned instead of retrying a request that the server already applied
+var errRequestApplied = errors.New("the request was applied by a previous attempt")
+
+// retryPolicy is how the requests to magento and GAMA are retried, read from the stage env on every
+// request: httpRetries (retries after the first attempt), httpRetryDelay (delay before the first
+// retry, doubled on every retry) and httpRetryMaxDelay (the longest wait, also for Retry-After)
+type retryPolicy struct {
+	retries  int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+func getRetryPolicy() retryPolicy {
+	policy := retryPolicy{retries: defaultHttpRetries, delay: defaultHttpRetryDelay, maxDelay: defaultHttpMaxDelay}
+
+	if retries, err := strconv.Atoi(os.Getenv("httpRetries")); err == nil && retries >= 0 {
+		policy.retries = retries
+	}
+	if delay, err := time.ParseDuration(os.Getenv("httpRetryDelay")); err == nil && delay >= 0 {
+		policy.delay = delay
+	}
+	if maxDelay, err := time.ParseDuration(os.Getenv("httpRetryMaxDelay")); err == nil && maxDelay >= 0 {
+		policy.maxDelay = maxDelay
+	}
+	return policy
+}
+
+// backoff returns the wait before the retry: the Retry-After of the response when it has one, if not
+// the delay doubled on every attempt with a random jitter of up to a half so the lambdas don't retry
+// at the same time
+func (p retryPolicy) backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if wait, ok := parseRetryAfter(response.Header.Get("Retry-After")); ok {
+			if wait > p.maxDelay {
+				return p.maxDelay
+			}
+			return wait
+		}
+	}
+
+	wait := p.delay
+	for i := 0; i < attempt && wait < p.maxDelay; i++ {
+		wait *= 2
+	}
+	if wait > p.maxDelay {
+		wait = p.maxDelay
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// parseRetryAfter reads the seconds or the http date of a Retry-After header
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// the context of the job or delta that sends the requests to magento and GAMA, set by ProcessJob and
+// SyncUpdatedUsers so the retries don't wait past the lambda deadline
+var (
+	requestsMu  sync.Mutex
+	requestsCtx = context.Background()
+)
+
+// withRequestsContext makes ctx the context of the requests until the returned function is called
+func withRequestsContext(ctx context.Context) func() {
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	previous := requestsCtx
+	requestsCtx = ctx
+	return func() {
+		requestsMu.Lock()
+		defer requestsMu.Unlock()
+		requestsCtx = previous
+	}
+}
+
+func requestsContext() context.Context {
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	return requestsCtx
+}
+
+// doWithRetries sends the request and retries it on network errors, 429 and 5xx responses. The
+// requests that are not idempotent (POST) are only retried on 429, the server didn't process them,
+// unless applied is given: it is called before retrying them and, when it finds the changes of the
+// request already saved by the failed attempt, errRequestApplied is returned instead of sending it again.
+// Every attempt waits its turn on the limiter of the client and keeps its slot until the caller closes
+// the body of the response. The failed attempt is returned without retrying it when the wait would
+// leave less than the timeout margin before the deadline of ctx.
+func doWithRetries(ctx context.Context, client *http.Client, limiter *rateLimiter, request *http.Request, applied func() (bool, error)) (*http.Response, error) {
+	policy := getRetryPolicy()
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request.Body = body
+		}
+
+		release := limiter.acquire()
+		response, err := client.Do(request)
+		if err != nil {
+			release()
+		} else {
+			response.Body = &releasingBody{ReadCloser: response.Body, release: release}
+		}
+		if attempt >= policy.retries || !isRetryable(request.Method, response, err, applied != nil) {
+			return response, err
+		}
+
+		wait := policy.backoff(attempt, response)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline)-wait < timeoutMargin {
+			fmt.Println("Not retrying " + request.Method + " " + request.URL.String() + ", the lambda is running out of time")
+			return response, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = response.Status
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+		fmt.Println("Retrying " + request.Method + " " + request.URL.String() + " in " + wait.String() + " after: " + reason)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if applied != nil && (err != nil || response.StatusCode != http.StatusTooManyRequests) {
+			done, err := applied()
+			if err != nil {
+				return nil, errors.New("error checking if " + request.Method + " " + request.URL.String() + " was applied before retrying it: " + err.Error())
+			}
+			if done {
+				return nil, errRequestApplied
+			}
+		}
+	}
+}
+
+// releasingBody frees the slot of the limiter once the body of the response was read and closed, so the
+// slots limit the requests in flight until their body is received
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// isRetryable tells if the attempt failed with an error that may not happen again
+func isRetryable(method string, response *http.Response, err error, checked bool) bool {
+	idempotent := method != http.MethodPost && method != http.MethodPatch
+	if err != nil {
+		return idempotent || checked
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return response.StatusCode >= http.StatusInternalServerError && (idempotent || checked)
+}
@@ -1,38 +1,136 @@
 package services
 
-func GetMapStates() map[int]int {
-	return map[int]int{
-		764:1,
-		765:2,
-		766:3,
-		767:4,
-		771:5,
-		772:6,
-		768:7,
-		769:8,
-		773:9,
-		770:10,
-		775:11,
-		776:12,
-		777:13,
-		778:14,
-		774:15,
-		779:16,
-		780:17,
-		781:18,
-		782:19,
-		783:20,
-		784:21,
-		785:22,
-		786:23,
-		787:24,
-		788:25,
-		789:26,
-		790:27,
-		791:28,
-		792:29,
-		793:30,
-		794:31,
-		795:32,
-	}
-}
\ No newline at end of file
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	getStatesEndpoint  = "api/states?items_per_page=1000&country_code="
+	defaultRegionsFile = "regions.json"
+)
+
+// GamaState is a state of a country on GAMA, code is the one of the magento region_code
+type GamaState struct {
+	StateId     int    `json:"state_id,string"`
+	CountryCode string `json:"country_code"`
+	Code        string `json:"code"`
+	State       string `json:"state"`
+}
+
+type GamaStatesResult struct {
+	States []GamaState `json:"states"`
+}
+
+// the regions file and the GAMA states are read once per process, the file again when regionsFile changes
+// and the states when the client changes
+var (
+	regionsMu    sync.Mutex
+	mapRegions   map[string]map[int]int
+	mapRegionsOf string
+	gamaStates   map[string][]GamaState
+	gamaStatesOf CSCartClient
+)
+
+// GetMapRegions reads the GAMA state_id of the magento region_id by country from the json file of
+// regionsFile, like {"MX": {"764": 1}}. There is no explicit mapping when regionsFile is empty.
+func GetMapRegions() (map[string]map[int]int, error) {
+	file := os.Getenv("regionsFile")
+	if file == "" {
+		return make(map[string]map[int]int), nil
+	}
+
+	regionsMu.Lock()
+	defer regionsMu.Unlock()
+	if mapRegions != nil && mapRegionsOf == file {
+		return mapRegions, nil
+	}
+
+	regions := make(map[string]map[int]int)
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return regions, errors.New("error reading the regions file " + file + ": " + err.Error())
+	}
+	err = json.Unmarshal(content, &regions)
+	if err != nil {
+		return regions, errors.New("error reading the regions file " + file + ": " + err.Error())
+	}
+	mapRegions = regions
+	mapRegionsOf = file
+
+	return regions, nil
+}
+
+// getGamaStates returns the GAMA states of the country, read from GAMA the first time
+func getGamaStates(countryId string) ([]GamaState, error) {
+	regionsMu.Lock()
+	defer regionsMu.Unlock()
+
+	client := GetCSCartClient()
+	if gamaStatesOf != client {
+		gamaStates = make(map[string][]GamaState)
+		gamaStatesOf = client
+	}
+	if states, ok := gamaStates[countryId]; ok {
+		return states, nil
+	}
+	states, err := client.GetStates(countryId)
+	if err != nil {
+		return nil, err
+	}
+	gamaStates[countryId] = states
+	return states, nil
+}
+
+// regionMapper resolves the GAMA state of the magento regions, the states of a country are read from
+// GAMA only for the regions without explicit mapping
+type regionMapper struct {
+	regions map[string]map[int]int
+}
+
+func newRegionMapper() (*regionMapper, error) {
+	regions, err := GetMapRegions()
+	if err != nil {
+		return nil, err
+	}
+	return &regionMapper{regions: regions}, nil
+}
+
+// stateId returns the GAMA state_id of the region: the one of the regions file or the GAMA state of the
+// country with the region code (or name). Addresses without region (countries without states) have none.
+func (m *regionMapper) stateId(countryId string, regionId int, regionCode string, region string) (int, error) {
+	if regionId == 0 && regionCode == "" && region == "" {
+		return 0, nil
+	}
+	if stateId, ok := m.regions[countryId][regionId]; ok {
+		return stateId, nil
+	}
+
+	states, err := getGamaStates(countryId)
+	if err != nil {
+		return 0, errors.New("error reading the GAMA states of " + countryId + ": " + err.Error())
+	}
+	for _, state := range states {
+		if regionCode != "" && strings.EqualFold(state.Code, regionCode) {
+			return state.StateId, nil
+		}
+	}
+	for _, state := range states {
+		if region != "" && strings.EqualFold(state.State, region) {
+			return state.StateId, nil
+		}
+	}
+
+	if regionCode == "" {
+		regionCode = region
+	}
+	if regionCode == "" {
+		return 0, errors.New("region " + strconv.Itoa(regionId) + " of " + countryId + " has no GAMA state")
+	}
+	return 0, errors.New("region " + regionCode + " (" + strconv.Itoa(regionId) + ") of " + countryId + " has no GAMA state")
+}
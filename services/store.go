@@ -0,0 +1,390 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MigrationStore keeps the state of the migration: results, address, hash, order, product, category, feature, image and usergroup records, checkpoints and jobs
+type MigrationStore interface {
+	SaveResult(bodyResult BodyResult) error
+	GetMigratedUser(email string) (BodyResult, error)
+	SaveAddress(addressProfile AddressProfile) error
+	GetAddress(key string) (AddressProfile, error)
+	SaveHash(userHash UserHash) error
+	SaveHashes(userHashes []UserHash) error
+	GetHash(email string) (UserHash, error)
+	GetHashes(emails []string) (map[string]UserHash, error)
+	SaveOrder(orderMapping OrderMapping) error
+	GetOrder(key string) (OrderMapping, error)
+	SaveProduct(productMapping ProductMapping) error
+	GetProduct(sku string) (ProductMapping, error)
+	SaveCategory(categoryMapping CategoryMapping) error
+	GetCategory(id string) (CategoryMapping, error)
+	SaveFeature(featureMapping FeatureMapping) error
+	GetFeature(attributeCode string) (FeatureMapping, error)
+	SaveImage(imageMapping ImageMapping) error
+	GetImage(id string) (ImageMapping, error)
+	SaveUsergroup(usergroupMapping UsergroupMapping) error
+	GetUsergroup(groupId string) (UsergroupMapping, error)
+	SaveCheckpoint(checkpoint Checkpoint) error
+	GetCheckpoint(id string) (Checkpoint, error)
+	SaveJob(job Job) error
+	GetJob(id string) (Job, error)
+}
+
+// storeTable names a table of the store, envName is the env var with the dynamodb table name
+type storeTable struct {
+	envName string
+	keyName string
+}
+
+var (
+	usersTable       = storeTable{envName: "MIGRATED_USERS_TABLE", keyName: "email"}
+	addressesTable   = storeTable{envName: "MIGRATED_ADDRESSES_TABLE", keyName: "email"}
+	hashTable        = storeTable{envName: "MIGRATED_HASH_TABLE", keyName: "email"}
+	ordersTable      = storeTable{envName: "MIGRATED_ORDERS_TABLE", keyName: "email"}
+	productsTable    = storeTable{envName: "MIGRATED_PRODUCTS_TABLE", keyName: "sku"}
+	categoriesTable  = storeTable{envName: "MIGRATED_CATEGORIES_TABLE", keyName: "id"}
+	featuresTable    = storeTable{envName: "MIGRATED_FEATURES_TABLE", keyName: "id"}
+	imagesTable      = storeTable{envName: "MIGRATED_IMAGES_TABLE", keyName: "id"}
+	usergroupsTable  = storeTable{envName: "MIGRATED_USERGROUPS_TABLE", keyName: "id"}
+	checkpointsTable = storeTable{envName: "MIGRATED_CHECKPOINTS_TABLE", keyName: "id"}
+	jobsTable        = storeTable{envName: "MIGRATION_JOBS_TABLE", keyName: "id"}
+)
+
+// storeBackend saves items by table and key, getItem returns ErrNotFound for missing keys and getItems
+// unmarshals every item found on the value that item returns for its key, the missing keys are skipped
+type storeBackend interface {
+	putItem(table storeTable, key string, item interface{}) error
+	putItems(table storeTable, items []storeItem) error
+	getItem(table storeTable, key string, item interface{}) error
+	getItems(table storeTable, keys []string, item func(key string) interface{}) error
+}
+
+// storeItem is an item of the batch writes
+type storeItem struct {
+	key  string
+	item interface{}
+}
+
+type migrationStore struct {
+	backend storeBackend
+}
+
+var store MigrationStore
+
+// GetStore returns the store configured with SetStore, by default the dynamodb one
+func GetStore() MigrationStore {
+	if store == nil {
+		store = NewDynamoStore()
+	}
+	return store
+}
+
+func SetStore(migrationStore MigrationStore) {
+	store = migrationStore
+}
+
+// NewStoreFromEnv creates the store named by MIGRATION_STORE: dynamodb (default), memory or file,
+// the file store uses the path of MIGRATION_STORE_PATH
+func NewStoreFromEnv() (MigrationStore, error) {
+	switch os.Getenv("MIGRATION_STORE") {
+	case "", "dynamodb":
+		return NewDynamoStore(), nil
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		path := os.Getenv("MIGRATION_STORE_PATH")
+		if path == "" {
+			path = "migration-store.json"
+		}
+		return NewFileStore(path)
+	default:
+		return nil, errors.New("Migration store " + os.Getenv("MIGRATION_STORE") + " is not defined")
+	}
+}
+
+func (s *migrationStore) SaveResult(bodyResult BodyResult) error {
+	return s.backend.putItem(usersTable, bodyResult.Email, bodyResult)
+}
+
+func (s *migrationStore) GetMigratedUser(email string) (BodyResult, error) {
+	item := BodyResult{}
+	err := s.backend.getItem(usersTable, email, &item)
+	if err == ErrNotFound {
+		fmt.Println("element not found")
+		return item, nil
+	}
+	return item, err
+}
+
+func (s *migrationStore) SaveAddress(addressProfile AddressProfile) error {
+	return s.backend.putItem(addressesTable, addressProfile.Email, addressProfile)
+}
+
+func (s *migrationStore) GetAddress(key string) (AddressProfile, error) {
+	item := AddressProfile{}
+	err := s.backend.getItem(addressesTable, key, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveHash(userHash UserHash) error {
+	return s.backend.putItem(hashTable, userHash.Email, userHash)
+}
+
+func (s *migrationStore) SaveHashes(userHashes []UserHash) error {
+	items := make([]storeItem, len(userHashes))
+	for index, userHash := range userHashes {
+		items[index] = storeItem{key: userHash.Email, item: userHash}
+	}
+	return s.backend.putItems(hashTable, items)
+}
+
+func (s *migrationStore) GetHash(email string) (UserHash, error) {
+	item := UserHash{}
+	err := s.backend.getItem(hashTable, email, &item)
+	return item, err
+}
+
+func (s *migrationStore) GetHashes(emails []string) (map[string]UserHash, error) {
+	items := make(map[string]*UserHash)
+	err := s.backend.getItems(hashTable, emails, func(key string) interface{} {
+		items[key] = &UserHash{}
+		return items[key]
+	})
+
+	userHashes := make(map[string]UserHash)
+	for email, item := range items {
+		userHashes[email] = *item
+	}
+	return userHashes, err
+}
+
+func (s *migrationStore) SaveOrder(orderMapping OrderMapping) error {
+	return s.backend.putItem(ordersTable, orderMapping.Email, orderMapping)
+}
+
+func (s *migrationStore) GetOrder(key string) (OrderMapping, error) {
+	item := OrderMapping{}
+	err := s.backend.getItem(ordersTable, key, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveProduct(productMapping ProductMapping) error {
+	return s.backend.putItem(productsTable, productMapping.Sku, productMapping)
+}
+
+func (s *migrationStore) GetProduct(sku string) (ProductMapping, error) {
+	item := ProductMapping{}
+	err := s.backend.getItem(productsTable, sku, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveCategory(categoryMapping CategoryMapping) error {
+	return s.backend.putItem(categoriesTable, categoryMapping.Id, categoryMapping)
+}
+
+func (s *migrationStore) GetCategory(id string) (CategoryMapping, error) {
+	item := CategoryMapping{}
+	err := s.backend.getItem(categoriesTable, id, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveFeature(featureMapping FeatureMapping) error {
+	return s.backend.putItem(featuresTable, featureMapping.Id, featureMapping)
+}
+
+func (s *migrationStore) GetFeature(attributeCode string) (FeatureMapping, error) {
+	item := FeatureMapping{}
+	err := s.backend.getItem(featuresTable, attributeCode, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveImage(imageMapping ImageMapping) error {
+	return s.backend.putItem(imagesTable, imageMapping.Id, imageMapping)
+}
+
+func (s *migrationStore) GetImage(id string) (ImageMapping, error) {
+	item := ImageMapping{}
+	err := s.backend.getItem(imagesTable, id, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveUsergroup(usergroupMapping UsergroupMapping) error {
+	return s.backend.putItem(usergroupsTable, usergroupMapping.Id, usergroupMapping)
+}
+
+func (s *migrationStore) GetUsergroup(groupId string) (UsergroupMapping, error) {
+	item := UsergroupMapping{}
+	err := s.backend.getItem(usergroupsTable, groupId, &item)
+	return item, err
+}
+
+func (s *migrationStore) SaveCheckpoint(checkpoint Checkpoint) error {
+	return s.backend.putItem(checkpointsTable, checkpoint.Id, checkpoint)
+}
+
+func (s *migrationStore) GetCheckpoint(id string) (Checkpoint, error) {
+	item := Checkpoint{}
+	err := s.backend.getItem(checkpointsTable, id, &item)
+	if err == ErrNotFound {
+		return item, nil
+	}
+	return item, err
+}
+
+func (s *migrationStore) SaveJob(job Job) error {
+	return s.backend.putItem(jobsTable, job.Id, job)
+}
+
+func (s *migrationStore) GetJob(id string) (Job, error) {
+	item := Job{}
+	err := s.backend.getItem(jobsTable, id, &item)
+	return item, err
+}
+
+// memoryBackend keeps the items as json by table and key, it is lost when the process ends
+type memoryBackend struct {
+	mu     sync.RWMutex
+	tables map[string]map[string]json.RawMessage
+}
+
+func NewMemoryStore() MigrationStore {
+	return &migrationStore{backend: newMemoryBackend()}
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{tables: make(map[string]map[string]json.RawMessage)}
+}
+
+func (b *memoryBackend) putItem(table storeTable, key string, item interface{}) error {
+	value, err := json.Marshal(item)
+	if err != nil {
+		fmt.Println("Error marshalling item: ", err.Error())
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tables[table.envName] == nil {
+		b.tables[table.envName] = make(map[string]json.RawMessage)
+	}
+	b.tables[table.envName][key] = value
+
+	return nil
+}
+
+// putItems fails for the repeated keys like BatchWriteItem
+func (b *memoryBackend) putItems(table storeTable, items []storeItem) error {
+	keys := make(map[string]bool)
+	for _, item := range items {
+		if keys[item.key] {
+			return errors.New("the batch has the key " + item.key + " more than once")
+		}
+		keys[item.key] = true
+	}
+
+	for _, item := range items {
+		err := b.putItem(table, item.key, item.item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) getItem(table storeTable, key string, item interface{}) error {
+	b.mu.RLock()
+	value, ok := b.tables[table.envName][key]
+	b.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+
+	return json.Unmarshal(value, item)
+}
+
+func (b *memoryBackend) getItems(table storeTable, keys []string, item func(key string) interface{}) error {
+	for _, key := range keys {
+		b.mu.RLock()
+		value, ok := b.tables[table.envName][key]
+		b.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		err := json.Unmarshal(value, item(key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileBackend is a memoryBackend written to a json file after every change, meant for local runs
+type fileBackend struct {
+	*memoryBackend
+	path    string
+	writeMu sync.Mutex // keeps the file writes in the same order as the changes
+}
+
+func NewFileStore(path string) (MigrationStore, error) {
+	backend := &fileBackend{memoryBackend: newMemoryBackend(), path: path}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		err = json.Unmarshal(content, &backend.tables)
+		if err != nil {
+			return nil, errors.New("error reading the migration store " + path + ": " + err.Error())
+		}
+	}
+
+	return &migrationStore{backend: backend}, nil
+}
+
+func (b *fileBackend) putItem(table storeTable, key string, item interface{}) error {
+	return b.putItems(table, []storeItem{{key: key, item: item}})
+}
+
+// putItems writes the file once for all the items
+func (b *fileBackend) putItems(table storeTable, items []storeItem) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	err := b.memoryBackend.putItems(table, items)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	content, err := json.MarshalIndent(b.tables, "", "  ")
+	b.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// written on a temporal file and renamed so a crash never leaves the store half written
+	tmp, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), b.path)
+}
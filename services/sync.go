@@ -0,0 +1,394 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	magentohash "migration-m2-gama/magentohash"
+)
+
+const (
+	UsersCheckpointId = "users"
+	DeltaCheckpointId = "users-delta"
+	magentoDateLayout = "2006-01-02 15:04:05"
+	timeoutMargin     = 30 * time.Second // time kept free before the lambda timeout to save the checkpoint
+)
+
+type BodyRequest struct {
+	Force    bool     `json:"force"`
+	All      bool     `json:"all"`       // migrate every magento customer instead of only the listed users
+	PageSize int      `json:"page_size"` // customers read per magento page when all is true
+	Restart  bool     `json:"restart"`   // discard the saved checkpoint and start the bulk migration again
+	DryRun   bool     `json:"dry_run"`   // return the payloads that would be sent, only the job record is written
+	Users    []User   `json:"users"`
+	Skus     []string `json:"skus,omitempty"`   // products to migrate when all is false
+	Source   string   `json:"source,omitempty"` // customer export of a hashes job, a file path or s3://bucket/key
+	Format   string   `json:"format,omitempty"` // csv or ndjson, by default from the extension of source
+}
+
+type User struct {
+	Email string `json:"email"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+type ResponseCode struct {
+	Code  int    `json:"code"`
+	Label string `json:"label"`
+}
+
+func GetResponseCodes() []ResponseCode {
+	var responseCodes []ResponseCode
+	for label, code := range getResponseCodeLabels() {
+		responseCodes = append(responseCodes, ResponseCode{
+			Code:  code,
+			Label: label,
+		})
+	}
+	return responseCodes
+}
+
+func getResponseCodeLabels() map[string]int {
+	return map[string]int{
+		"User created successfylly":       1,
+		"User updated successfylly":       2,
+		"Error creating or updating user": 3,
+		"User without changes on GAMA":    4,
+		"User skipped, never migrated":    5,
+	}
+}
+
+// getErrorCode returns the code of the errors that the client can fix on the data it sends, like the
+// password hashes that are not magento hashes
+func getErrorCode(err error) string {
+	if hashErr, ok := err.(*magentohash.InvalidHashError); ok {
+		return hashErr.Code
+	}
+	return ""
+}
+
+// isMigrated reports if the saved result is of a user that is already on GAMA
+func isMigrated(migratedUser BodyResult) bool {
+	return migratedUser.ResponseCode == 1 || migratedUser.ResponseCode == 2 || migratedUser.ResponseCode == 4
+}
+
+// SyncUser imports the magento customers found with the email of the user, the ones already
+// migrated are only imported again when force is true. On dry runs the results have the preview
+// of the import and nothing is written.
+func SyncUser(user User, force bool, dryRun bool) ([]BodyResult, error) {
+	var bodyResults []BodyResult
+
+	fmt.Println("Updating: " + user.Email + " | force: " + strconv.FormatBool(force) + " | dry run: " + strconv.FormatBool(dryRun))
+	migratedUser, err := GetMigratedUser(user.Email)
+	if err != nil {
+		fmt.Println("Error returned by getMigratedUser function: ", err.Error())
+		return append(bodyResults, readErrorResult(user.Email, err)), nil
+	}
+
+	if isMigrated(migratedUser) && !force {
+		return append(bodyResults, migratedUser), nil
+	}
+
+	magentoResult, err := GetMagentoUser(user.Email)
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoUser function: ", err.Error())
+		return append(bodyResults, readErrorResult(user.Email, err)), nil
+	}
+	if magentoResult.Total <= 0 {
+		bodyResult := BodyResult{
+			Email:        user.Email,
+			ResponseCode: 3,
+			Reason:       "Not user found on magento databse",
+		}
+		if !dryRun {
+			SaveResultToDb(bodyResult)
+		}
+		return append(bodyResults, bodyResult), nil
+	}
+
+	// looping for each magento item
+	for _, magentoUser := range magentoResult.Items {
+		magentoUser.Hash = user.Hash
+		if dryRun {
+			bodyResults = append(bodyResults, PreviewMagentoUser(magentoUser, force))
+		} else {
+			bodyResults = append(bodyResults, ImportMagentoUser(magentoUser, force))
+		}
+	}
+
+	return bodyResults, nil
+}
+
+// readErrorResult is the result of a user that could not be read after the retries, the job goes on with
+// the next user. It is not saved so the result of a user migrated before is kept.
+func readErrorResult(email string, err error) BodyResult {
+	return BodyResult{
+		Email:        email,
+		ResponseCode: 3,
+		Reason:       err.Error(),
+	}
+}
+
+// ImportMagentoUser sends one magento user to gama and stores the result
+func ImportMagentoUser(magentoUser MagentoUser, force bool) BodyResult {
+	bodyResult := BodyResult{
+		Email: magentoUser.Email,
+	}
+
+	responseCode, diff, err := GamaImportUser(magentoUser, force)
+	bodyResult.ResponseCode = responseCode
+	bodyResult.Diff = diff
+	if err != nil {
+		bodyResult.ResponseCode = 3
+		bodyResult.Reason = err.Error()
+		bodyResult.ErrorCode = getErrorCode(err)
+	} else {
+		bodyResult.Reason = usergroupWarning(magentoUser.GroupId)
+	}
+	SaveResultToDb(bodyResult)
+
+	return bodyResult
+}
+
+// SyncAllUsers walks the whole magento customer base page by page and imports every customer,
+// the cursor is saved after every user so a later invocation resumes where this one stopped
+func SyncAllUsers(ctx context.Context, force bool, pageSize int, restart bool) ([]BodyResult, Checkpoint, error) {
+	var magentoUsers []MagentoUser
+
+	return syncAllPages(ctx, pagedMigration{
+		checkpointId: UsersCheckpointId,
+		entity:       "customers",
+		readPage: func(afterEntityId int, pageSize int) ([]int, int, error) {
+			magentoResult, err := GetMagentoUsersPage(afterEntityId, pageSize)
+			if err != nil {
+				fmt.Println("Error returned by GetMagentoUsersPage function: ", err.Error())
+				return nil, 0, err
+			}
+			magentoUsers = magentoResult.Items
+			entityIds := make([]int, len(magentoUsers))
+			for index, magentoUser := range magentoUsers {
+				entityIds[index] = magentoUser.Id
+			}
+			return entityIds, magentoResult.Total, nil
+		},
+		importItem: func(index int, checkpoint *Checkpoint) (BodyResult, error) {
+			migratedUser, err := GetMigratedUser(magentoUsers[index].Email)
+			if err != nil {
+				fmt.Println("Error returned by getMigratedUser function: ", err.Error())
+				return migratedUser, err
+			}
+			if isMigrated(migratedUser) && !force {
+				checkpoint.Skipped++
+				return migratedUser, nil
+			}
+			bodyResult := ImportMagentoUser(magentoUsers[index], force)
+			countResult(checkpoint, bodyResult)
+			return bodyResult, nil
+		},
+	}, pageSize, restart)
+}
+
+// pagedMigration is a bulk migration of the magento entities read page by page in entity_id order
+type pagedMigration struct {
+	checkpointId string
+	entity       string // customers or products, for the logs
+	// readPage returns the entity_ids of the first page of the items after afterEntityId and the
+	// total_count of magento, the items left
+	readPage func(afterEntityId int, pageSize int) ([]int, int, error)
+	// importItem imports the item of the last page read at index and counts it on the checkpoint
+	importItem func(index int, checkpoint *Checkpoint) (BodyResult, error)
+}
+
+// syncAllPages runs the paged migration from its checkpoint, the cursor is saved after every item and
+// the migration stops, with the checkpoint saved, when the lambda is running out of time
+func syncAllPages(ctx context.Context, migration pagedMigration, pageSize int, restart bool) ([]BodyResult, Checkpoint, error) {
+	var bodyResults []BodyResult
+
+	checkpoint, err := GetCheckpointFromDb(migration.checkpointId)
+	if err != nil {
+		fmt.Println("Error returned by GetCheckpointFromDb function: ", err.Error())
+		return bodyResults, checkpoint, err
+	}
+	if checkpoint.Id == "" || checkpoint.Finished || restart {
+		checkpoint = Checkpoint{
+			Id:        migration.checkpointId,
+			PageSize:  pageSize,
+			StartedAt: time.Now().Format(time.RFC3339),
+		}
+		if checkpoint.PageSize <= 0 {
+			checkpoint.PageSize = DefaultPageSize
+		}
+	} else {
+		fmt.Println("Resuming " + migration.entity + " migration after entity_id: " + strconv.Itoa(checkpoint.LastEntityId))
+	}
+
+	// every page is read after the last entity_id processed instead of by page number, the entities
+	// deleted on magento during the migration would move the later ones to the pages already read
+	for page := checkpoint.LastPage + 1; ; page++ {
+		fmt.Println("Reading magento " + migration.entity + " after entity_id: " + strconv.Itoa(checkpoint.LastEntityId))
+		entityIds, total, err := migration.readPage(checkpoint.LastEntityId, checkpoint.PageSize)
+		if err != nil {
+			return bodyResults, checkpoint, err
+		}
+		checkpoint.Total = checkpoint.Processed + total
+
+		for index, entityId := range entityIds {
+			if IsRunningOutOfTime(ctx) {
+				fmt.Println("Stopping " + migration.entity + " migration before lambda timeout at entity_id: " + strconv.Itoa(checkpoint.LastEntityId))
+				return bodyResults, checkpoint, saveCheckpoint(&checkpoint)
+			}
+
+			bodyResult, err := migration.importItem(index, &checkpoint)
+			if err != nil {
+				return bodyResults, checkpoint, err
+			}
+			bodyResults = append(bodyResults, bodyResult)
+
+			checkpoint.Processed++
+			checkpoint.LastEntityId = entityId
+			err = saveCheckpoint(&checkpoint)
+			if err != nil {
+				return bodyResults, checkpoint, err
+			}
+		}
+
+		checkpoint.LastPage = page
+		// total_count has the items left, the migration finishes with the page that had all of them
+		if len(entityIds) == 0 || len(entityIds) >= total {
+			checkpoint.Finished = true
+		}
+		err = saveCheckpoint(&checkpoint)
+		if err != nil || checkpoint.Finished {
+			return bodyResults, checkpoint, err
+		}
+	}
+}
+
+// SyncUpdatedUsers pushes to gama, with update semantics, the migrated magento customers modified since
+// the last delta run, the ones that were never migrated are skipped. When since is empty the high water
+// mark saved by the previous run is used, and on the first run the start of the bulk migration.
+func SyncUpdatedUsers(ctx context.Context, since string) ([]BodyResult, Checkpoint, error) {
+	var bodyResults []BodyResult
+	defer withRequestsContext(ctx)()
+
+	checkpoint, err := GetCheckpointFromDb(DeltaCheckpointId)
+	if err != nil {
+		fmt.Println("Error returned by GetCheckpointFromDb function: ", err.Error())
+		return bodyResults, checkpoint, err
+	}
+	if since == "" {
+		since, err = getDeltaHighWaterMark(checkpoint)
+		if err != nil {
+			return bodyResults, checkpoint, err
+		}
+	}
+	if since != checkpoint.HighWaterMark {
+		checkpoint.LastEntityId = 0 // the entity_id tiebreaker only applies to the saved mark
+	}
+	checkpoint = Checkpoint{
+		Id:            DeltaCheckpointId,
+		PageSize:      DefaultPageSize,
+		LastEntityId:  checkpoint.LastEntityId,
+		HighWaterMark: since,
+		StartedAt:     time.Now().Format(time.RFC3339),
+	}
+
+	fmt.Println("Reading magento customers updated since: " + since)
+	for page := 1; ; page++ {
+		magentoResult, err := GetMagentoUsersUpdatedSince(since, page, checkpoint.PageSize)
+		if err != nil {
+			fmt.Println("Error returned by GetMagentoUsersUpdatedSince function: ", err.Error())
+			return bodyResults, checkpoint, err
+		}
+
+		for _, magentoUser := range magentoResult.Items {
+			// customers sharing the second of the mark were already sent up to LastEntityId
+			if magentoUser.UpdatedAt == checkpoint.HighWaterMark && magentoUser.Id <= checkpoint.LastEntityId {
+				continue
+			}
+			if IsRunningOutOfTime(ctx) {
+				fmt.Println("Stopping delta sync before lambda timeout at updated_at: " + checkpoint.HighWaterMark)
+				return bodyResults, checkpoint, saveCheckpoint(&checkpoint)
+			}
+
+			migratedUser, err := GetMigratedUser(magentoUser.Email)
+			if err != nil {
+				fmt.Println("Error returned by getMigratedUser function: ", err.Error())
+				return bodyResults, checkpoint, err
+			}
+
+			// only the users of the bulk migration are updated, the new customers are created by it
+			bodyResult := BodyResult{Email: magentoUser.Email, ResponseCode: 5, Reason: "the user was never migrated, the delta only updates migrated users"}
+			if isMigrated(migratedUser) {
+				bodyResult = ImportMagentoUser(magentoUser, true)
+			}
+			countResult(&checkpoint, bodyResult)
+			bodyResults = append(bodyResults, bodyResult)
+
+			checkpoint.Processed++
+			checkpoint.HighWaterMark = magentoUser.UpdatedAt
+			checkpoint.LastEntityId = magentoUser.Id
+		}
+
+		checkpoint.Total = magentoResult.Total
+		checkpoint.LastPage = page
+		if len(magentoResult.Items) == 0 || page*checkpoint.PageSize >= magentoResult.Total {
+			checkpoint.Finished = true
+			return bodyResults, checkpoint, saveCheckpoint(&checkpoint)
+		}
+		err = saveCheckpoint(&checkpoint)
+		if err != nil {
+			return bodyResults, checkpoint, err
+		}
+	}
+}
+
+// IsRunningOutOfTime reports if the lambda deadline is closer than the time reserved to finish the current user
+func IsRunningOutOfTime(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < timeoutMargin
+}
+
+func getDeltaHighWaterMark(checkpoint Checkpoint) (string, error) {
+	if checkpoint.HighWaterMark != "" {
+		return checkpoint.HighWaterMark, nil
+	}
+
+	bulkCheckpoint, err := GetCheckpointFromDb(UsersCheckpointId)
+	if err != nil {
+		fmt.Println("Error returned by GetCheckpointFromDb function: ", err.Error())
+		return "", err
+	}
+	startedAt, err := time.Parse(time.RFC3339, bulkCheckpoint.StartedAt)
+	if err != nil {
+		return "", errors.New("there is no high water mark for the delta sync, run the bulk migration first or send the since param")
+	}
+
+	return startedAt.UTC().Format(magentoDateLayout), nil
+}
+
+func countResult(checkpoint *Checkpoint, bodyResult BodyResult) {
+	switch bodyResult.ResponseCode {
+	case 1:
+		checkpoint.Created++
+	case 2:
+		checkpoint.Updated++
+	case 4:
+		checkpoint.Unchanged++
+	case 5:
+		checkpoint.Skipped++
+	default:
+		checkpoint.Failed++
+	}
+}
+
+func saveCheckpoint(checkpoint *Checkpoint) error {
+	checkpoint.UpdatedAt = time.Now().Format(time.RFC3339)
+	err := SaveCheckpointToDb(*checkpoint)
+	if err != nil {
+		fmt.Println("Error returned by SaveCheckpointToDb function: ", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	getCustomerGroupEndpoint = "customerGroups/"
+	usergroupsEndpoint       = "api/usergroups"
+	magentoGeneralGroup      = 1 // NOT LOGGED IN (0) and General (1) customers get no usergroup unless they are mapped
+)
+
+type MagentoCustomerGroup struct {
+	Id   int    `json:"id"`
+	Code string `json:"code"`
+}
+
+type GamaUsergroup struct {
+	Usergroup string `json:"usergroup"`
+	Type      string `json:"type"` // C for customers
+	Status    string `json:"status"`
+}
+
+type GamaUsergroupResponse struct {
+	UsergroupId int `json:"usergroup_id"`
+}
+
+// GamaUserUsergroup is a usergroup of a GAMA user, the user is a member while the status is A
+type GamaUserUsergroup struct {
+	UsergroupId int    `json:"usergroup_id,string"`
+	Status      string `json:"status"`
+}
+
+// UsergroupMapping relates a magento customer group_id (as string, it is the key) with the GAMA
+// usergroup created for it
+type UsergroupMapping struct {
+	Id     string `json:"id"`
+	Code   string `json:"code"`
+	GamaId int    `json:"gama_id,omitempty"`
+	Result bool   `json:"response_code"`
+}
+
+// GetMapUsergroups returns the GAMA usergroup_id of the magento group ids configured on the stage as
+// group_id:usergroup_id pairs separated by commas, usergroup_id 0 leaves the customers without usergroup
+func GetMapUsergroups() (map[int]int, error) {
+	usergroups := make(map[int]int)
+	config := strings.TrimSpace(os.Getenv("gamaUsergroups"))
+	if config == "" {
+		return usergroups, nil
+	}
+
+	for _, pair := range strings.Split(config, ",") {
+		ids := strings.Split(strings.TrimSpace(pair), ":")
+		if len(ids) != 2 {
+			return usergroups, errors.New("invalid usergroups mapping " + pair + ", use group_id:usergroup_id")
+		}
+		groupId, err := strconv.Atoi(ids[0])
+		if err != nil {
+			return usergroups, errors.New("invalid usergroups mapping " + pair + ", use group_id:usergroup_id")
+		}
+		usergroupId, err := strconv.Atoi(ids[1])
+		if err != nil {
+			return usergroups, errors.New("invalid usergroups mapping " + pair + ", use group_id:usergroup_id")
+		}
+		usergroups[groupId] = usergroupId
+	}
+
+	return usergroups, nil
+}
+
+func GetMagentoCustomerGroup(groupId int) (MagentoCustomerGroup, error) {
+	return GetMagentoClient().GetCustomerGroup(getCustomerGroupEndpoint + strconv.Itoa(groupId))
+}
+
+// getGamaUsergroupId returns the GAMA usergroup of the magento customer group: the one configured on
+// the stage or the one created for it. Groups without usergroup are created when the stage allows it
+// and create is true, otherwise 0 is returned for them unless the stage requires the usergroups.
+func getGamaUsergroupId(groupId int, create bool) (int, error) {
+	usergroupId, found, err := findGamaUsergroupId(groupId)
+	if err != nil || found || groupId <= magentoGeneralGroup {
+		return usergroupId, err
+	}
+	if os.Getenv("gamaCreateUsergroups") != "true" {
+		if os.Getenv("gamaRequireUsergroups") == "true" {
+			return 0, errors.New("magento customer group " + strconv.Itoa(groupId) + " has no GAMA usergroup, map it on the usergroups of the stage or allow creating them")
+		}
+		return 0, nil
+	}
+	if !create {
+		return 0, nil
+	}
+
+	return createGamaUsergroup(groupId)
+}
+
+// findGamaUsergroupId returns the usergroup configured on the stage or created for the magento group
+func findGamaUsergroupId(groupId int) (int, bool, error) {
+	usergroups, err := GetMapUsergroups()
+	if err != nil {
+		return 0, false, err
+	}
+	if usergroupId, ok := usergroups[groupId]; ok {
+		return usergroupId, true, nil
+	}
+
+	mapping, err := GetUsergroupFromDb(strconv.Itoa(groupId))
+	if err == nil && mapping.Result {
+		return mapping.GamaId, true, nil
+	}
+	return 0, false, nil
+}
+
+// usergroupWarning returns the reason of the users migrated without usergroup because their magento
+// group is not mapped nor created, empty for the rest
+func usergroupWarning(groupId int) string {
+	if groupId <= magentoGeneralGroup || os.Getenv("gamaCreateUsergroups") == "true" {
+		return ""
+	}
+	if _, found, err := findGamaUsergroupId(groupId); err != nil || found {
+		return ""
+	}
+	return "migrated without usergroup, the magento customer group " + strconv.Itoa(groupId) + " has no GAMA usergroup"
+}
+
+// createGamaUsergroup creates a customers usergroup named as the code of the magento group
+func createGamaUsergroup(groupId int) (int, error) {
+	magentoGroup, err := GetMagentoCustomerGroup(groupId)
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoCustomerGroup function: ", err.Error())
+		return 0, errors.New("error reading the magento customer group " + strconv.Itoa(groupId) + ": " + err.Error())
+	}
+
+	gamaUsergroupResponse, err := GetCSCartClient().CreateUsergroup(GamaUsergroup{
+		Usergroup: magentoGroup.Code,
+		Type:      "C",
+		Status:    "A",
+	})
+	mapping := UsergroupMapping{
+		Id:     strconv.Itoa(groupId),
+		Code:   magentoGroup.Code,
+		GamaId: gamaUsergroupResponse.UsergroupId,
+		Result: err == nil,
+	}
+	saveErr := SaveUsergroupToDb(mapping)
+	if saveErr != nil {
+		fmt.Println("Error returned by SaveUsergroupToDb function: ", saveErr.Error())
+	}
+	if err != nil {
+		return 0, errors.New("error creating the usergroup " + magentoGroup.Code + ": " + err.Error())
+	}
+
+	return mapping.GamaId, nil
+}
+
+// diffUsergroup returns the change when the GAMA user is not an active member of the usergroup
+func diffUsergroup(gamaUserId string, usergroupId int) (*FieldChange, error) {
+	if usergroupId == 0 {
+		return nil, nil
+	}
+
+	usergroups, err := GetCSCartClient().GetUserUsergroups(gamaUserId)
+	if err != nil {
+		return nil, err
+	}
+	var active []string
+	for _, usergroup := range usergroups {
+		if usergroup.Status != "A" {
+			continue
+		}
+		if usergroup.UsergroupId == usergroupId {
+			return nil, nil
+		}
+		active = append(active, strconv.Itoa(usergroup.UsergroupId))
+	}
+
+	return &FieldChange{Field: "usergroup_id", Old: strings.Join(active, ","), New: strconv.Itoa(usergroupId)}, nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	getConfigurableEndpoint = "configurable-products/"
+	variationGroupsEndpoint = "api/product_variations_groups"
+	configurableProductType = "configurable"
+)
+
+type MagentoConfigurableValue struct {
+	ValueIndex int `json:"value_index"`
+}
+
+// MagentoConfigurableOption is an attribute (size, color...) whose values make the children of a configurable product
+type MagentoConfigurableOption struct {
+	Id          int                        `json:"id"`
+	AttributeId string                     `json:"attribute_id"`
+	Label       string                     `json:"label"`
+	Position    int                        `json:"position"`
+	Values      []MagentoConfigurableValue `json:"values"`
+}
+
+// GamaProductFeature is the value of a feature of a GAMA product: the variant of the selects, the
+// variants of the multiple checkboxes or the value of the texts
+type GamaProductFeature struct {
+	FeatureId int    `json:"feature_id"`
+	VariantId int    `json:"variant_id,omitempty"`
+	Variants  []int  `json:"variants,omitempty"`
+	Value     string `json:"value,omitempty"`
+}
+
+// GamaVariationGroup groups the GAMA products that are variations of the same product by the features
+// that make them different, the first product is the parent
+type GamaVariationGroup struct {
+	ProductIds []int `json:"product_ids"`
+	FeatureIds []int `json:"feature_ids"`
+}
+
+type GamaVariationGroupResponse struct {
+	GroupId int `json:"group_id"`
+}
+
+// variation is a child of a configurable product with the values of the variation features
+type variation struct {
+	product  MagentoProduct
+	features map[string]GamaProductFeature
+}
+
+func GetMagentoConfigurableChildren(sku string) ([]MagentoProduct, error) {
+	return GetMagentoClient().GetProducts(getConfigurableEndpoint + url.PathEscape(sku) + "/children")
+}
+
+func GetMagentoConfigurableOptions(sku string) ([]MagentoConfigurableOption, error) {
+	return GetMagentoClient().GetConfigurableOptions(getConfigurableEndpoint + url.PathEscape(sku) + "/options/all")
+}
+
+// importConfigurableProduct migrates the children of the configurable product as a group of GAMA
+// variations: the options are variation features and the first child is the parent of the group. The
+// children take the name, descriptions, categories and status of the configurable product.
+func importConfigurableProduct(magentoProduct MagentoProduct, mapping ProductMapping) (ProductMapping, error) {
+	variations, featureIds, err := getVariations(magentoProduct)
+	if err != nil {
+		return mapping, err
+	}
+	if len(variations) == 0 {
+		return mapping, errors.New("configurable product " + magentoProduct.Sku + " has no children")
+	}
+
+	productIds := make([]int, len(variations))
+	for index, child := range variations {
+		childMapping, err := GetProductFromDb(child.product.Sku)
+		if err != nil && err != ErrNotFound {
+			return mapping, err
+		}
+		if index == 0 && mapping.GamaId != 0 {
+			childMapping.GamaId = mapping.GamaId // the parent of the group
+		}
+
+		gamaProduct, err := translateVariationInformation(magentoProduct, child)
+		if err != nil {
+			return mapping, errors.New("variation " + child.product.Sku + ": " + err.Error())
+		}
+
+		gamaId, err := sendGamaProduct(childMapping.GamaId, gamaProduct)
+		childMapping = ProductMapping{
+			Sku:       child.product.Sku,
+			MagentoId: child.product.Id,
+			GamaId:    gamaId,
+			ParentSku: magentoProduct.Sku,
+			Result:    err == nil && gamaId != 0,
+		}
+		saveErr := SaveProductToDb(childMapping)
+		if saveErr != nil {
+			fmt.Println("Error returned by SaveProductToDb function: ", saveErr.Error())
+		}
+		if err != nil {
+			return mapping, errors.New("variation " + child.product.Sku + ": " + err.Error())
+		}
+		productIds[index] = gamaId
+	}
+	mapping.GamaId = productIds[0]
+
+	group := GamaVariationGroup{ProductIds: productIds, FeatureIds: featureIds}
+	if mapping.GroupId != 0 {
+		err = GetCSCartClient().UpdateVariationGroup(mapping.GroupId, group)
+	} else {
+		var groupResponse GamaVariationGroupResponse
+		groupResponse, err = GetCSCartClient().CreateVariationGroup(group)
+		mapping.GroupId = groupResponse.GroupId
+	}
+
+	return mapping, err
+}
+
+// getVariations returns the children of the configurable product with the GAMA variants of their options
+func getVariations(magentoProduct MagentoProduct) ([]variation, []int, error) {
+	options, err := GetMagentoConfigurableOptions(magentoProduct.Sku)
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoConfigurableOptions function: ", err.Error())
+		return nil, nil, err
+	}
+
+	var attributes []MagentoProductAttribute
+	var features []FeatureMapping
+	var featureIds []int
+	for _, option := range options {
+		attribute, err := GetMagentoProductAttribute(option.AttributeId)
+		if err != nil {
+			fmt.Println("Error returned by GetMagentoProductAttribute function: ", err.Error())
+			return nil, nil, err
+		}
+		feature, err := migrateAttributeFeature(attribute, "S", VariationFeaturePurpose, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		attributes = append(attributes, attribute)
+		features = append(features, feature)
+		featureIds = append(featureIds, feature.FeatureId)
+	}
+
+	children, err := GetMagentoConfigurableChildren(magentoProduct.Sku)
+	if err != nil {
+		fmt.Println("Error returned by GetMagentoConfigurableChildren function: ", err.Error())
+		return nil, nil, err
+	}
+
+	variations := make([]variation, len(children))
+	for index, child := range children {
+		variations[index] = variation{product: child, features: make(map[string]GamaProductFeature)}
+		for optionIndex, attribute := range attributes {
+			value := getProductAttribute(child, attribute.AttributeCode)
+			variantId, ok := features[optionIndex].Variants[value]
+			if !ok {
+				return nil, nil, errors.New("variation " + child.Sku + " has no GAMA variant for " + attribute.AttributeCode + " " + value)
+			}
+			featureId := strconv.Itoa(features[optionIndex].FeatureId)
+			variations[index].features[featureId] = GamaProductFeature{FeatureId: features[optionIndex].FeatureId, VariantId: variantId}
+		}
+	}
+
+	return variations, featureIds, nil
+}
+
+func translateVariationInformation(configurable MagentoProduct, child variation) (GamaProduct, error) {
+	gamaProduct, err := translateProductInformation(configurable)
+	if err != nil {
+		return gamaProduct, err
+	}
+
+	gamaProduct.ProductCode = child.product.Sku
+	gamaProduct.Price = child.product.Price
+	gamaProduct.Weight = child.product.Weight
+	gamaProduct.Amount = getProductStock(child.product)
+	if child.product.Status != 1 {
+		gamaProduct.Status = "D"
+	}
+	for featureId, feature := range child.features {
+		gamaProduct.ProductFeatures[featureId] = feature
+	}
+
+	return gamaProduct, nil
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -14,114 +13,43 @@ import (
 
 var stage string //this var is assigned from make file on build command
 
-type BodyRequest struct {
-	Force bool   `json:"force"`
-	Users []User `json:"users"`
-}
-
-type User struct {
-	Email string `json:"email"`
-	Hash  string `json:"hash,omitempty"`
-}
-
-type ResponseCode struct {
-	Code  int    `json:"code"`
-	Label string `json:"label"`
-}
-
-type BodyResults struct {
-	Results       []services.BodyResult   `json:"results"`
-	ResponseCodes []ResponseCode `json:"response_codes"`
+type JobCreated struct {
+	JobId  string `json:"job_id"`
+	Status string `json:"status"`
 }
 
+// SyncUsers enqueues the migration of the request and returns the id of the job, its progress and
+// results are exposed by GET /jobs/{id}
 func SyncUsers(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	var magentoResult services.MagentoResults
-	var err error
-	responsecodes := getResponseCodeLabels()
-	bodyRequest := BodyRequest{}
-	bodyResults := BodyResults{}
-
-	for label, code := range responsecodes {
-		bodyResults.ResponseCodes = append(bodyResults.ResponseCodes, ResponseCode{
-			Code:  code,
-			Label: label,
-		})
-	}
+	bodyRequest := services.BodyRequest{}
 
-	err = json.Unmarshal([]byte(request.Body), &bodyRequest)
+	err := json.Unmarshal([]byte(request.Body), &bodyRequest)
 	if err != nil {
 		fmt.Println("Error destructuring the body of the request on SyncUsers function : ", err.Error())
 		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
 	}
 
-	for _, user := range bodyRequest.Users {
-		force := bodyRequest.Force // this variable is used to persit magento user information if in gama the user already exists
-
-		fmt.Println("Updating: " + user.Email + " | force: " + strconv.FormatBool(force))
-		migratedUser, err := services.GetMigratedUser(user.Email)
-		
-		if err != nil {
-			fmt.Println("Error returned by getMigratedUser function: ", err.Error())
-			return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
-		}
+	if !bodyRequest.All && len(bodyRequest.Users) == 0 {
+		return events.APIGatewayProxyResponse{Body: "users is empty, send the users to migrate or all equals to true", StatusCode: http.StatusBadRequest}, nil
+	}
 
-		if (migratedUser.ResponseCode == 2 || migratedUser.ResponseCode == 1) && !force {
-			bodyResults.Results = append(bodyResults.Results, migratedUser)
-		} else {
-			magentoResult, err = services.GetMagentoUser(user.Email)
-			if err != nil {
-				fmt.Println("Error returned by GetMagentoUser function: ", err.Error())
-				return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
-			}
-			if magentoResult.Total <= 0 {
-				bodyResult := services.BodyResult{
-					Email:        user.Email,
-					ResponseCode: 3,
-					Reason:       "Not user found on magento databse",
-				}
-				bodyResults.Results = append(bodyResults.Results, bodyResult)
-				services.SaveResultToDb(bodyResult)
-			}
-	
-			// looping for each magento item
-			for _, magentoUser := range magentoResult.Items {
-				magentoUser.Hash = user.Hash
-				responseCode, err := services.GamaImportUser(magentoUser, force)
-				if err != nil {
-					bodyResult := services.BodyResult{
-						Email:        magentoUser.Email,
-						ResponseCode: 3,
-						Reason:       err.Error(),
-					}
-					bodyResults.Results = append(bodyResults.Results, bodyResult)
-					services.SaveResultToDb(bodyResult)
-				} else {
-					bodyResult := services.BodyResult{
-						Email:        magentoUser.Email,
-						ResponseCode: responseCode,
-					}
-					bodyResults.Results = append(bodyResults.Results, bodyResult)
-					services.SaveResultToDb(bodyResult)
-				}
-			}
-		}
+	if bodyRequest.All && bodyRequest.DryRun {
+		return events.APIGatewayProxyResponse{Body: "dry_run is only supported on POST /users with a list of users", StatusCode: http.StatusBadRequest}, nil
 	}
 
-	marshaledResult, err := json.Marshal(bodyResults)
+	job, err := services.CreateJob(services.UsersJob, bodyRequest)
 	if err != nil {
-		fmt.Println("Error on marshal sync result: ", err.Error())
+		fmt.Println("Error returned by CreateJob function: ", err.Error())
 		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
 	}
 
-	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusOK}, nil
-}
-
-func getResponseCodeLabels() map[string]int {
-	return map[string]int{
-		"User created successfylly":       1,
-		"User updated successfylly":       2,
-		"Error creating or updating user": 3,
+	marshaledResult, err := json.Marshal(JobCreated{JobId: job.Id, Status: job.Status})
+	if err != nil {
+		fmt.Println("Error on marshal job: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
 	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusAccepted}, nil
 }
 
 func main() {
@@ -0,0 +1,935 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+type harness struct {
+	*fakes.Environment
+}
+
+func newHarness(t *testing.T) *harness {
+	return &harness{fakes.NewEnvironment(t)}
+}
+
+// post sends the body to SyncUsers and returns the job once the worker processed it
+func (h *harness) post(t *testing.T, body string) services.Job {
+	t.Helper()
+	return h.RunJob(t, SyncUsers, body)
+}
+
+func magentoCustomer(email string) services.MagentoUser {
+	return services.MagentoUser{
+		Email:     email,
+		Firstname: "Maria",
+		Lastname:  "Valencia",
+		GroupId:   1,
+	}
+}
+
+func magentoAddress(id int, street string) services.Address {
+	return services.Address{
+		Id:        id,
+		Region:    services.Region{RegionCode: "CDMX", Region: "Ciudad de México", RegionId: 764},
+		CountryId: "MX",
+		Street:    []string{street},
+		Telephone: "5555555555",
+		Postcode:  "06700",
+		Firstname: "Maria",
+		Lastname:  "Valencia",
+		City:      "Cuauhtémoc",
+		Attributes: []services.Attribute{
+			{Code: "external_number", Value: "12"},
+			{Code: "internal_number", Value: "3"},
+			{Code: "suburb", Value: "Roma Norte"},
+			{Code: "receptor_details", Value: "Blue door"},
+		},
+	}
+}
+
+func TestSyncUsersCreatesUserProfilesAndHash(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), magentoAddress(11, "Calle Orizaba")}
+	customer.Addresses = &addresses
+	customer.DefaultShipping = 10
+	h.Magento.AddCustomer(customer)
+	hash := base64.StdEncoding.EncodeToString([]byte("a665a45920422f9d417e4867efdc4fb8:salt"))
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com", "hash": "`+hash+`"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	if job.Total != 1 || job.Processed != 1 {
+		t.Errorf("job total/processed = %d/%d, want 1/1", job.Total, job.Processed)
+	}
+
+	user, ok := h.CSCart.UserByEmail("maria.valencia@example.com")
+	if !ok {
+		t.Fatal("user was not created on CS-Cart")
+	}
+	if user.Firstname != "Maria" || user.Lastname != "Valencia" || user.Status != "A" || user.UserType != "C" || user.CompanyId != "0" {
+		t.Errorf("CS-Cart user = %+v", user)
+	}
+	if user.Hash != "salt" {
+		t.Errorf("CS-Cart password = %q, want %q", user.Hash, "salt")
+	}
+
+	savedHash, err := h.Store.GetHash("maria.valencia@example.com")
+	if err != nil || savedHash.Hash != hash {
+		t.Errorf("saved hash = %+v, %v, want %s", savedHash, err, hash)
+	}
+
+	profiles := h.CSCart.Profiles("maria.valencia@example.com")
+	if len(profiles) != 2 {
+		t.Fatalf("CS-Cart profiles = %+v, want 2", profiles)
+	}
+	for _, magentoId := range []int{10, 11} {
+		address := mustAddress(t, h, "maria.valencia@example.com"+strconv.Itoa(magentoId))
+		profile, ok := profiles[address.GamaId]
+		if !ok || !address.Result || address.MagentoId != magentoId {
+			t.Fatalf("address record of %d = %+v, profiles = %+v", magentoId, address, profiles)
+		}
+		if profile.Main != (magentoId == 10) {
+			t.Errorf("profile of address %d main = %v", magentoId, profile.Main)
+		}
+		if profile.Profile.ProfileName != magentoId || profile.Profile.Sstate != 1 || profile.Profile.Scountry != "MX" || profile.Profile.Fields["59"] != "12" || profile.Profile.Fields["58"] != "12" {
+			t.Errorf("profile of address %d = %+v", magentoId, profile.Profile)
+		}
+	}
+	if profiles[mustAddress(t, h, "maria.valencia@example.com10").GamaId].Profile.Saddress != "Av. Álvaro Obregón" {
+		t.Errorf("main profile was not updated with the default shipping address")
+	}
+}
+
+func mustAddress(t *testing.T, h *harness, key string) services.AddressProfile {
+	t.Helper()
+	address, err := h.Store.GetAddress(key)
+	if err != nil {
+		t.Fatalf("GetAddress(%s): %v", key, err)
+	}
+	return address
+}
+
+func TestSyncUsersExistingUserWithoutForce(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("test@reynolds.com"))
+	h.CSCart.AddUser(services.GamaUser{Email: "test@reynolds.com", Firstname: "Old", Status: "A", UserType: "C"})
+
+	job := h.post(t, `{"users": [{"email": "test@reynolds.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "test@reynolds.com",
+		ResponseCode: 3,
+		Reason:       "user already exists on GAMA, try send force param equals to 'true' (string)",
+	})
+	if user, _ := h.CSCart.UserByEmail("test@reynolds.com"); user.Firstname != "Old" {
+		t.Errorf("CS-Cart user was updated without force: %+v", user)
+	}
+	if migrated, _ := h.Store.GetMigratedUser("test@reynolds.com"); migrated.ResponseCode != 3 {
+		t.Errorf("saved result = %+v, want response code 3", migrated)
+	}
+}
+
+func TestSyncUsersForceUpdatesExistingUser(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("test@reynolds.com")
+	addresses := []services.Address{magentoAddress(20, "Insurgentes Sur")}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+	h.CSCart.AddUser(services.GamaUser{Email: "test@reynolds.com", Firstname: "Old", Status: "A", UserType: "C"})
+
+	job := h.post(t, `{"force": true, "users": [{"email": "test@reynolds.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "test@reynolds.com", ResponseCode: 2})
+	user, _ := h.CSCart.UserByEmail("test@reynolds.com")
+	if user.Firstname != "Maria" || user.UserType != "C" {
+		t.Errorf("CS-Cart user = %+v, want it updated", user)
+	}
+	if len(h.CSCart.RequestsTo(http.MethodPost, "api/users")) != 0 {
+		t.Error("an existing user was created again")
+	}
+	if address := mustAddress(t, h, "test@reynolds.com20"); !address.Result || address.GamaId == 0 {
+		t.Errorf("address record = %+v", address)
+	}
+
+	diff := job.Results[0].Diff
+	if diff == nil || len(diff.NewProfiles) != 1 || diff.NewProfiles[0] != 20 {
+		t.Fatalf("diff = %+v, want the address 20 as new profile", diff)
+	}
+	expected := []services.FieldChange{
+		{Field: "firstname", Old: "Old", New: "Maria"},
+		{Field: "lastname", Old: "", New: "Valencia"},
+	}
+	if len(diff.User) != len(expected) || diff.User[0] != expected[0] || diff.User[1] != expected[1] {
+		t.Errorf("user diff = %+v, want %+v", diff.User, expected)
+	}
+}
+
+func TestSyncUsersForceSkipsUnchangedUser(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("test@reynolds.com")
+	addresses := []services.Address{magentoAddress(20, "Insurgentes Sur")}
+	customer.Addresses = &addresses
+	customer = h.Magento.AddCustomer(customer)
+	h.CSCart.AddUser(services.GamaUser{Email: "test@reynolds.com", Firstname: "Old", Status: "A", UserType: "C"})
+
+	h.post(t, `{"force": true, "users": [{"email": "test@reynolds.com"}]}`)
+	job := h.post(t, `{"force": true, "users": [{"email": "test@reynolds.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "test@reynolds.com", ResponseCode: 4})
+	if len(h.CSCart.RequestsTo(http.MethodPut, "api/users/1")) != 1 || len(h.CSCart.RequestsTo(http.MethodPut, "api/profiles/1")) != 0 {
+		t.Errorf("unchanged user was sent again: %+v", h.CSCart.Requests())
+	}
+
+	// a new postcode on magento only sends the profile
+	changed := []services.Address{magentoAddress(20, "Insurgentes Sur")}
+	changed[0].Postcode = "03100"
+	customer.Addresses = &changed
+	h.Magento.UpdateCustomer(customer)
+	job = h.post(t, `{"force": true, "users": [{"email": "test@reynolds.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "test@reynolds.com", ResponseCode: 2})
+	diff := job.Results[0].Diff
+	if diff == nil || len(diff.User) != 0 || len(diff.Profiles[20]) != 2 {
+		t.Fatalf("diff = %+v, want only the zipcodes of the profile 20", diff)
+	}
+	if len(h.CSCart.RequestsTo(http.MethodPut, "api/users/1")) != 1 || len(h.CSCart.RequestsTo(http.MethodPut, "api/profiles/1")) != 1 {
+		t.Errorf("requests = %+v, want only the profile update", h.CSCart.Requests())
+	}
+}
+
+func TestSyncUsersNotFoundOnMagento(t *testing.T) {
+	h := newHarness(t)
+
+	job := h.post(t, `{"users": [{"email": "eggcontinued@chewydonut.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "eggcontinued@chewydonut.com",
+		ResponseCode: 3,
+		Reason:       "Not user found on magento databse",
+	})
+	if len(h.CSCart.Requests()) != 0 {
+		t.Errorf("CS-Cart was called for a user missing on magento: %+v", h.CSCart.Requests())
+	}
+}
+
+func TestSyncUsersSkipsMigratedUsers(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("zahitrios@example.com"))
+
+	h.post(t, `{"users": [{"email": "zahitrios@example.com"}]}`)
+	requests := len(h.CSCart.Requests())
+	job := h.post(t, `{"users": [{"email": "zahitrios@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "zahitrios@example.com", ResponseCode: 1})
+	if len(h.CSCart.Requests()) != requests {
+		t.Errorf("CS-Cart was called again for a migrated user")
+	}
+}
+
+func TestSyncUsersMagentoErrorFailsOnlyTheUser(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("zahitrios@example.com"))
+	h.Magento.AddCustomer(magentoCustomer("maria.valencia@example.com"))
+	h.Magento.FailAllAttempts(http.MethodGet, "customers/search", http.StatusInternalServerError)
+
+	job := h.post(t, `{"users": [{"email": "zahitrios@example.com"}, {"email": "maria.valencia@example.com"}]}`)
+
+	if job.Status != services.JobFinished || job.Processed != 2 || len(job.Results) != 2 {
+		t.Fatalf("job = %+v, want it finished with the result of both users", job)
+	}
+	if job.Results[0].Email != "zahitrios@example.com" || job.Results[0].ResponseCode != 3 || job.Results[0].Reason == "" {
+		t.Errorf("result of the user magento failed on = %+v, want an error", job.Results[0])
+	}
+	if job.Results[1].Email != "maria.valencia@example.com" || job.Results[1].ResponseCode != 1 {
+		t.Errorf("result of the next user = %+v, want it created", job.Results[1])
+	}
+	if _, ok := h.CSCart.UserByEmail("zahitrios@example.com"); ok {
+		t.Error("user magento failed on was created on CS-Cart")
+	}
+	if _, ok := h.CSCart.UserByEmail("maria.valencia@example.com"); !ok {
+		t.Error("next user was not created on CS-Cart")
+	}
+}
+
+func TestSyncUsersRetriesMagentoAndGamaErrors(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("zahitrios@example.com"))
+	h.Magento.Fail(http.MethodGet, "customers/search", http.StatusBadGateway)
+	h.CSCart.Fail(http.MethodGet, "api/users", http.StatusServiceUnavailable, http.StatusGatewayTimeout)
+
+	job := h.post(t, `{"users": [{"email": "zahitrios@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "zahitrios@example.com", ResponseCode: 1})
+	if _, ok := h.CSCart.UserByEmail("zahitrios@example.com"); !ok {
+		t.Error("user was not created on CS-Cart after the retries")
+	}
+}
+
+func TestSyncUsersWaitsRetryAfterOfThrottledRequests(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("zahitrios@example.com"))
+	h.CSCart.Throttle(http.MethodPost, "api/users", "1")
+
+	start := time.Now()
+	job := h.post(t, `{"users": [{"email": "zahitrios@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "zahitrios@example.com", ResponseCode: 1})
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("the throttled request was retried after %s, want the Retry-After of 1s", elapsed)
+	}
+	if posts := h.CSCart.RequestsTo(http.MethodPost, "api/users"); len(posts) != 2 {
+		t.Errorf("POST api/users was sent %d times, want 2", len(posts))
+	}
+}
+
+func TestSyncUsersDoesNotWaitRetriesPastTheDeadline(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("zahitrios@example.com"))
+	h.CSCart.Throttle(http.MethodPost, "api/users", "20")
+	job := services.Job{
+		Id:      "deadline",
+		Type:    services.UsersJob,
+		Status:  services.JobQueued,
+		Request: services.BodyRequest{Users: []services.User{{Email: "zahitrios@example.com"}}},
+		Results: []services.BodyResult{},
+	}
+	if err := h.Store.SaveJob(job); err != nil {
+		t.Fatalf("SaveJob: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := services.ProcessJob(ctx, job.Id); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the job took %s, want the throttled request not retried past the deadline", elapsed)
+	}
+	job, _ = h.Store.GetJob(job.Id)
+	if len(job.Results) != 1 || job.Results[0].ResponseCode != 3 {
+		t.Errorf("results = %+v, want the throttled user failed", job.Results)
+	}
+	if posts := h.CSCart.RequestsTo(http.MethodPost, "api/users"); len(posts) != 1 {
+		t.Errorf("POST api/users was sent %d times, want 1", len(posts))
+	}
+}
+
+func TestSyncUsersFailsWhenGamaReturnsNoUserId(t *testing.T) {
+	h := newHarness(t)
+	h.Magento.AddCustomer(magentoCustomer("zahitrios@example.com"))
+	h.CSCart.Fail(http.MethodPost, "api/users", http.StatusOK)
+
+	job := h.post(t, `{"users": [{"email": "zahitrios@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "zahitrios@example.com",
+		ResponseCode: 3,
+		Reason:       "gama returned no user_id creating the user zahitrios@example.com",
+	})
+}
+
+func TestSyncUsersRetriedCreationDoesNotDuplicateUser(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón")}
+	customer.Addresses = &addresses
+	customer.DefaultShipping = 10
+	h.Magento.AddCustomer(customer)
+	// GAMA creates the user but the gateway answers 504
+	h.CSCart.FailAfterHandling(http.MethodPost, "api/users", http.StatusGatewayTimeout)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	if posts := h.CSCart.RequestsTo(http.MethodPost, "api/users"); len(posts) != 1 || len(h.CSCart.Users()) != 1 {
+		t.Fatalf("POST api/users sent %d times, users = %+v, want a single user", len(posts), h.CSCart.Users())
+	}
+	profiles := h.CSCart.Profiles("maria.valencia@example.com")
+	address := mustAddress(t, h, "maria.valencia@example.com10")
+	if len(profiles) != 1 || !profiles[address.GamaId].Main || profiles[address.GamaId].Profile.Saddress != "Av. Álvaro Obregón" {
+		t.Errorf("CS-Cart profiles = %+v, want the main profile with the default shipping address", profiles)
+	}
+}
+
+func TestSyncUsersSendsGamaRequestsAtTheRateLimit(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("gamaRateLimit", "20")
+	t.Setenv("gamaRateBurst", "1")
+	services.SetCSCartClient(services.NewCSCartClient(h.CSCart.BaseUrl(), fakes.CSCartUser, fakes.CSCartPassword, nil))
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		h.Magento.AddCustomer(magentoCustomer(email))
+	}
+
+	start := time.Now()
+	job := h.post(t, `{"users": [{"email": "a@example.com"}, {"email": "b@example.com"}, {"email": "c@example.com"}]}`)
+	elapsed := time.Since(start)
+
+	if job.Status != services.JobFinished || len(job.Results) != 3 {
+		t.Fatalf("job = %+v", job)
+	}
+	// a token every 50ms after the first request
+	requests := len(h.CSCart.Requests())
+	if want := time.Duration(requests-1) * 50 * time.Millisecond; elapsed < want {
+		t.Errorf("%d requests sent in %s, want at least %s", requests, elapsed, want)
+	}
+}
+
+func TestSyncUsersAllPagesThroughMagento(t *testing.T) {
+	h := newHarness(t)
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		h.Magento.AddCustomer(magentoCustomer(email))
+	}
+	h.CSCart.AddUser(services.GamaUser{Email: "c@example.com", Status: "A", UserType: "C"})
+
+	job := h.post(t, `{"all": true, "page_size": 2}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "c@example.com",
+		ResponseCode: 3,
+		Reason:       "user already exists on GAMA, try send force param equals to 'true' (string)",
+	})
+	checkpoint := job.Checkpoint
+	if checkpoint == nil || !checkpoint.Finished || checkpoint.Processed != 5 || checkpoint.Created != 4 || checkpoint.Failed != 1 || checkpoint.LastPage != 3 || checkpoint.LastEntityId != 5 {
+		t.Errorf("checkpoint = %+v", checkpoint)
+	}
+	if len(h.CSCart.Users()) != 5 {
+		t.Errorf("CS-Cart users = %+v, want 5", h.CSCart.Users())
+	}
+}
+
+func TestSyncUsersAllResumesAfterTheLastCustomer(t *testing.T) {
+	h := newHarness(t)
+	// customer 1 was deleted on magento after the previous invocation migrated 1 and 2
+	for id, email := range map[int]string{2: "b@example.com", 3: "c@example.com", 4: "d@example.com", 5: "e@example.com"} {
+		customer := magentoCustomer(email)
+		customer.Id = id
+		h.Magento.AddCustomer(customer)
+	}
+	checkpoint := services.Checkpoint{Id: services.UsersCheckpointId, PageSize: 2, LastPage: 1, LastEntityId: 2, Processed: 2, Created: 2}
+	if err := h.Store.SaveCheckpoint(checkpoint); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	job := h.post(t, `{"all": true, "page_size": 2}`)
+
+	if job.Checkpoint == nil || !job.Checkpoint.Finished || job.Checkpoint.Processed != 5 || job.Checkpoint.Created != 5 || job.Checkpoint.LastEntityId != 5 {
+		t.Errorf("checkpoint = %+v", job.Checkpoint)
+	}
+	for _, email := range []string{"c@example.com", "d@example.com", "e@example.com"} {
+		if _, ok := h.CSCart.UserByEmail(email); !ok {
+			t.Errorf("%s was not migrated", email)
+		}
+	}
+	if _, ok := h.CSCart.UserByEmail("b@example.com"); ok {
+		t.Error("b@example.com was migrated again")
+	}
+}
+
+func TestSyncUsersRejectsInvalidBody(t *testing.T) {
+	newHarness(t)
+
+	for _, body := range []string{`{"users": `, `{"users": []}`} {
+		response, err := SyncUsers(events.APIGatewayProxyRequest{Body: body})
+		if err != nil || response.StatusCode != http.StatusBadRequest {
+			t.Errorf("SyncUsers(%s) = %d, %v, want %d", body, response.StatusCode, err, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSyncUsersDryRunWritesNothing(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), magentoAddress(11, "Calle Orizaba")}
+	customer.Addresses = &addresses
+	customer.DefaultShipping = 10
+	h.Magento.AddCustomer(customer)
+	hash := base64.StdEncoding.EncodeToString([]byte("a665a45920422f9d417e4867efdc4fb8:salt"))
+
+	job := h.post(t, `{"dry_run": true, "users": [{"email": "maria.valencia@example.com", "hash": "`+hash+`"}]}`)
+
+	if job.Status != services.JobFinished || len(job.Results) != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	result := job.Results[0]
+	if result.ResponseCode != 1 || result.Preview == nil {
+		t.Fatalf("result = %+v, want a preview of an insert", result)
+	}
+	preview := result.Preview
+	if preview.Mode != "insert" || preview.User.Email != "maria.valencia@example.com" || preview.User.UserType != "C" || preview.User.Hash != "[hidden]" {
+		t.Errorf("user preview = %+v", preview)
+	}
+	modes := map[int]string{}
+	for _, profile := range preview.Profiles {
+		modes[profile.Profile.ProfileName] = profile.Mode
+	}
+	if modes[10] != "update" || modes[11] != "insert" || len(modes) != 2 {
+		t.Errorf("profile modes = %+v, want the default shipping address updating the main profile", modes)
+	}
+
+	for _, request := range h.CSCart.Requests() {
+		if request.Method != http.MethodGet {
+			t.Errorf("dry run sent %s %s to CS-Cart", request.Method, request.Path)
+		}
+	}
+	if migrated, _ := h.Store.GetMigratedUser("maria.valencia@example.com"); migrated.Email != "" {
+		t.Errorf("dry run saved the result %+v", migrated)
+	}
+	if _, err := h.Store.GetHash("maria.valencia@example.com"); err != services.ErrNotFound {
+		t.Errorf("dry run saved the hash, err = %v", err)
+	}
+}
+
+func TestSyncUsersAssignsConfiguredUsergroups(t *testing.T) {
+	h := newHarness(t)
+	wholesale := h.CSCart.AddUsergroup(services.GamaUsergroup{Usergroup: "Mayoreo", Type: "C", Status: "A"})
+	t.Setenv("gamaUsergroups", "2:"+strconv.Itoa(wholesale))
+	customer := magentoCustomer("mayoreo@example.com")
+	customer.GroupId = 2
+	h.Magento.AddCustomer(customer)
+	h.Magento.AddCustomer(magentoCustomer("general@example.com"))
+
+	job := h.post(t, `{"users": [{"email": "mayoreo@example.com"}, {"email": "general@example.com"}]}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Email: "mayoreo@example.com", ResponseCode: 1},
+		services.BodyResult{Email: "general@example.com", ResponseCode: 1},
+	)
+	user, _ := h.CSCart.UserByEmail("mayoreo@example.com")
+	if usergroups := h.CSCart.UserUsergroups(user.Id); len(usergroups) != 1 || usergroups[wholesale] != "A" {
+		t.Errorf("usergroups of %s = %+v, want the wholesale usergroup", user.Email, usergroups)
+	}
+	general, _ := h.CSCart.UserByEmail("general@example.com")
+	if usergroups := h.CSCart.UserUsergroups(general.Id); len(usergroups) != 0 {
+		t.Errorf("usergroups of %s = %+v, want none", general.Email, usergroups)
+	}
+}
+
+func TestSyncUsersMigratesUnmappedGroupsWithoutUsergroup(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("vip@example.com")
+	customer.GroupId = 4
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "vip@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "vip@example.com",
+		ResponseCode: 1,
+		Reason:       "migrated without usergroup, the magento customer group 4 has no GAMA usergroup",
+	})
+	users := h.CSCart.Users()
+	if len(users) != 1 || len(h.CSCart.UserUsergroups(users[0].Id)) != 0 {
+		t.Errorf("CS-Cart users = %+v, want the user created without usergroup", users)
+	}
+}
+
+func TestSyncUsersFailsForUnmappedGroupsWhenRequired(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("gamaRequireUsergroups", "true")
+	customer := magentoCustomer("vip@example.com")
+	customer.GroupId = 4
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "vip@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "vip@example.com",
+		ResponseCode: 3,
+		Reason:       "magento customer group 4 has no GAMA usergroup, map it on the usergroups of the stage or allow creating them",
+	})
+	if len(h.CSCart.Users()) != 0 {
+		t.Errorf("CS-Cart users = %+v, want the user not created", h.CSCart.Users())
+	}
+}
+
+func TestSyncUsersCreatesMissingUsergroups(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("gamaCreateUsergroups", "true")
+	h.Magento.AddCustomerGroup(services.MagentoCustomerGroup{Id: 4, Code: "VIP"})
+	for _, email := range []string{"vip@example.com", "vip2@example.com"} {
+		customer := magentoCustomer(email)
+		customer.GroupId = 4
+		h.Magento.AddCustomer(customer)
+	}
+
+	job := h.post(t, `{"users": [{"email": "vip@example.com"}, {"email": "vip2@example.com"}]}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Email: "vip@example.com", ResponseCode: 1},
+		services.BodyResult{Email: "vip2@example.com", ResponseCode: 1},
+	)
+	usergroups := h.CSCart.Usergroups()
+	if len(usergroups) != 1 || usergroups[1] != (services.GamaUsergroup{Usergroup: "VIP", Type: "C", Status: "A"}) {
+		t.Fatalf("CS-Cart usergroups = %+v, want one VIP usergroup", usergroups)
+	}
+	for _, user := range h.CSCart.Users() {
+		if h.CSCart.UserUsergroups(user.Id)[1] != "A" {
+			t.Errorf("user %s is not on the VIP usergroup", user.Email)
+		}
+	}
+	if mapping, err := h.Store.GetUsergroup("4"); err != nil || !mapping.Result || mapping.GamaId != 1 || mapping.Code != "VIP" {
+		t.Errorf("usergroup mapping = %+v, %v", mapping, err)
+	}
+}
+
+func TestSyncUsersForceMovesUserToItsUsergroup(t *testing.T) {
+	h := newHarness(t)
+	wholesale := h.CSCart.AddUsergroup(services.GamaUsergroup{Usergroup: "Mayoreo", Type: "C", Status: "A"})
+	vip := h.CSCart.AddUsergroup(services.GamaUsergroup{Usergroup: "VIP", Type: "C", Status: "A"})
+	t.Setenv("gamaUsergroups", "2:"+strconv.Itoa(wholesale)+",4:"+strconv.Itoa(vip))
+	customer := magentoCustomer("test@reynolds.com")
+	customer.GroupId = 4
+	h.Magento.AddCustomer(customer)
+	user := h.CSCart.AddUser(services.GamaUser{Email: "test@reynolds.com", Firstname: "Maria", Lastname: "Valencia", Status: "A", UserType: "C"})
+	h.CSCart.SetUserUsergroup(user.Id, wholesale, "A")
+
+	job := h.post(t, `{"force": true, "users": [{"email": "test@reynolds.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "test@reynolds.com", ResponseCode: 2})
+	expected := services.FieldChange{Field: "usergroup_id", Old: strconv.Itoa(wholesale), New: strconv.Itoa(vip)}
+	if diff := job.Results[0].Diff; diff == nil || len(diff.User) != 0 || diff.Usergroup == nil || *diff.Usergroup != expected {
+		t.Errorf("diff = %+v, want only the usergroup %+v", diff, expected)
+	}
+	if usergroups := h.CSCart.UserUsergroups(user.Id); usergroups[vip] != "A" {
+		t.Errorf("usergroups = %+v, want the VIP usergroup active", usergroups)
+	}
+
+	job = h.post(t, `{"force": true, "users": [{"email": "test@reynolds.com"}]}`)
+	fakes.AssertResults(t, job, services.BodyResult{Email: "test@reynolds.com", ResponseCode: 4})
+}
+
+func TestSyncUsersResolvesRegionsWithGamaStates(t *testing.T) {
+	h := newHarness(t)
+	texas := h.CSCart.AddState(services.GamaState{CountryCode: "US", Code: "TX", State: "Texas"})
+	h.CSCart.AddState(services.GamaState{CountryCode: "MX", Code: "TX", State: "Tlaxcala"})
+	customer := magentoCustomer("maria.valencia@example.com")
+	address := magentoAddress(10, "Main St")
+	address.CountryId = "US"
+	address.Region = services.Region{RegionCode: "TX", Region: "Texas", RegionId: 57}
+	addresses := []services.Address{address, magentoAddress(11, "Calle Orizaba")}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	states := map[int]int{}
+	for _, profile := range h.CSCart.Profiles("maria.valencia@example.com") {
+		states[profile.Profile.ProfileName] = profile.Profile.Sstate
+	}
+	if states[10] != texas.StateId || states[11] != 1 {
+		t.Errorf("profile states = %+v, want Texas from the GAMA states and 1 from the regions file", states)
+	}
+	if requests := h.CSCart.RequestsTo(http.MethodGet, "api/states"); len(requests) != 1 {
+		t.Errorf("states requests = %+v, want only the states of US", requests)
+	}
+
+	other := magentoCustomer("zahit.rios@example.com")
+	otherAddresses := []services.Address{address}
+	other.Addresses = &otherAddresses
+	h.Magento.AddCustomer(other)
+	job = h.post(t, `{"users": [{"email": "zahit.rios@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "zahit.rios@example.com", ResponseCode: 1})
+	if requests := h.CSCart.RequestsTo(http.MethodGet, "api/states"); len(requests) != 1 {
+		t.Errorf("states requests = %+v, want the states of US read once for both users", requests)
+	}
+}
+
+func TestSyncUsersFailsForUnmappedRegions(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	address := magentoAddress(10, "Main St")
+	address.CountryId = "US"
+	address.Region = services.Region{RegionCode: "TX", Region: "Texas", RegionId: 57}
+	addresses := []services.Address{magentoAddress(11, "Calle Orizaba"), address}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "maria.valencia@example.com",
+		ResponseCode: 3,
+		Reason:       "address 10: region TX (57) of US has no GAMA state",
+	})
+	if len(h.CSCart.Users()) != 0 {
+		t.Errorf("CS-Cart users = %+v, want the user not created", h.CSCart.Users())
+	}
+}
+
+func TestSyncUsersUsesConfiguredProfileFields(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("profileFields", "external_number:101:102,suburb:103")
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón")}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	expected := services.Fields{"101": "12", "102": "12", "103": "Roma Norte"}
+	for _, profile := range h.CSCart.Profiles("maria.valencia@example.com") {
+		if profile.Profile.ProfileName != 10 {
+			continue
+		}
+		if len(profile.Profile.Fields) != len(expected) {
+			t.Errorf("profile fields = %+v, want %+v", profile.Profile.Fields, expected)
+		}
+		for id, value := range expected {
+			if profile.Profile.Fields[id] != value {
+				t.Errorf("profile fields = %+v, want %+v", profile.Profile.Fields, expected)
+			}
+		}
+	}
+}
+
+func TestSyncUsersDiscoversProfileFields(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("profileFields", "external_number,internal_number:0:60,receptor_details:70:71")
+	t.Setenv("discoverProfileFields", "true")
+	h.CSCart.AddProfileField(services.GamaProfileField{FieldId: 36, FieldName: "external_number", Section: "S"})
+	h.CSCart.AddProfileField(services.GamaProfileField{FieldId: 37, FieldName: "external_number", Section: "B"})
+	h.CSCart.AddProfileField(services.GamaProfileField{FieldId: 38, FieldName: "internal_number", Section: "S"})
+	h.CSCart.AddProfileField(services.GamaProfileField{FieldId: 39, FieldName: "internal_number", Section: "B"})
+	h.CSCart.AddProfileField(services.GamaProfileField{FieldId: 40, FieldName: "receptor_details", Section: "S"})
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), magentoAddress(11, "Calle Orizaba")}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	// the configured ids are kept and the missing ones are discovered by field name
+	expected := services.Fields{"36": "12", "37": "12", "38": "3", "60": "3", "70": "Blue door", "71": "Blue door"}
+	for _, profile := range h.CSCart.Profiles("maria.valencia@example.com") {
+		if profile.Main {
+			continue
+		}
+		for id, value := range expected {
+			if profile.Profile.Fields[id] != value || len(profile.Profile.Fields) != len(expected) {
+				t.Errorf("profile %d fields = %+v, want %+v", profile.Profile.ProfileName, profile.Profile.Fields, expected)
+				break
+			}
+		}
+	}
+}
+
+func TestSyncUsersRejectsInvalidProfileFields(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("profileFields", "external_number:fifty")
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón")}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{
+		Email:        "maria.valencia@example.com",
+		ResponseCode: 3,
+		Reason:       "invalid profile fields mapping external_number:fifty, use attribute_code:shipping_field_id:billing_field_id",
+	})
+}
+
+func TestSyncUsersAddressWithoutStreet(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	address := magentoAddress(10, "")
+	address.Street = []string{}
+	addresses := []services.Address{address}
+	customer.Addresses = &addresses
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	profile := h.CSCart.Profiles("maria.valencia@example.com")[mustAddress(t, h, "maria.valencia@example.com10").GamaId]
+	if profile.Profile.Saddress != "" || profile.Profile.Baddress != "" {
+		t.Errorf("profile of the address without street = %+v, want empty addresses", profile.Profile)
+	}
+}
+
+func TestSyncUsersUsesDefaultBillingAddress(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	billing := magentoAddress(11, "Calle Orizaba")
+	billing.Firstname = "Facturación"
+	billing.Attributes[0].Value = "99"
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), billing, magentoAddress(12, "Calle Durango")}
+	customer.Addresses = &addresses
+	customer.DefaultShipping = 10
+	customer.DefaultBilling = 11
+	h.Magento.AddCustomer(customer)
+
+	job := h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 1})
+	profiles := h.CSCart.Profiles("maria.valencia@example.com")
+	streets := map[int]string{10: "Av. Álvaro Obregón", 11: "Calle Orizaba", 12: "Calle Durango"}
+	for magentoId, street := range streets {
+		profile := profiles[mustAddress(t, h, "maria.valencia@example.com"+strconv.Itoa(magentoId)).GamaId]
+		if profile.Profile.Saddress != street || profile.Profile.Baddress != "Calle Orizaba" || profile.Profile.Bfirstname != "Facturación" {
+			t.Errorf("profile of address %d = %+v, want its shipping side and the billing side of the address 11", magentoId, profile.Profile)
+		}
+		shippingNumber := "12"
+		if magentoId == 11 {
+			shippingNumber = "99"
+		}
+		if profile.Profile.Fields["59"] != shippingNumber || profile.Profile.Fields["58"] != "99" {
+			t.Errorf("profile of address %d fields = %+v, want the billing fields of the address 11", magentoId, profile.Profile.Fields)
+		}
+		if profile.Main != (magentoId == 10) || (profile.Profile.ProfileType == "P") != (magentoId == 10) {
+			t.Errorf("profile of address %d main = %v, type = %s", magentoId, profile.Main, profile.Profile.ProfileType)
+		}
+	}
+}
+
+func TestSyncUsersMainProfileFollowsDefaultAddresses(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), magentoAddress(11, "Calle Orizaba")}
+	customer.Addresses = &addresses
+	customer.DefaultBilling = 11 // without default shipping the billing address is the main one
+	customer = h.Magento.AddCustomer(customer)
+
+	h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+	main := mustAddress(t, h, "maria.valencia@example.com11").GamaId
+	if profile := h.CSCart.Profiles("maria.valencia@example.com")[main]; !profile.Main || profile.Profile.Saddress != "Calle Orizaba" {
+		t.Fatalf("main profile = %+v, want the default billing address", profile)
+	}
+
+	customer.DefaultShipping = 10
+	h.Magento.UpdateCustomer(customer)
+	job := h.post(t, `{"force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 2})
+	profiles := h.CSCart.Profiles("maria.valencia@example.com")
+	if !profiles[mustAddress(t, h, "maria.valencia@example.com10").GamaId].Main || profiles[main].Main {
+		t.Errorf("profiles = %+v, want the default shipping address as main profile", profiles)
+	}
+}
+
+func TestSyncUsersDeletesProfilesOfDeletedAddresses(t *testing.T) {
+	h := newHarness(t)
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), magentoAddress(11, "Calle Orizaba"), magentoAddress(12, "Calle Durango")}
+	customer.Addresses = &addresses
+	customer.DefaultShipping = 10
+	customer = h.Magento.AddCustomer(customer)
+	h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+	orphan := mustAddress(t, h, "maria.valencia@example.com11")
+
+	// the address 11 and the default shipping address are deleted on magento
+	remaining := []services.Address{magentoAddress(12, "Calle Durango")}
+	customer.Addresses = &remaining
+	customer.DefaultShipping = 0
+	h.Magento.UpdateCustomer(customer)
+	job := h.post(t, `{"force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 2})
+	if diff := job.Results[0].Diff; diff == nil || len(diff.OrphanProfiles) != 1 || diff.OrphanProfiles[0] != 11 {
+		t.Errorf("diff = %+v, want the address 11 as orphan profile", diff)
+	}
+	profiles := h.CSCart.Profiles("maria.valencia@example.com")
+	if _, ok := profiles[orphan.GamaId]; ok || len(profiles) != 2 {
+		t.Errorf("profiles = %+v, want the profile of the address 11 deleted and the main profile kept", profiles)
+	}
+	if address := mustAddress(t, h, "maria.valencia@example.com11"); address.Result || !address.Orphaned {
+		t.Errorf("address record = %+v, want it orphaned", address)
+	}
+
+	job = h.post(t, `{"force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 4})
+}
+
+func TestSyncUsersFlagsProfilesOfDeletedAddresses(t *testing.T) {
+	h := newHarness(t)
+	t.Setenv("orphanProfiles", services.FlagOrphanProfiles)
+	customer := magentoCustomer("maria.valencia@example.com")
+	addresses := []services.Address{magentoAddress(10, "Av. Álvaro Obregón"), magentoAddress(11, "Calle Orizaba")}
+	customer.Addresses = &addresses
+	customer = h.Magento.AddCustomer(customer)
+	h.post(t, `{"users": [{"email": "maria.valencia@example.com"}]}`)
+
+	remaining := []services.Address{magentoAddress(10, "Av. Álvaro Obregón")}
+	customer.Addresses = &remaining
+	h.Magento.UpdateCustomer(customer)
+	job := h.post(t, `{"dry_run": true, "force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+	if preview := job.Results[0].Preview; preview == nil || len(preview.Profiles) != 1 || preview.Profiles[0].Mode != services.FlagOrphanProfiles {
+		t.Errorf("preview = %+v, want the profile of the address 11 flagged", preview)
+	}
+
+	job = h.post(t, `{"force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 2})
+	address := mustAddress(t, h, "maria.valencia@example.com11")
+	if !address.Result || !address.Orphaned {
+		t.Errorf("address record = %+v, want it flagged", address)
+	}
+	if _, ok := h.CSCart.Profiles("maria.valencia@example.com")[address.GamaId]; !ok {
+		t.Error("the flagged profile was deleted from CS-Cart")
+	}
+	if len(h.CSCart.RequestsTo(http.MethodDelete, "api/profiles/1")) != 0 {
+		t.Error("a profile was deleted with the flag mode")
+	}
+
+	job = h.post(t, `{"force": true, "users": [{"email": "maria.valencia@example.com"}]}`)
+	fakes.AssertResults(t, job, services.BodyResult{Email: "maria.valencia@example.com", ResponseCode: 4})
+}
+
+func TestSyncUsersReportsInvalidHashesWithoutStoppingTheBatch(t *testing.T) {
+	h := newHarness(t)
+	for _, email := range []string{"not-base64@example.com", "no-hex@example.com", "bad-version@example.com", "valid@example.com"} {
+		h.Magento.AddCustomer(magentoCustomer(email))
+	}
+	noHex := base64.StdEncoding.EncodeToString([]byte("salt"))
+	badVersion := base64.StdEncoding.EncodeToString([]byte("a665a45920422f9d417e4867efdc4fb8:salt:7"))
+	valid := base64.StdEncoding.EncodeToString([]byte("a665a45920422f9d417e4867efdc4fb8:salt:0"))
+
+	job := h.post(t, `{"users": [
+		{"email": "not-base64@example.com", "hash": "a665:salt"},
+		{"email": "no-hex@example.com", "hash": "`+noHex+`"},
+		{"email": "bad-version@example.com", "hash": "`+badVersion+`"},
+		{"email": "valid@example.com", "hash": "`+valid+`"}
+	]}`)
+
+	fakes.AssertResults(t, job,
+		services.BodyResult{Email: "not-base64@example.com", ResponseCode: 3, Reason: "invalid password hash, it is not base64: illegal base64 data at input byte 4"},
+		services.BodyResult{Email: "no-hex@example.com", ResponseCode: 3, Reason: "invalid password hash, the hash is not the hex of a md5 hash"},
+		services.BodyResult{Email: "bad-version@example.com", ResponseCode: 3, Reason: "invalid password hash, version 7 is not supported"},
+		services.BodyResult{Email: "valid@example.com", ResponseCode: 1},
+	)
+	for index, code := range []string{"invalid_hash_encoding", "invalid_hash_format", "invalid_hash_format", ""} {
+		if job.Results[index].ErrorCode != code {
+			t.Errorf("result %d error code = %q, want %q", index, job.Results[index].ErrorCode, code)
+		}
+	}
+
+	if users := h.CSCart.Users(); len(users) != 1 || users[0].Email != "valid@example.com" || users[0].Hash != "salt" {
+		t.Errorf("CS-Cart users = %+v, want only the user with a valid hash", users)
+	}
+	if _, err := h.Store.GetHash("no-hex@example.com"); err != services.ErrNotFound {
+		t.Errorf("GetHash of an invalid hash err = %v, want ErrNotFound", err)
+	}
+
+	job = h.post(t, `{"dry_run": true, "users": [{"email": "no-hex@example.com", "hash": "`+noHex+`"}]}`)
+	if len(job.Results) != 1 || job.Results[0].ResponseCode != 3 || job.Results[0].ErrorCode != "invalid_hash_format" {
+		t.Errorf("dry run results = %+v, want the invalid hash reported", job.Results)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+type VerifyRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// VerifyPassword is called by GAMA when a migrated user logs in without a GAMA password, it validates the
+// password against the migrated magento hash and signals GAMA to rehash it
+func VerifyPassword(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	verifyRequest := VerifyRequest{}
+
+	err := json.Unmarshal([]byte(request.Body), &verifyRequest)
+	if err != nil {
+		fmt.Println("Error destructuring the body of the request on VerifyPassword function : ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusBadRequest}, nil
+	}
+
+	if verifyRequest.Email == "" {
+		return events.APIGatewayProxyResponse{Body: "email is empty", StatusCode: http.StatusBadRequest}, nil
+	}
+
+	verification, err := services.VerifyMagentoPassword(verifyRequest.Email, verifyRequest.Password)
+	if err == services.ErrNotFound {
+		return events.APIGatewayProxyResponse{Body: "user " + verifyRequest.Email + " has no magento password", StatusCode: http.StatusNotFound}, nil
+	}
+	if err != nil {
+		fmt.Println("Error returned by VerifyMagentoPassword function: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	marshaledResult, err := json.Marshal(verification)
+	if err != nil {
+		fmt.Println("Error on marshal verification: ", err.Error())
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: http.StatusInternalServerError}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshaledResult), StatusCode: http.StatusOK}, nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(VerifyPassword)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"golang.org/x/crypto/argon2"
+
+	fakes "migration-m2-gama/fakes"
+	services "migration-m2-gama/services"
+)
+
+const password = "Secreto123"
+
+// argon2Hash is the libsodium crypto_pwhash of magento with the interactive limits, salt has 16 bytes
+func argon2Hash(data string, salt string) string {
+	return hex.EncodeToString(argon2.IDKey([]byte(data), []byte(salt), 2, 65536, 1, 32))
+}
+
+func saveHash(t *testing.T, env *fakes.Environment, email string, passwordHash string) {
+	t.Helper()
+	err := env.Store.SaveHash(services.UserHash{Email: email, Hash: base64.StdEncoding.EncodeToString([]byte(passwordHash))})
+	if err != nil {
+		t.Fatalf("SaveHash(%s): %v", email, err)
+	}
+}
+
+func verify(t *testing.T, email string, password string) (int, services.PasswordVerification) {
+	t.Helper()
+	body, _ := json.Marshal(VerifyRequest{Email: email, Password: password})
+	response, err := VerifyPassword(events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: string(body)})
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+
+	verification := services.PasswordVerification{}
+	if response.StatusCode == http.StatusOK {
+		if err := json.Unmarshal([]byte(response.Body), &verification); err != nil {
+			t.Fatalf("unmarshal handler body %s: %v", response.Body, err)
+		}
+	}
+	return response.StatusCode, verification
+}
+
+func TestVerifyPasswordReadsEveryMagentoHashVersion(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	longSalt := "k3Jm9QzR1vT8wLp2Yx6Nb4Hc7Gd5Fs0A"
+	hashes := map[string]string{
+		"legacy@example.com":    "7c266567098ccff6de7966bd510ea1b4",
+		"md5@example.com":       "61c5f640d0c22b1c3d0d8fcfad6ea645:Xy7pQ2",
+		"md5v0@example.com":     "61c5f640d0c22b1c3d0d8fcfad6ea645:Xy7pQ2:0",
+		"sha256@example.com":    "4ea5bf148556dd9d7bb4c84d5f75278519bfe3d237a7297c5354de16e84662e1:Xy7pQ2:1",
+		"argon2@example.com":    argon2Hash(password, longSalt[:16]) + ":" + longSalt + ":2",
+		"agnostic@example.com":  argon2Hash(password, longSalt[:16]) + ":" + longSalt + ":3_32_2_67108864",
+		"upgraded@example.com":  argon2Hash("61c5f640d0c22b1c3d0d8fcfad6ea645", "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:0:2",
+		"upgraded1@example.com": argon2Hash("4ea5bf148556dd9d7bb4c84d5f75278519bfe3d237a7297c5354de16e84662e1", "Xy7pQ2Xy7pQ2Xy7p") + ":Xy7pQ2:1:3_32_2_67108864",
+	}
+	for email, passwordHash := range hashes {
+		saveHash(t, env, email, passwordHash)
+	}
+
+	current := map[string]bool{"argon2@example.com": true, "agnostic@example.com": true}
+
+	for email := range hashes {
+		status, verification := verify(t, email, password)
+		if status != http.StatusOK || verification.Email != email || !verification.Valid || verification.Rehash == current[email] {
+			t.Errorf("verify %s = %d %+v, want a valid password to rehash unless the hash is a single argon2id13", email, status, verification)
+		}
+
+		status, verification = verify(t, email, "secreto123")
+		if status != http.StatusOK || verification.Valid || verification.Rehash {
+			t.Errorf("verify %s with a wrong password = %d %+v, want an invalid password", email, status, verification)
+		}
+	}
+}
+
+func TestVerifyPasswordRehashesPendingHashes(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	salt := "k3Jm9QzR1vT8wLp2"
+	passwordHash := base64.StdEncoding.EncodeToString([]byte(argon2Hash(password, salt) + ":" + salt + ":2"))
+	if err := env.Store.SaveHash(services.UserHash{Email: "pending@example.com", Hash: passwordHash, Pending: true}); err != nil {
+		t.Fatalf("SaveHash: %v", err)
+	}
+
+	status, verification := verify(t, "pending@example.com", password)
+	if status != http.StatusOK || !verification.Valid || !verification.Rehash {
+		t.Errorf("verify = %d %+v, want the hash that GAMA doesn't have to rehash", status, verification)
+	}
+}
+
+func TestVerifyPasswordRejectsUnknownUsersAndInvalidHashes(t *testing.T) {
+	env := fakes.NewEnvironment(t)
+	saveHash(t, env, "unknown-version@example.com", "61c5f640d0c22b1c3d0d8fcfad6ea645:Xy7pQ2:9")
+	saveHash(t, env, "bad-argon2@example.com", "61c5f640d0c22b1c3d0d8fcfad6ea645:Xy7pQ2:3_32_x_67108864")
+	if err := env.Store.SaveHash(services.UserHash{Email: "not-base64@example.com", Hash: "%%%"}); err != nil {
+		t.Fatalf("SaveHash: %v", err)
+	}
+
+	if status, _ := verify(t, "nobody@example.com", password); status != http.StatusNotFound {
+		t.Errorf("verify of a user without hash = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := verify(t, "", password); status != http.StatusBadRequest {
+		t.Errorf("verify without email = %d, want %d", status, http.StatusBadRequest)
+	}
+	for _, email := range []string{"unknown-version@example.com", "bad-argon2@example.com", "not-base64@example.com"} {
+		if status, _ := verify(t, email, password); status != http.StatusInternalServerError {
+			t.Errorf("verify %s = %d, want %d", email, status, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	services "migration-m2-gama/services"
+)
+
+var stage string //this var is assigned from make file on build command
+
+// ProcessJobs receives the ids of the jobs created by POST /users
+func ProcessJobs(ctx context.Context, event events.SQSEvent) error {
+	for _, message := range event.Records {
+		err := services.ProcessJob(ctx, message.Body)
+		if err != nil {
+			fmt.Println("Error returned by ProcessJob function: ", err.Error())
+		}
+	}
+	return nil
+}
+
+func main() {
+	err := services.DefineEnv(stage)
+	if err == nil {
+		lambda.Start(ProcessJobs)
+	} else {
+		fmt.Println("Error stage (" + stage + ") not recognized: ")
+	}
+}